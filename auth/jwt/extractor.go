@@ -0,0 +1,67 @@
+package jwt
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// token 的来源.
+const (
+	TokenSourceHeader = "header"
+	TokenSourceQuery  = "query"
+	TokenSourceCookie = "cookie"
+	TokenSourceCustom = "custom"
+)
+
+// TokenExtractor 定义从请求中提取 token 的方式.
+type TokenExtractor struct {
+	// Source token 的来源. 用于指标等场景区分 token 的位置.
+	Source string
+
+	// Extract 提取 token 字符串. 不存在时返回空字符串.
+	Extract func(*gin.Context) string
+}
+
+// HeaderExtractor 从请求头中提取 token.
+// prefix 不为空时要求请求头的值为 "prefix token" 的形式, 例如 "Bearer xxxx".
+func HeaderExtractor(header, prefix string) TokenExtractor {
+	return TokenExtractor{
+		Source: TokenSourceHeader,
+		Extract: func(c *gin.Context) string {
+			v := c.GetHeader(header)
+			if prefix == "" {
+				return v
+			}
+			tokenStr, ok := strings.CutPrefix(v, prefix+" ")
+			if !ok {
+				return ""
+			}
+			return tokenStr
+		},
+	}
+}
+
+// QueryExtractor 从查询参数中提取 token.
+func QueryExtractor(name string) TokenExtractor {
+	return TokenExtractor{
+		Source: TokenSourceQuery,
+		Extract: func(c *gin.Context) string {
+			return c.Query(name)
+		},
+	}
+}
+
+// CookieExtractor 从 cookie 中提取 token.
+func CookieExtractor(name string) TokenExtractor {
+	return TokenExtractor{
+		Source: TokenSourceCookie,
+		Extract: func(c *gin.Context) string {
+			v, err := c.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return v
+		},
+	}
+}
@@ -0,0 +1,153 @@
+package jwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExtractor(t *testing.T) {
+	tests := []struct {
+		name       string
+		extractor  TokenExtractor
+		reqBuilder func(t *testing.T) *http.Request
+		wantSource string
+		want       string
+	}{
+		{
+			name:      "header_with_prefix",
+			extractor: HeaderExtractor("authorization", "Bearer"),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("authorization", "Bearer token")
+				return req
+			},
+			wantSource: TokenSourceHeader,
+			want:       "token",
+		},
+		{
+			// 前缀不匹配
+			name:      "header_prefix_mismatch",
+			extractor: HeaderExtractor("authorization", "Bearer"),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("authorization", "Basic token")
+				return req
+			},
+			wantSource: TokenSourceHeader,
+			want:       "",
+		},
+		{
+			name:      "header_without_prefix",
+			extractor: HeaderExtractor("x-token", ""),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("x-token", "token")
+				return req
+			},
+			wantSource: TokenSourceHeader,
+			want:       "token",
+		},
+		{
+			name:      "query",
+			extractor: QueryExtractor("access_token"),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/?access_token=token", nil)
+				require.NoError(t, err)
+				return req
+			},
+			wantSource: TokenSourceQuery,
+			want:       "token",
+		},
+		{
+			name:      "cookie",
+			extractor: CookieExtractor("access_token"),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: "token"})
+				return req
+			},
+			wantSource: TokenSourceCookie,
+			want:       "token",
+		},
+		{
+			name:      "cookie_not_found",
+			extractor: CookieExtractor("access_token"),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				return req
+			},
+			wantSource: TokenSourceCookie,
+			want:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.reqBuilder(t)
+			assert.Equal(t, tt.wantSource, tt.extractor.Source)
+			assert.Equal(t, tt.want, tt.extractor.Extract(c))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_SetExtractors(t *testing.T) {
+	m := NewMiddlewareBuilder[Claims](tokenManager).SetExtractors(
+		HeaderExtractor("authorization", "Bearer"),
+		QueryExtractor("access_token"),
+	)
+	tests := []struct {
+		name       string
+		reqBuilder func(t *testing.T) *http.Request
+		want       string
+		wantSource string
+	}{
+		{
+			name: "header_first",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/?access_token=query", nil)
+				require.NoError(t, err)
+				req.Header.Add("authorization", "Bearer header")
+				return req
+			},
+			want:       "header",
+			wantSource: TokenSourceHeader,
+		},
+		{
+			name: "fallback_to_query",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/?access_token=query", nil)
+				require.NoError(t, err)
+				return req
+			},
+			want:       "query",
+			wantSource: TokenSourceQuery,
+		},
+		{
+			name: "not_found",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+				return req
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.reqBuilder(t)
+			got, source := m.extract(c)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/udugong/token"
+)
+
+// Actor 定义代表主体执行操作的参与者.
+// 嵌套的 Act 为更早的参与者.
+// 详见 https://datatracker.ietf.org/doc/html/rfc8693#section-4.1
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
+// String 返回参与者链, 例如 "staff-1 -> admin-1".
+func (a *Actor) String() string {
+	var subs []string
+	for cur := a; cur != nil; cur = cur.Act {
+		subs = append(subs, cur.Subject)
+	}
+	return strings.Join(subs, " -> ")
+}
+
+// ActorClaims 定义 act claim.
+// 可以嵌入到自定义的 Claims 中以支持模拟用户.
+type ActorClaims struct {
+	Act *Actor `json:"act,omitempty"`
+}
+
+// GetActor 获取参与者. 不是模拟用户时返回 nil.
+func (c ActorClaims) GetActor() *Actor {
+	return c.Act
+}
+
+// SetActor 设置参与者.
+func (c *ActorClaims) SetActor(actor *Actor) {
+	c.Act = actor
+}
+
+// Impersonate 使用资源令牌管理器为 clm 的主体签发模拟用户的 token.
+// actor 为实际执行操作的参与者, 例如客服人员. clm 中已有的参与者会嵌套在 actor 之后.
+//
+//	token, err := Impersonate[Claims](accessTM, customerClaims, Actor{Subject: "staff-1"})
+func Impersonate[T jwt.Claims, PT interface {
+	*T
+	GetActor() *Actor
+	SetActor(*Actor)
+}](accessTM token.Manager[T], clm T, actor Actor) (string, error) {
+	p := PT(&clm)
+	actor.Act = p.GetActor()
+	p.SetActor(&actor)
+	return accessTM.GenerateToken(clm)
+}
+
+// actorKey 定义从 context.Context 中设置/获取参与者的 key.
+type actorKey struct{}
+
+// ContextWithActor 为参与者创建 context.
+func ContextWithActor(ctx context.Context, actor *Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 从 context 中获取参与者.
+// 不是模拟用户的请求返回 false.
+func ActorFromContext(ctx context.Context) (*Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(*Actor)
+	return actor, ok && actor != nil
+}
+
+// ImpersonationBuilder 定义模拟用户的中间件构建器.
+// 需要在认证中间件之后使用. 对于模拟用户的请求:
+//   - 把参与者设置到 context.Context 中, 通过 ActorFromContext 获取.
+//   - 使用 policy 判断是否允许访问.
+//   - 请求结束后记录审计日志.
+//
+// 实际生效的主体仍然通过 ClaimsFromContext 获取.
+type ImpersonationBuilder[T interface {
+	jwt.Claims
+	GetActor() *Actor
+}] struct {
+	// getClaims 获取 Claims.
+	// 默认使用 ClaimsFromContext[T] 获取.
+	getClaims func(*gin.Context) (T, bool)
+
+	// policy 判断是否允许模拟用户访问.
+	// 默认全部允许.
+	policy func(c *gin.Context, actor *Actor) bool
+
+	// logger 记录审计日志.
+	// 默认使用 slog.Default().
+	logger *slog.Logger
+}
+
+// NewImpersonationBuilder 创建一个模拟用户的中间件构建器.
+func NewImpersonationBuilder[T interface {
+	jwt.Claims
+	GetActor() *Actor
+}]() *ImpersonationBuilder[T] {
+	return &ImpersonationBuilder[T]{
+		getClaims: func(c *gin.Context) (T, bool) {
+			return ClaimsFromContext[T](c.Request.Context())
+		},
+		policy: func(*gin.Context, *Actor) bool {
+			return true
+		},
+		logger: slog.Default(),
+	}
+}
+
+// SetGetClaimsFunc 设置获取 Claims 的方法.
+// 需要与认证中间件中设置 Claims 的方式匹配.
+func (b *ImpersonationBuilder[T]) SetGetClaimsFunc(fn func(*gin.Context) (T, bool)) *ImpersonationBuilder[T] {
+	b.getClaims = fn
+	return b
+}
+
+// SetPolicyFunc 设置判断是否允许模拟用户访问的方法.
+// 不允许时返回 403.
+func (b *ImpersonationBuilder[T]) SetPolicyFunc(fn func(c *gin.Context, actor *Actor) bool) *ImpersonationBuilder[T] {
+	b.policy = fn
+	return b
+}
+
+// SetLogger 设置记录审计日志的 slog.Logger.
+func (b *ImpersonationBuilder[T]) SetLogger(logger *slog.Logger) *ImpersonationBuilder[T] {
+	b.logger = logger
+	return b
+}
+
+// Build 构建模拟用户的中间件.
+func (b *ImpersonationBuilder[T]) Build() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clm, ok := b.getClaims(c)
+		if !ok {
+			return
+		}
+		actor := clm.GetActor()
+		if actor == nil {
+			return
+		}
+		c.Request = c.Request.WithContext(ContextWithActor(c.Request.Context(), actor))
+
+		defer b.audit(c, clm, actor)
+		if !b.policy(c, actor) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Forbid 构建禁止模拟用户访问的中间件, 用于敏感路由.
+// 模拟用户的请求返回 403, 并记录审计日志.
+func (b *ImpersonationBuilder[T]) Forbid() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clm, ok := b.getClaims(c)
+		if !ok {
+			return
+		}
+		actor := clm.GetActor()
+		if actor == nil {
+			return
+		}
+		defer b.audit(c, clm, actor)
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+// audit 记录模拟用户请求的审计日志.
+func (b *ImpersonationBuilder[T]) audit(c *gin.Context, clm T, actor *Actor) {
+	subject, _ := clm.GetSubject()
+	b.logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "模拟用户请求",
+		slog.String("subject", subject),
+		slog.String("actor", actor.String()),
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.String("full_path", c.FullPath()),
+		slog.String("client_ip", c.ClientIP()),
+		slog.Int("status", c.Writer.Status()),
+	)
+}
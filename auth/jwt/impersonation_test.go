@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+)
+
+type actorClaims struct {
+	jwtcore.RegisteredClaims
+	ActorClaims
+}
+
+func TestImpersonate(t *testing.T) {
+	tm := jwtcore.NewTokenManager[actorClaims]("sign key", 10*time.Minute)
+	tests := []struct {
+		name      string
+		clm       actorClaims
+		actor     Actor
+		wantActor string
+	}{
+		{
+			name:      "normal",
+			clm:       actorClaims{RegisteredClaims: jwtcore.RegisteredClaims{Subject: "customer-1"}},
+			actor:     Actor{Subject: "staff-1"},
+			wantActor: "staff-1",
+		},
+		{
+			// 嵌套已有的参与者
+			name: "nested",
+			clm: actorClaims{
+				RegisteredClaims: jwtcore.RegisteredClaims{Subject: "customer-1"},
+				ActorClaims:      ActorClaims{Act: &Actor{Subject: "admin-1"}},
+			},
+			actor:     Actor{Subject: "staff-1"},
+			wantActor: "staff-1 -> admin-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenStr, err := Impersonate[actorClaims](tm, tt.clm, tt.actor)
+			require.NoError(t, err)
+			clm, err := tm.VerifyToken(tokenStr)
+			require.NoError(t, err)
+			assert.Equal(t, "customer-1", clm.Subject)
+			assert.Equal(t, tt.wantActor, clm.GetActor().String())
+		})
+	}
+}
+
+func TestImpersonationBuilder_Build(t *testing.T) {
+	impersonated := actorClaims{
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "customer-1"},
+		ActorClaims:      ActorClaims{Act: &Actor{Subject: "staff-1"}},
+	}
+	tests := []struct {
+		name      string
+		clm       *actorClaims
+		policy    func(*gin.Context, *Actor) bool
+		wantCode  int
+		wantActor string
+		wantAudit bool
+	}{
+		{
+			name:      "impersonated",
+			clm:       &impersonated,
+			wantCode:  http.StatusOK,
+			wantActor: "staff-1",
+			wantAudit: true,
+		},
+		{
+			// 策略不允许模拟用户
+			name: "policy_denied",
+			clm:  &impersonated,
+			policy: func(c *gin.Context, actor *Actor) bool {
+				return actor.Subject != "staff-1"
+			},
+			wantCode:  http.StatusForbidden,
+			wantAudit: true,
+		},
+		{
+			name: "not_impersonated",
+			clm: &actorClaims{
+				RegisteredClaims: jwtcore.RegisteredClaims{Subject: "customer-1"},
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no_claims",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			b := NewImpersonationBuilder[actorClaims]().
+				SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+			if tt.policy != nil {
+				b.SetPolicyFunc(tt.policy)
+			}
+			server := gin.New()
+			server.Use(func(c *gin.Context) {
+				if tt.clm != nil {
+					c.Request = c.Request.WithContext(
+						ContextWithClaims(c.Request.Context(), *tt.clm))
+				}
+			}, b.Build())
+			server.GET("/orders/:id", func(c *gin.Context) {
+				actor, ok := ActorFromContext(c.Request.Context())
+				if ok {
+					c.String(http.StatusOK, actor.String())
+					return
+				}
+				c.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequest(http.MethodGet, "/orders/1", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantActor, recorder.Body.String())
+
+			if !tt.wantAudit {
+				assert.Empty(t, buf.String())
+				return
+			}
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+			assert.Equal(t, "customer-1", record["subject"])
+			assert.Equal(t, "staff-1", record["actor"])
+			assert.Equal(t, "/orders/:id", record["full_path"])
+			assert.Equal(t, float64(tt.wantCode), record["status"])
+		})
+	}
+}
+
+func TestImpersonationBuilder_Forbid(t *testing.T) {
+	tests := []struct {
+		name     string
+		clm       actorClaims
+		wantCode  int
+		wantAudit bool
+	}{
+		{
+			name: "impersonated",
+			clm: actorClaims{
+				RegisteredClaims: jwtcore.RegisteredClaims{Subject: "customer-1"},
+				ActorClaims:      ActorClaims{Act: &Actor{Subject: "staff-1"}},
+			},
+			wantCode:  http.StatusForbidden,
+			wantAudit: true,
+		},
+		{
+			name:     "not_impersonated",
+			clm:      actorClaims{},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			b := NewImpersonationBuilder[actorClaims]().
+				SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+			server := gin.New()
+			server.Use(func(c *gin.Context) {
+				c.Request = c.Request.WithContext(
+					ContextWithClaims(c.Request.Context(), tt.clm))
+			})
+			server.POST("/email", b.Forbid(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequest(http.MethodPost, "/email", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+
+			if !tt.wantAudit {
+				assert.Empty(t, buf.String())
+				return
+			}
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+			assert.Equal(t, "customer-1", record["subject"])
+			assert.Equal(t, "staff-1", record["actor"])
+			assert.Equal(t, "/email", record["full_path"])
+			assert.Equal(t, float64(http.StatusForbidden), record["status"])
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package jwt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/udugong/token"
+)
+
+// token_type_hint 的取值.
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+var (
+	// ErrInactiveToken 远程内省端点返回 token 无效.
+	ErrInactiveToken = errors.New("token 无效")
+	// ErrGenerateNotSupported 不支持生成 token.
+	ErrGenerateNotSupported = errors.New("不支持生成 token")
+	// ErrVerifierUnavailable 校验 token 的服务不可用, 例如远程内省端点超时或者返回 5xx.
+	// 此时无法判断 token 是否有效, MiddlewareBuilder 返回 503 而不是 401.
+	ErrVerifierUnavailable = errors.New("校验 token 的服务不可用")
+)
+
+// ClientAuthenticator 认证调用方客户端.
+// 认证通过时返回 true.
+type ClientAuthenticator func(ctx context.Context, clientID, clientSecret string) bool
+
+// IntrospectionResponse 定义 token 内省的响应.
+// 详见 https://datatracker.ietf.org/doc/html/rfc7662#section-2.2
+//
+// 实现了 jwt.Claims 接口, 因此远程内省的结果可以作为 claims 使用.
+type IntrospectionResponse struct {
+	Active    bool             `json:"active"`
+	Scope     string           `json:"scope,omitempty"`
+	ClientID  string           `json:"client_id,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	TokenType string           `json:"token_type,omitempty"`
+	Exp       int64            `json:"exp,omitempty"`
+	Iat       int64            `json:"iat,omitempty"`
+	Nbf       int64            `json:"nbf,omitempty"`
+	Sub       string           `json:"sub,omitempty"`
+	Aud       jwt.ClaimStrings `json:"aud,omitempty"`
+	Iss       string           `json:"iss,omitempty"`
+	Jti       string           `json:"jti,omitempty"`
+}
+
+// GetExpirationTime implements the Claims interface.
+func (r IntrospectionResponse) GetExpirationTime() (*jwt.NumericDate, error) {
+	return unixToNumericDate(r.Exp), nil
+}
+
+// GetNotBefore implements the Claims interface.
+func (r IntrospectionResponse) GetNotBefore() (*jwt.NumericDate, error) {
+	return unixToNumericDate(r.Nbf), nil
+}
+
+// GetIssuedAt implements the Claims interface.
+func (r IntrospectionResponse) GetIssuedAt() (*jwt.NumericDate, error) {
+	return unixToNumericDate(r.Iat), nil
+}
+
+// GetAudience implements the Claims interface.
+func (r IntrospectionResponse) GetAudience() (jwt.ClaimStrings, error) {
+	return r.Aud, nil
+}
+
+// GetIssuer implements the Claims interface.
+func (r IntrospectionResponse) GetIssuer() (string, error) {
+	return r.Iss, nil
+}
+
+// GetSubject implements the Claims interface.
+func (r IntrospectionResponse) GetSubject() (string, error) {
+	return r.Sub, nil
+}
+
+// IntrospectionBuilder 定义 token 内省端点的构建器.
+type IntrospectionBuilder[T jwt.Claims] struct {
+	// accessTM 资源令牌管理.
+	accessTM token.Manager[T]
+
+	// refreshTM 刷新令牌管理. 为 nil 时只内省资源令牌.
+	refreshTM token.Manager[T]
+
+	// authenticateClient 认证调用方客户端.
+	authenticateClient ClientAuthenticator
+
+	// isRevoked 判断 token 是否已被吊销.
+	// 默认使用 func(*gin.Context, T) bool { return false } 也就是全部未吊销.
+	isRevoked func(*gin.Context, T) bool
+
+	// setResponse 根据 claims 补充响应, 例如 scope, client_id.
+	// 默认不补充.
+	setResponse func(T, *IntrospectionResponse)
+
+	// authorize 判断调用方客户端是否可以内省 token.
+	// 默认使用 func(*gin.Context, string, T) bool { return true } 也就是全部允许.
+	authorize func(c *gin.Context, clientID string, clm T) bool
+}
+
+// NewIntrospectionBuilder 创建一个 token 内省端点的构建器.
+// refreshTM 可以为 nil.
+func NewIntrospectionBuilder[T jwt.Claims](accessTM, refreshTM token.Manager[T],
+	authenticator ClientAuthenticator) *IntrospectionBuilder[T] {
+	return &IntrospectionBuilder[T]{
+		accessTM:           accessTM,
+		refreshTM:          refreshTM,
+		authenticateClient: authenticator,
+		isRevoked: func(*gin.Context, T) bool {
+			return false
+		},
+		setResponse: func(T, *IntrospectionResponse) {},
+		authorize: func(*gin.Context, string, T) bool {
+			return true
+		},
+	}
+}
+
+// SetRevokedFunc 设置判断 token 是否已被吊销的方法.
+// 已被吊销的 token 视为无效.
+func (b *IntrospectionBuilder[T]) SetRevokedFunc(fn func(*gin.Context, T) bool) *IntrospectionBuilder[T] {
+	b.isRevoked = fn
+	return b
+}
+
+// SetResponseFunc 设置根据 claims 补充响应的方法.
+func (b *IntrospectionBuilder[T]) SetResponseFunc(fn func(T, *IntrospectionResponse)) *IntrospectionBuilder[T] {
+	b.setResponse = fn
+	return b
+}
+
+// SetAuthorizeFunc 设置判断调用方客户端是否可以内省 token 的方法, 例如只允许 token 的受众内省.
+// 不允许时与无效的 token 相同, 返回 {"active": false}, 避免泄露 token 的信息.
+// 详见 https://datatracker.ietf.org/doc/html/rfc7662#section-4
+func (b *IntrospectionBuilder[T]) SetAuthorizeFunc(fn func(c *gin.Context, clientID string, clm T) bool) *IntrospectionBuilder[T] {
+	b.authorize = fn
+	return b
+}
+
+// Build 构建 token 内省端点的 gin.HandlerFunc.
+// 请求为 application/x-www-form-urlencoded 格式, 包含 token 与可选的 token_type_hint.
+func (b *IntrospectionBuilder[T]) Build() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret, ok := clientCredentials(c)
+		if !ok || !b.authenticateClient(c.Request.Context(), clientID, clientSecret) {
+			c.Header("WWW-Authenticate", `Basic realm="introspect"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, OAuthError{Error: ErrCodeInvalidClient})
+			return
+		}
+
+		tokenStr := c.PostForm("token")
+		if tokenStr == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{Error: ErrCodeInvalidRequest})
+			return
+		}
+
+		for _, tm := range b.managers(c.PostForm("token_type_hint")) {
+			clm, err := tm.VerifyToken(tokenStr)
+			if err != nil {
+				continue
+			}
+			if b.isRevoked(c, clm) || !b.authorize(c, clientID, clm) {
+				break
+			}
+			c.JSON(http.StatusOK, b.response(clm))
+			return
+		}
+		c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+	}
+}
+
+// managers 根据 token_type_hint 返回校验 token 的顺序.
+func (b *IntrospectionBuilder[T]) managers(hint string) []token.Manager[T] {
+	if b.refreshTM == nil {
+		return []token.Manager[T]{b.accessTM}
+	}
+	if hint == TokenTypeHintRefreshToken {
+		return []token.Manager[T]{b.refreshTM, b.accessTM}
+	}
+	return []token.Manager[T]{b.accessTM, b.refreshTM}
+}
+
+func (b *IntrospectionBuilder[T]) response(clm T) IntrospectionResponse {
+	var resp IntrospectionResponse
+	// 通过 JSON 获取 claims 中与响应同名的字段, 例如 jti, scope, client_id.
+	var fields struct {
+		Scope    string `json:"scope"`
+		ClientID string `json:"client_id"`
+		Username string `json:"username"`
+		Jti      string `json:"jti"`
+	}
+	if data, err := json.Marshal(clm); err == nil {
+		_ = json.Unmarshal(data, &fields)
+	}
+	resp.Active = true
+	resp.Scope = fields.Scope
+	resp.ClientID = fields.ClientID
+	resp.Username = fields.Username
+	resp.Jti = fields.Jti
+	if exp, _ := clm.GetExpirationTime(); exp != nil {
+		resp.Exp = exp.Unix()
+	}
+	if iat, _ := clm.GetIssuedAt(); iat != nil {
+		resp.Iat = iat.Unix()
+	}
+	if nbf, _ := clm.GetNotBefore(); nbf != nil {
+		resp.Nbf = nbf.Unix()
+	}
+	resp.Sub, _ = clm.GetSubject()
+	resp.Aud, _ = clm.GetAudience()
+	resp.Iss, _ = clm.GetIssuer()
+	b.setResponse(clm, &resp)
+	return resp
+}
+
+// RemoteIntrospector 通过远程内省端点校验 token.
+// 实现了 token.Manager[IntrospectionResponse] 接口, 可以用于创建 MiddlewareBuilder:
+//
+//	NewMiddlewareBuilder[IntrospectionResponse](NewRemoteIntrospector(endpoint, id, secret))
+type RemoteIntrospector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+
+	// client 调用内省端点的 http.Client.
+	// 默认超时时间为 5 秒.
+	client *http.Client
+
+	// cacheTTL 缓存内省结果的最长时间. 为 0 时不缓存.
+	// 缓存时间不会超过 token 的过期时间. 默认为 1 分钟.
+	cacheTTL time.Duration
+
+	// negativeCacheTTL 缓存无效结果的时间. 为 0 时不缓存. 默认为 10 秒.
+	negativeCacheTTL time.Duration
+
+	// maxCacheEntries 最多缓存的 token 数量. 默认为 10000.
+	maxCacheEntries int
+
+	timeFunc func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]introspectionCacheEntry
+}
+
+type introspectionCacheEntry struct {
+	resp     IntrospectionResponse
+	expireAt time.Time
+}
+
+// NewRemoteIntrospector 创建一个远程内省校验器.
+// clientID 与 clientSecret 通过 Basic 认证发送给内省端点.
+func NewRemoteIntrospector(endpoint, clientID, clientSecret string) *RemoteIntrospector {
+	return &RemoteIntrospector{
+		endpoint:         endpoint,
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		client:           &http.Client{Timeout: 5 * time.Second},
+		cacheTTL:         time.Minute,
+		negativeCacheTTL: 10 * time.Second,
+		maxCacheEntries:  10000,
+		timeFunc:         time.Now,
+		cache:            make(map[string]introspectionCacheEntry),
+	}
+}
+
+// SetHTTPClient 设置调用内省端点的 http.Client.
+func (r *RemoteIntrospector) SetHTTPClient(client *http.Client) *RemoteIntrospector {
+	r.client = client
+	return r
+}
+
+// SetCache 设置缓存内省结果的最长时间与最多缓存的 token 数量.
+// ttl 为 0 时不缓存.
+func (r *RemoteIntrospector) SetCache(ttl time.Duration, maxEntries int) *RemoteIntrospector {
+	r.cacheTTL = ttl
+	r.maxCacheEntries = maxEntries
+	return r
+}
+
+// SetNegativeCache 设置缓存无效结果的时间. ttl 为 0 时不缓存.
+// 缓存无效结果可以避免无效的 token 每次都调用内省端点, 与有效结果共用最多缓存的 token 数量.
+func (r *RemoteIntrospector) SetNegativeCache(ttl time.Duration) *RemoteIntrospector {
+	r.negativeCacheTTL = ttl
+	return r
+}
+
+// GenerateToken 不支持生成 token, 总是返回 ErrGenerateNotSupported.
+func (r *RemoteIntrospector) GenerateToken(IntrospectionResponse) (string, error) {
+	return "", ErrGenerateNotSupported
+}
+
+// VerifyToken 调用远程内省端点校验 token.
+// token 无效时返回 ErrInactiveToken; 内省端点无法访问或者没有返回 200 时返回 ErrVerifierUnavailable.
+func (r *RemoteIntrospector) VerifyToken(tokenStr string) (IntrospectionResponse, error) {
+	key := cacheKey(tokenStr)
+	if resp, ok := r.getCache(key); ok {
+		if !resp.Active {
+			return IntrospectionResponse{}, ErrInactiveToken
+		}
+		return resp, nil
+	}
+
+	resp, err := r.introspect(tokenStr)
+	if err != nil {
+		return IntrospectionResponse{}, err
+	}
+	r.setCache(key, resp)
+	if !resp.Active {
+		return IntrospectionResponse{}, ErrInactiveToken
+	}
+	return resp, nil
+}
+
+func (r *RemoteIntrospector) introspect(tokenStr string) (IntrospectionResponse, error) {
+	var resp IntrospectionResponse
+	form := url.Values{}
+	form.Set("token", tokenStr)
+	form.Set("token_type_hint", TokenTypeHintAccessToken)
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(r.clientID), url.QueryEscape(r.clientSecret))
+
+	httpResp, err := r.client.Do(req)
+	if err != nil {
+		return resp, fmt.Errorf("%w: %w", ErrVerifierUnavailable, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		// 无效的 token 同样返回 200, 其他状态码说明内省端点不可用,
+		// 例如调用方的客户端凭证错误或者已轮换时返回 401, 不能视为 token 无效
+		err = fmt.Errorf("%w: 内省端点响应异常: %d", ErrVerifierUnavailable, httpResp.StatusCode)
+		log.Println(err)
+		return resp, err
+	}
+	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("%w: %w", ErrVerifierUnavailable, err)
+	}
+	return resp, nil
+}
+
+func (r *RemoteIntrospector) getCache(key string) (IntrospectionResponse, bool) {
+	if r.cacheTTL <= 0 && r.negativeCacheTTL <= 0 {
+		return IntrospectionResponse{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[key]
+	if !ok {
+		return IntrospectionResponse{}, false
+	}
+	if !r.timeFunc().Before(entry.expireAt) {
+		delete(r.cache, key)
+		return IntrospectionResponse{}, false
+	}
+	return entry.resp, true
+}
+
+func (r *RemoteIntrospector) setCache(key string, resp IntrospectionResponse) {
+	ttl := r.cacheTTL
+	if !resp.Active {
+		ttl = r.negativeCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := r.timeFunc()
+	expireAt := now.Add(ttl)
+	if resp.Exp > 0 {
+		if exp := time.Unix(resp.Exp, 0); exp.Before(expireAt) {
+			expireAt = exp
+		}
+	}
+	if !now.Before(expireAt) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= r.maxCacheEntries {
+		// 清理过期的缓存, 仍然已满时不缓存
+		for k, v := range r.cache {
+			if !now.Before(v.expireAt) {
+				delete(r.cache, k)
+			}
+		}
+		if len(r.cache) >= r.maxCacheEntries {
+			return
+		}
+	}
+	r.cache[key] = introspectionCacheEntry{resp: resp, expireAt: expireAt}
+}
+
+// cacheKey 避免在内存中保存 token 原文.
+func cacheKey(tokenStr string) string {
+	sum := sha256.Sum256([]byte(tokenStr))
+	return hex.EncodeToString(sum[:])
+}
+
+func unixToNumericDate(sec int64) *jwt.NumericDate {
+	if sec == 0 {
+		return nil
+	}
+	return jwt.NewNumericDate(time.Unix(sec, 0))
+}
@@ -0,0 +1,360 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+)
+
+func TestIntrospectionBuilder_Build(t *testing.T) {
+	nowTime := time.Now()
+	accessTM := jwtcore.NewTokenManager[Claims]("access key", 10*time.Minute,
+		jwtcore.WithTimeFunc[Claims](func() time.Time { return nowTime }),
+		jwtcore.WithIssuer[Claims]("ginx"))
+	refreshTM := jwtcore.NewTokenManager[Claims]("refresh key", time.Hour,
+		jwtcore.WithTimeFunc[Claims](func() time.Time { return nowTime }))
+	accessToken, err := accessTM.GenerateToken(Claims{Uid: 1,
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "1", ID: "jti"}})
+	require.NoError(t, err)
+	refreshToken, err := refreshTM.GenerateToken(Claims{Uid: 1,
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "1"}})
+	require.NoError(t, err)
+	authenticator := func(_ context.Context, clientID, clientSecret string) bool {
+		return clientID == "gateway" && clientSecret == "secret"
+	}
+
+	tests := []struct {
+		name       string
+		builder    *IntrospectionBuilder[Claims]
+		reqBuilder func(t *testing.T) *http.Request
+		wantCode   int
+		wantBody   any
+	}{
+		{
+			name:    "access_token",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {accessToken}})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{
+				Active: true,
+				Sub:    "1",
+				Iss:    "ginx",
+				Jti:    "jti",
+				Exp:    nowTime.Add(10 * time.Minute).Unix(),
+				Iat:    nowTime.Unix(),
+			},
+		},
+		{
+			// 使用 token_type_hint 与表单中的客户端凭证
+			name: "refresh_token_with_hint",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator).
+				SetResponseFunc(func(clm Claims, resp *IntrospectionResponse) {
+					resp.ClientID = "web"
+					resp.TokenType = "refresh_token"
+				}),
+			reqBuilder: func(t *testing.T) *http.Request {
+				return newFormRequest(t, url.Values{
+					"token":           {refreshToken},
+					"token_type_hint": {TokenTypeHintRefreshToken},
+					"client_id":       {"gateway"},
+					"client_secret":   {"secret"},
+				})
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{
+				Active:    true,
+				Sub:       "1",
+				ClientID:  "web",
+				TokenType: "refresh_token",
+				Exp:       nowTime.Add(time.Hour).Unix(),
+				Iat:       nowTime.Unix(),
+			},
+		},
+		{
+			// 没有刷新令牌管理时刷新令牌无效
+			name:    "refresh_token_without_refresh_manager",
+			builder: NewIntrospectionBuilder[Claims](accessTM, nil, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {refreshToken}})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{Active: false},
+		},
+		{
+			name: "revoked",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator).
+				SetRevokedFunc(func(*gin.Context, Claims) bool { return true }),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {accessToken}})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{Active: false},
+		},
+		{
+			// 调用方不能内省该 token
+			name: "unauthorized_caller",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator).
+				SetAuthorizeFunc(func(_ *gin.Context, clientID string, clm Claims) bool {
+					return clientID == "gateway" && clm.Subject == "2"
+				}),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {accessToken}})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{Active: false},
+		},
+		{
+			name:    "bad_token",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {"bad_token"}})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantBody: IntrospectionResponse{Active: false},
+		},
+		{
+			name:    "missing_token",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{})
+				req.SetBasicAuth("gateway", "secret")
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: OAuthError{Error: ErrCodeInvalidRequest},
+		},
+		{
+			name:    "invalid_client",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				req := newFormRequest(t, url.Values{"token": {accessToken}})
+				req.SetBasicAuth("gateway", "bad secret")
+				return req
+			},
+			wantCode: http.StatusUnauthorized,
+			wantBody: OAuthError{Error: ErrCodeInvalidClient},
+		},
+		{
+			name:    "no_client_credentials",
+			builder: NewIntrospectionBuilder[Claims](accessTM, refreshTM, authenticator),
+			reqBuilder: func(t *testing.T) *http.Request {
+				return newFormRequest(t, url.Values{"token": {accessToken}})
+			},
+			wantCode: http.StatusUnauthorized,
+			wantBody: OAuthError{Error: ErrCodeInvalidClient},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gin.Default()
+			server.POST("/introspect", tt.builder.Build())
+
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, tt.reqBuilder(t))
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			want, err := json.Marshal(tt.wantBody)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), recorder.Body.String())
+		})
+	}
+}
+
+func TestRemoteIntrospector_VerifyToken(t *testing.T) {
+	nowTime := time.Now()
+	accessTM := jwtcore.NewTokenManager[Claims]("access key", 10*time.Minute,
+		jwtcore.WithTimeFunc[Claims](func() time.Time { return nowTime }))
+	accessToken, err := accessTM.GenerateToken(Claims{Uid: 1,
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "1"}})
+	require.NoError(t, err)
+
+	calls := 0
+	server := gin.New()
+	introspect := NewIntrospectionBuilder[Claims](accessTM, nil,
+		func(_ context.Context, clientID, clientSecret string) bool {
+			return clientID == "gateway" && clientSecret == "secret"
+		}).Build()
+	server.POST("/introspect", func(c *gin.Context) {
+		calls++
+		introspect(c)
+	})
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		r         *RemoteIntrospector
+		tokens    []string
+		wantErr   error
+		wantSub   string
+		wantCalls int
+	}{
+		{
+			// 命中缓存只调用一次
+			name:      "cached",
+			r:         NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret"),
+			tokens:    []string{accessToken, accessToken},
+			wantSub:   "1",
+			wantCalls: 1,
+		},
+		{
+			name: "no_cache",
+			r: NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret").
+				SetCache(0, 0),
+			tokens:    []string{accessToken, accessToken},
+			wantSub:   "1",
+			wantCalls: 2,
+		},
+		{
+			// 无效的 token 同样缓存
+			name:      "inactive",
+			r:         NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret"),
+			tokens:    []string{"bad_token", "bad_token"},
+			wantErr:   ErrInactiveToken,
+			wantCalls: 1,
+		},
+		{
+			name: "no_negative_cache",
+			r: NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret").
+				SetNegativeCache(0),
+			tokens:    []string{"bad_token", "bad_token"},
+			wantErr:   ErrInactiveToken,
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			for _, tokenStr := range tt.tokens {
+				resp, err := tt.r.VerifyToken(tokenStr)
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantSub, resp.Sub)
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+
+	t.Run("negative_cache_expired", func(t *testing.T) {
+		calls = 0
+		now := time.Now()
+		r := NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret").
+			SetNegativeCache(time.Second)
+		r.timeFunc = func() time.Time { return now }
+		_, err := r.VerifyToken("bad_token")
+		assert.ErrorIs(t, err, ErrInactiveToken)
+		now = now.Add(time.Second)
+		_, err = r.VerifyToken("bad_token")
+		assert.ErrorIs(t, err, ErrInactiveToken)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("invalid_client", func(t *testing.T) {
+		_, err := NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "bad").
+			VerifyToken(accessToken)
+		// 调用方的客户端凭证错误时内省端点不可用, 不能视为 token 无效
+		assert.ErrorIs(t, err, ErrVerifierUnavailable)
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		r := NewRemoteIntrospector(srv.URL+"/introspect", "gateway", "secret")
+		engine := gin.New()
+		engine.GET("/", NewMiddlewareBuilder[IntrospectionResponse](r).Build(),
+			func(c *gin.Context) {
+				clm, ok := ClaimsFromContext[IntrospectionResponse](c.Request.Context())
+				require.True(t, ok)
+				c.String(http.StatusOK, clm.Sub)
+			})
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		req.Header.Add(authorizationHeader, "Bearer "+accessToken)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "1", recorder.Body.String())
+	})
+}
+
+func TestIntrospectionResponse_Claims(t *testing.T) {
+	var clm jwt.Claims = IntrospectionResponse{Exp: 1695571800, Sub: "1"}
+	exp, err := clm.GetExpirationTime()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1695571800), exp.Unix())
+	nbf, err := clm.GetNotBefore()
+	require.NoError(t, err)
+	assert.Nil(t, nbf)
+	sub, err := clm.GetSubject()
+	require.NoError(t, err)
+	assert.Equal(t, "1", sub)
+}
+
+func newFormRequest(t *testing.T, form url.Values) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestRemoteIntrospector_Unavailable(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer unavailable.Close()
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+	}{
+		{name: "upstream_5xx", endpoint: unavailable.URL},
+		// 调用方的客户端凭证错误或者已轮换
+		{name: "upstream_401", endpoint: unauthorized.URL},
+		{name: "transport_error", endpoint: closed.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRemoteIntrospector(tt.endpoint, "gateway", "secret")
+			_, err := r.VerifyToken("token")
+			assert.ErrorIs(t, err, ErrVerifierUnavailable)
+
+			// 无法判断 token 是否有效时返回 503 而不是 401
+			engine := gin.New()
+			engine.GET("/", NewMiddlewareBuilder[IntrospectionResponse](r).Build(),
+				func(c *gin.Context) {
+					c.Status(http.StatusOK)
+				})
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			req.Header.Add(authorizationHeader, "Bearer token")
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, req)
+			assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		})
+	}
+}
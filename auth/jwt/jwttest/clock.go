@@ -0,0 +1,46 @@
+package jwttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Clock 可控制的时钟, 用于测试 token 过期.
+// 并发安全.
+type Clock struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+// NewClock 创建一个时间为 now 的时钟.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now 返回当前时间. 可以作为 jwtcore.WithTimeFunc 等的参数.
+func (c *Clock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now
+}
+
+// Advance 将时钟向前拨动 d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set 设置当前时间.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// After 返回 clock 当前时间加上 d 的 *jwt.NumericDate, 用于构造 claims 的 exp, nbf 等.
+func After(clock *Clock, d time.Duration) *jwt.NumericDate {
+	return jwt.NewNumericDate(clock.Now().Add(d))
+}
@@ -0,0 +1,113 @@
+// Package jwttest 提供测试 jwt 认证相关代码的工具.
+package jwttest
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/udugong/token"
+)
+
+var (
+	// ErrTokenNotFound token 不是由 Manager 生成的.
+	ErrTokenNotFound = errors.New("token 不存在")
+	// ErrTokenRevoked token 已被 Manager.Revoke 吊销.
+	ErrTokenRevoked = errors.New("token 已被吊销")
+)
+
+var _ token.Manager[jwt.RegisteredClaims] = (*Manager[jwt.RegisteredClaims])(nil)
+
+// Manager 基于内存的 token.Manager[T] 实现.
+// GenerateToken 生成不透明的 token 并保存 claims, VerifyToken 返回保存的 claims.
+// 可以通过 FailGenerate, FailVerify 设置返回的错误.
+// 并发安全.
+type Manager[T jwt.Claims] struct {
+	mu          sync.Mutex
+	clock       *Clock
+	seq         int
+	tokens      map[string]T
+	revoked     map[string]struct{}
+	generateErr error
+	verifyErr   error
+}
+
+// NewManager 创建一个基于内存的 token.Manager[T].
+// clock 不为 nil 时, VerifyToken 会根据 clock 校验 claims 的 exp 与 nbf.
+func NewManager[T jwt.Claims](clock *Clock) *Manager[T] {
+	return &Manager[T]{
+		clock:   clock,
+		tokens:  make(map[string]T),
+		revoked: make(map[string]struct{}),
+	}
+}
+
+// GenerateToken 生成 token. 设置了 FailGenerate 时返回该错误.
+func (m *Manager[T]) GenerateToken(clm T) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.generateErr != nil {
+		return "", m.generateErr
+	}
+	m.seq++
+	tokenStr := "jwttest-token-" + strconv.Itoa(m.seq)
+	m.tokens[tokenStr] = clm
+	return tokenStr, nil
+}
+
+// VerifyToken 校验 token. 设置了 FailVerify 时返回该错误.
+// 过期的 token 返回 jwt.ErrTokenExpired, 未生效的 token 返回 jwt.ErrTokenNotValidYet.
+func (m *Manager[T]) VerifyToken(tokenStr string) (T, error) {
+	var zeroClm T
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.verifyErr != nil {
+		return zeroClm, m.verifyErr
+	}
+	clm, ok := m.tokens[tokenStr]
+	if !ok {
+		return zeroClm, ErrTokenNotFound
+	}
+	if _, ok = m.revoked[tokenStr]; ok {
+		return zeroClm, ErrTokenRevoked
+	}
+	if m.clock != nil {
+		now := m.clock.Now()
+		if exp, _ := clm.GetExpirationTime(); exp != nil && !now.Before(exp.Time) {
+			return zeroClm, jwt.ErrTokenExpired
+		}
+		if nbf, _ := clm.GetNotBefore(); nbf != nil && now.Before(nbf.Time) {
+			return zeroClm, jwt.ErrTokenNotValidYet
+		}
+	}
+	return clm, nil
+}
+
+// Add 直接保存 token 与 claims, 用于构造指定的 token.
+func (m *Manager[T]) Add(tokenStr string, clm T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[tokenStr] = clm
+}
+
+// Revoke 吊销 token, 之后 VerifyToken 返回 ErrTokenRevoked.
+func (m *Manager[T]) Revoke(tokenStr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[tokenStr] = struct{}{}
+}
+
+// FailGenerate 设置 GenerateToken 返回的错误. 传入 nil 恢复正常.
+func (m *Manager[T]) FailGenerate(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generateErr = err
+}
+
+// FailVerify 设置 VerifyToken 返回的错误. 传入 nil 恢复正常.
+func (m *Manager[T]) FailVerify(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.verifyErr = err
+}
@@ -0,0 +1,128 @@
+package jwttest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+)
+
+type Claims struct {
+	Uid int64 `json:"uid,omitempty"`
+	jwtcore.RegisteredClaims
+}
+
+func TestManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  func(m *Manager[Claims], clock *Clock, tokenStr string)
+		clm     func(clock *Clock) Claims
+		wantErr error
+	}{
+		{
+			name:   "normal",
+			before: func(*Manager[Claims], *Clock, string) {},
+			clm: func(*Clock) Claims {
+				return Claims{Uid: 1}
+			},
+		},
+		{
+			name: "expired",
+			before: func(_ *Manager[Claims], clock *Clock, _ string) {
+				clock.Advance(2 * time.Minute)
+			},
+			clm: func(clock *Clock) Claims {
+				return Claims{Uid: 1, RegisteredClaims: jwtcore.RegisteredClaims{
+					ExpiresAt: After(clock, time.Minute),
+				}}
+			},
+			wantErr: jwt.ErrTokenExpired,
+		},
+		{
+			name:   "not_valid_yet",
+			before: func(*Manager[Claims], *Clock, string) {},
+			clm: func(clock *Clock) Claims {
+				return Claims{Uid: 1, RegisteredClaims: jwtcore.RegisteredClaims{
+					NotBefore: After(clock, time.Minute),
+				}}
+			},
+			wantErr: jwt.ErrTokenNotValidYet,
+		},
+		{
+			name: "revoked",
+			before: func(m *Manager[Claims], _ *Clock, tokenStr string) {
+				m.Revoke(tokenStr)
+			},
+			clm: func(*Clock) Claims {
+				return Claims{Uid: 1}
+			},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name: "fail_verify",
+			before: func(m *Manager[Claims], _ *Clock, _ string) {
+				m.FailVerify(errors.New("模拟校验失败"))
+			},
+			clm: func(*Clock) Claims {
+				return Claims{Uid: 1}
+			},
+			wantErr: errors.New("模拟校验失败"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewClock(time.UnixMilli(1695571200000))
+			m := NewManager[Claims](clock)
+			wantClm := tt.clm(clock)
+			tokenStr, err := m.GenerateToken(wantClm)
+			require.NoError(t, err)
+			tt.before(m, clock, tokenStr)
+
+			clm, err := m.VerifyToken(tokenStr)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, wantClm, clm)
+		})
+	}
+}
+
+func TestManager_Add(t *testing.T) {
+	m := NewManager[Claims](nil)
+	m.Add("fixed", Claims{Uid: 2})
+	clm, err := m.VerifyToken("fixed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), clm.Uid)
+
+	_, err = m.VerifyToken("unknown")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+}
+
+func TestManager_FailGenerate(t *testing.T) {
+	m := NewManager[Claims](nil)
+	wantErr := errors.New("模拟生成失败")
+	m.FailGenerate(wantErr)
+	_, err := m.GenerateToken(Claims{})
+	assert.Equal(t, wantErr, err)
+
+	m.FailGenerate(nil)
+	tokenStr, err := m.GenerateToken(Claims{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokenStr)
+}
+
+func TestClock(t *testing.T) {
+	start := time.UnixMilli(1695571200000)
+	clock := NewClock(start)
+	assert.Equal(t, start, clock.Now())
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
@@ -0,0 +1,44 @@
+package jwttest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/udugong/token"
+	"github.com/udugong/token/jwtcore"
+)
+
+// NewTokenManager 创建一个使用 clock 生成与校验 token 的 jwtcore.TokenManager.
+func NewTokenManager[T jwt.Claims, PT jwtcore.Claims[T]](key string, expire time.Duration,
+	clock *Clock, opts ...jwtcore.Option[T, PT]) *jwtcore.TokenManager[T, PT] {
+	opts = append([]jwtcore.Option[T, PT]{
+		jwtcore.WithTimeFunc[T, PT](clock.Now),
+		jwtcore.WithAddParserOption[T, PT](jwt.WithTimeFunc(clock.Now)),
+	}, opts...)
+	return jwtcore.NewTokenManager[T, PT](key, expire, opts...)
+}
+
+// Mint 使用 tm 生成 token, 失败时终止测试.
+func Mint[T any](tb testing.TB, tm token.Manager[T], clm T) string {
+	tb.Helper()
+	tokenStr, err := tm.GenerateToken(clm)
+	if err != nil {
+		tb.Fatalf("生成 token 失败: %v", err)
+	}
+	return tokenStr
+}
+
+// Sign 使用 HS256 与 key 对 claims 签名, 失败时终止测试.
+// 与 jwtcore.TokenManager 不同, 不会修改 claims 中的 iat 与 exp,
+// 因此可以构造任意 claims 或者已过期的 token, 例如:
+//
+//	Sign(t, "key", jwt.MapClaims{"sub": "1", "exp": After(clock, -time.Minute)})
+func Sign(tb testing.TB, key string, clm jwt.Claims) string {
+	tb.Helper()
+	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, clm).SignedString([]byte(key))
+	if err != nil {
+		tb.Fatalf("签名 token 失败: %v", err)
+	}
+	return tokenStr
+}
@@ -0,0 +1,101 @@
+package jwttest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+
+	ujwt "github.com/udugong/ginx/auth/jwt"
+)
+
+func TestNewTokenManager(t *testing.T) {
+	clock := NewClock(time.UnixMilli(1695571200000))
+	tm := NewTokenManager[Claims]("sign key", 10*time.Minute, clock)
+	tokenStr := Mint[Claims](t, tm, Claims{Uid: 1})
+
+	clm, err := tm.VerifyToken(tokenStr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), clm.Uid)
+	assert.Equal(t, clock.Now().Add(10*time.Minute).Unix(), clm.ExpiresAt.Unix())
+
+	// 过期
+	clock.Advance(11 * time.Minute)
+	_, err = tm.VerifyToken(tokenStr)
+	assert.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	clock := NewClock(time.UnixMilli(1695571200000))
+	tm := NewTokenManager[Claims]("sign key", 10*time.Minute, clock)
+	tests := []struct {
+		name    string
+		clm     jwt.Claims
+		wantErr bool
+	}{
+		{
+			name: "normal",
+			clm: jwt.MapClaims{
+				"uid": 1,
+				"exp": After(clock, time.Hour),
+			},
+		},
+		{
+			name: "expired",
+			clm: Claims{Uid: 1, RegisteredClaims: jwtcore.RegisteredClaims{
+				ExpiresAt: After(clock, -time.Minute),
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clm, err := tm.VerifyToken(Sign(t, "sign key", tt.clm))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), clm.Uid)
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	m := NewManager[Claims](nil)
+	tokenStr := Mint[Claims](t, m, Claims{Uid: 1})
+	builder := ujwt.NewMiddlewareBuilder[Claims](m).SetExtractors(
+		ujwt.HeaderExtractor("authorization", "Bearer"),
+		ujwt.HeaderExtractor("x-token", ""),
+		ujwt.QueryExtractor("access_token"),
+		ujwt.CookieExtractor("access_token"),
+	)
+	server := gin.New()
+	server.GET("/", builder.Build(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	tests := []struct {
+		name     string
+		opt      RequestOption
+		wantCode int
+	}{
+		{name: "bearer", opt: Bearer(tokenStr), wantCode: http.StatusOK},
+		{name: "header", opt: Header("x-token", "", tokenStr), wantCode: http.StatusOK},
+		{name: "query", opt: Query("access_token", tokenStr), wantCode: http.StatusOK},
+		{name: "cookie", opt: Cookie("access_token", tokenStr), wantCode: http.StatusOK},
+		{name: "bad_token", opt: Bearer("bad_token"), wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, NewRequest(http.MethodGet, "/", nil, tt.opt))
+			assert.Equal(t, tt.wantCode, recorder.Code)
+		})
+	}
+}
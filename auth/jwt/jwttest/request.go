@@ -0,0 +1,51 @@
+package jwttest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+// RequestOption 修改测试请求, 例如附加 token.
+type RequestOption func(*http.Request)
+
+// NewRequest 创建一个测试请求, 并按顺序应用 opts.
+func NewRequest(method, target string, body io.Reader, opts ...RequestOption) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req
+}
+
+// Bearer 把 token 以 "Bearer token" 的形式放入 Authorization 请求头.
+func Bearer(token string) RequestOption {
+	return Header("Authorization", "Bearer", token)
+}
+
+// Header 把 token 放入请求头. prefix 不为空时使用 "prefix token" 的形式.
+func Header(name, prefix, token string) RequestOption {
+	value := token
+	if prefix != "" {
+		value = prefix + " " + token
+	}
+	return func(req *http.Request) {
+		req.Header.Set(name, value)
+	}
+}
+
+// Query 把 token 放入查询参数.
+func Query(name, token string) RequestOption {
+	return func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set(name, token)
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// Cookie 把 token 放入 cookie.
+func Cookie(name, token string) RequestOption {
+	return func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: name, Value: token})
+	}
+}
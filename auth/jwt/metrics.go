@@ -0,0 +1,143 @@
+package jwt
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 认证结果.
+const (
+	OutcomeOK               = "ok"
+	OutcomeMissing          = "missing"
+	OutcomeExpired          = "expired"
+	OutcomeInvalidSignature = "invalid_signature"
+	OutcomeInvalid          = "invalid"
+	OutcomeRevoked          = "revoked"
+	OutcomeIgnored          = "ignored"
+	OutcomeUnavailable      = "unavailable"
+)
+
+// 刷新令牌的结果.
+const (
+	RefreshOutcomeOK           = "ok"
+	RefreshOutcomeUnauthorized = "unauthorized"
+	RefreshOutcomeReused       = "reused"
+	RefreshOutcomeError        = "error"
+)
+
+// MetricsOpts 定义认证指标的配置.
+type MetricsOpts struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels prometheus.Labels
+
+	// Buckets 校验耗时直方图的桶, 单位为秒.
+	// 默认使用 prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// Metrics 定义认证与刷新令牌的 prometheus 指标.
+// 为 nil 时不记录任何指标.
+type Metrics struct {
+	authTotal      *prometheus.CounterVec
+	verifyDuration *prometheus.HistogramVec
+	refreshTotal   *prometheus.CounterVec
+	rotationTotal  prometheus.Counter
+}
+
+// NewMetrics 创建认证指标并注册到 reg 中.
+// reg 为 nil 时使用 prometheus.DefaultRegisterer. 注册失败时 panic.
+func NewMetrics(reg prometheus.Registerer, opts MetricsOpts) *Metrics {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	m := &Metrics{
+		authTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "auth_requests_total",
+			Help:        "认证请求数, 按结果、token 来源与签发人区分",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"outcome", "source", "issuer"}),
+		verifyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "auth_verify_duration_seconds",
+			Help:        "token 校验耗时",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     buckets,
+		}, []string{"outcome"}),
+		refreshTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "auth_refresh_total",
+			Help:        "刷新令牌请求数, 按结果区分",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"outcome"}),
+		rotationTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "auth_refresh_rotations_total",
+			Help:        "轮换刷新令牌的次数",
+			ConstLabels: opts.ConstLabels,
+		}),
+	}
+	reg.MustRegister(m.authTotal, m.verifyDuration, m.refreshTotal, m.rotationTotal)
+	return m
+}
+
+func (m *Metrics) observeAuth(outcome, source, issuer string) {
+	if m == nil {
+		return
+	}
+	m.authTotal.WithLabelValues(outcome, source, issuer).Inc()
+}
+
+func (m *Metrics) observeVerify(outcome string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.verifyDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeRefresh(outcome string) {
+	if m == nil {
+		return
+	}
+	m.refreshTotal.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) observeRotation() {
+	if m == nil {
+		return
+	}
+	m.rotationTotal.Inc()
+}
+
+// verifyOutcome 根据校验 token 的错误得到认证结果.
+func verifyOutcome(err error) string {
+	switch {
+	case errors.Is(err, ErrVerifierUnavailable):
+		return OutcomeUnavailable
+	case isJWTError(err, jwt.ErrTokenExpired):
+		return OutcomeExpired
+	case isJWTError(err, jwt.ErrTokenSignatureInvalid):
+		return OutcomeInvalidSignature
+	default:
+		return OutcomeInvalid
+	}
+}
+
+// isJWTError 判断 err 是否为 target.
+// jwtcore 使用 %v 包装错误, errors.Is 无法判断时退化为比较错误信息.
+func isJWTError(err, target error) bool {
+	return errors.Is(err, target) || strings.Contains(err.Error(), target.Error())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -21,15 +22,27 @@ type MiddlewareBuilder[T jwt.Claims] struct {
 	// 默认使用 func(*gin.Context) bool { return false } 也就是全部不忽略.
 	ignorePath func(*gin.Context) bool
 
-	// Middleware 中提取 token 字符串的方法.
+	// Middleware 中提取 token 字符串的方式. 按顺序使用第一个非空的 token.
 	// 默认从 authorization 请求头中,获取按 Bearer 分割获取.
-	extractToken func(*gin.Context) string
+	extractors []TokenExtractor
 
 	// Middleware 中设置 Claims 的方法.
 	// 默认设置到 key=claimsKey{} 的 context.Context 中.
 	// 通过 ClaimsFromContext[T]() 获取 Claims.
 	setClaims func(*gin.Context, T)
 
+	// Middleware 中判断 token 是否已被吊销的方法.
+	// 默认使用 func(*gin.Context, T) bool { return false } 也就是全部未吊销.
+	isRevoked func(*gin.Context, T) bool
+
+	// Middleware 中把 claims 投影为 Principal 的方法.
+	// 默认为 nil 也就是不投影.
+	// 投影的 Principal 会设置到 context.Context 与 gin.Context.Keys 中.
+	principal func(T) Principal
+
+	// metrics 认证指标. 默认为 nil 不记录.
+	metrics *Metrics
+
 	TokenManager token.Manager[T]
 }
 
@@ -40,11 +53,16 @@ func NewMiddlewareBuilder[T jwt.Claims](
 		ignorePath: func(*gin.Context) bool {
 			return false
 		},
-		extractToken: extractToken,
+		extractors: []TokenExtractor{
+			{Source: TokenSourceHeader, Extract: extractToken},
+		},
 		setClaims: func(c *gin.Context, t T) {
 			c.Request = c.Request.WithContext(
 				ContextWithClaims(c.Request.Context(), t))
 		},
+		isRevoked: func(*gin.Context, T) bool {
+			return false
+		},
 		TokenManager: m,
 	}
 }
@@ -56,8 +74,15 @@ func (m *MiddlewareBuilder[T]) IgnorePathFunc(fn func(*gin.Context) bool) *Middl
 }
 
 // SetExtractTokenFunc 设置提取 token 字符串的方法.
+// 该方法提取的 token 来源记为 TokenSourceCustom.
 func (m *MiddlewareBuilder[T]) SetExtractTokenFunc(fn func(*gin.Context) string) *MiddlewareBuilder[T] {
-	m.extractToken = fn
+	return m.SetExtractors(TokenExtractor{Source: TokenSourceCustom, Extract: fn})
+}
+
+// SetExtractors 设置提取 token 的方式.
+// 按顺序尝试, 使用第一个提取到的 token.
+func (m *MiddlewareBuilder[T]) SetExtractors(extractors ...TokenExtractor) *MiddlewareBuilder[T] {
+	m.extractors = extractors
 	return m
 }
 
@@ -67,6 +92,26 @@ func (m *MiddlewareBuilder[T]) SetClaimsFunc(fn func(*gin.Context, T)) *Middlewa
 	return m
 }
 
+// SetRevokedFunc 设置判断 token 是否已被吊销的方法.
+// 已被吊销的 token 会返回 401.
+func (m *MiddlewareBuilder[T]) SetRevokedFunc(fn func(*gin.Context, T) bool) *MiddlewareBuilder[T] {
+	m.isRevoked = fn
+	return m
+}
+
+// SetPrincipalFunc 设置把 claims 投影为 Principal 的方法.
+// 可以使用 ProjectPrincipal[T]. 通过 PrincipalFromContext 或 GetPrincipal 获取.
+func (m *MiddlewareBuilder[T]) SetPrincipalFunc(fn func(T) Principal) *MiddlewareBuilder[T] {
+	m.principal = fn
+	return m
+}
+
+// SetMetrics 设置认证指标.
+func (m *MiddlewareBuilder[T]) SetMetrics(metrics *Metrics) *MiddlewareBuilder[T] {
+	m.metrics = metrics
+	return m
+}
+
 // IgnoreFullPath 忽略匹配的完整路径.
 // 例如: "/user/:id"
 func (m *MiddlewareBuilder[T]) IgnoreFullPath(fullPaths ...string) *MiddlewareBuilder[T] {
@@ -78,26 +123,67 @@ func (m *MiddlewareBuilder[T]) Build() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 不需要校验
 		if m.ignorePath(c) {
+			m.metrics.observeAuth(OutcomeIgnored, "", "")
 			return
 		}
 
 		// 提取 token
-		tokenStr := m.extractToken(c)
+		tokenStr, source := m.extract(c)
 		if tokenStr == "" {
+			m.metrics.observeAuth(OutcomeMissing, "", "")
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
 		// 校验 token
+		start := time.Now()
 		clm, err := m.TokenManager.VerifyToken(tokenStr)
 		if err != nil {
+			outcome := verifyOutcome(err)
+			m.metrics.observeVerify(outcome, time.Since(start))
+			m.metrics.observeAuth(outcome, source, "")
+			if outcome == OutcomeUnavailable {
+				// 无法判断 token 是否有效, 客户端可以重试
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		m.metrics.observeVerify(OutcomeOK, time.Since(start))
+		issuer, _ := clm.GetIssuer()
+
+		// 校验是否已吊销
+		if m.isRevoked(c, clm) {
+			m.metrics.observeAuth(OutcomeRevoked, source, issuer)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		m.metrics.observeAuth(OutcomeOK, source, issuer)
 
 		// 设置 claims
 		m.setClaims(c, clm)
+		if m.principal != nil {
+			p := m.principal(clm)
+			setPrincipal(c, &p)
+		}
+	}
+}
+
+// extractToken 提取 token 字符串.
+func (m *MiddlewareBuilder[T]) extractToken(c *gin.Context) string {
+	tokenStr, _ := m.extract(c)
+	return tokenStr
+}
+
+// extract 提取 token 字符串及其来源.
+func (m *MiddlewareBuilder[T]) extract(c *gin.Context) (string, string) {
+	for _, e := range m.extractors {
+		if tokenStr := e.Extract(c); tokenStr != "" {
+			return tokenStr, e.Source
+		}
 	}
+	return "", ""
 }
 
 // claimsKey 定义从 context.Context 中设置/获取 claims 的 key.
@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuth 2.0 错误码.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidClient        = "invalid_client"
+	ErrCodeInvalidGrant         = "invalid_grant"
+	ErrCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrCodeServerError          = "server_error"
+)
+
+// OAuthError 定义 OAuth 2.0 的错误响应.
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// ClientClaims 定义 client_id claim, 记录令牌签发给哪个客户端.
+// 可以嵌入到自定义的 Claims 中, 令牌端点拒绝其他客户端使用记录了客户端的刷新令牌.
+// 详见 https://datatracker.ietf.org/doc/html/rfc8693#section-4.3
+type ClientClaims struct {
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// GetClientID 获取令牌签发给的客户端. 没有记录时返回空字符串.
+func (c ClientClaims) GetClientID() string {
+	return c.ClientID
+}
+
+// SetClientID 设置令牌签发给的客户端.
+func (c *ClientClaims) SetClientID(clientID string) {
+	c.ClientID = clientID
+}
+
+// clientCredentials 获取客户端凭证.
+// 优先使用 Basic 认证, 其次使用表单中的 client_id 与 client_secret.
+// Basic 认证中的凭证按 application/x-www-form-urlencoded 编码.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		id, err := url.QueryUnescape(id)
+		if err != nil {
+			return "", "", false
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return "", "", false
+		}
+		return id, secret, true
+	}
+	id := c.PostForm("client_id")
+	if id == "" {
+		return "", "", false
+	}
+	return id, c.PostForm("client_secret"), true
+}
@@ -0,0 +1,256 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrCodeInsufficientScope 权限范围不足的错误码.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6750#section-3.1
+const ErrCodeInsufficientScope = "insufficient_scope"
+
+type policyKind int
+
+const (
+	policyAuthenticated policyKind = iota
+	policyPublic
+	policyOptional
+)
+
+// Policy 定义路由的认证策略.
+type Policy struct {
+	kind   policyKind
+	scopes []string
+}
+
+// Public 不需要认证.
+func Public() Policy {
+	return Policy{kind: policyPublic}
+}
+
+// Optional 可选认证. 没有 token 时允许匿名访问, 有 token 时必须通过认证.
+func Optional() Policy {
+	return Policy{kind: policyOptional}
+}
+
+// Authenticated 需要通过认证.
+func Authenticated() Policy {
+	return Policy{kind: policyAuthenticated}
+}
+
+// Scopes 需要通过认证且 token 包含全部的 scopes.
+func Scopes(scopes ...string) Policy {
+	return Policy{kind: policyAuthenticated, scopes: scopes}
+}
+
+// String 返回策略的描述.
+func (p Policy) String() string {
+	switch {
+	case p.kind == policyPublic:
+		return "public"
+	case p.kind == policyOptional:
+		return "optional"
+	case len(p.scopes) > 0:
+		return "scopes(" + strings.Join(p.scopes, " ") + ")"
+	default:
+		return "authenticated"
+	}
+}
+
+// routeKey 定义路由的方法与完整路径.
+type routeKey struct {
+	method   string
+	fullPath string
+}
+
+// PolicyRegistry 定义按路由声明认证策略的注册表.
+// 使用一个中间件根据 c.FullPath() 与请求方法查找策略, 代替全局的忽略列表:
+//
+//	registry := NewPolicyRegistry[Claims](NewMiddlewareBuilder[Claims](accessTM))
+//	r.Use(registry.Build())
+//	registry.Handle(r, http.MethodPost, "/login", Public(), login)
+//	registry.Handle(r, http.MethodGet, "/orders", Scopes("orders:read"), listOrders)
+//	if err := registry.Validate(r); err != nil {
+//		panic(err)
+//	}
+type PolicyRegistry[T jwt.Claims] struct {
+	auth *MiddlewareBuilder[T]
+
+	// getClaims 获取 Claims.
+	// 默认使用 ClaimsFromContext[T] 获取.
+	getClaims func(*gin.Context) (T, bool)
+
+	// getScopes 获取 claims 中的权限范围.
+	// 默认读取 claims 中的 scope (以空格分隔) 或 scp 字段.
+	getScopes func(T) []string
+
+	// defaultPolicy 没有声明策略的路由使用的策略.
+	// 默认为 Authenticated().
+	defaultPolicy Policy
+
+	mu       sync.RWMutex
+	policies map[routeKey]Policy
+}
+
+// NewPolicyRegistry 创建一个认证策略注册表.
+// auth 用于认证需要认证的路由.
+func NewPolicyRegistry[T jwt.Claims](auth *MiddlewareBuilder[T]) *PolicyRegistry[T] {
+	return &PolicyRegistry[T]{
+		auth: auth,
+		getClaims: func(c *gin.Context) (T, bool) {
+			return ClaimsFromContext[T](c.Request.Context())
+		},
+		getScopes: func(clm T) []string {
+			return scopesFromClaims(clm)
+		},
+		defaultPolicy: Authenticated(),
+		policies:      make(map[routeKey]Policy),
+	}
+}
+
+// SetGetClaimsFunc 设置获取 Claims 的方法.
+// 需要与 auth 中设置 Claims 的方式匹配.
+func (r *PolicyRegistry[T]) SetGetClaimsFunc(fn func(*gin.Context) (T, bool)) *PolicyRegistry[T] {
+	r.getClaims = fn
+	return r
+}
+
+// SetGetScopesFunc 设置获取 claims 中权限范围的方法.
+func (r *PolicyRegistry[T]) SetGetScopesFunc(fn func(T) []string) *PolicyRegistry[T] {
+	r.getScopes = fn
+	return r
+}
+
+// SetDefaultPolicy 设置没有声明策略的路由使用的策略.
+func (r *PolicyRegistry[T]) SetDefaultPolicy(p Policy) *PolicyRegistry[T] {
+	r.defaultPolicy = p
+	return r
+}
+
+// Register 为路由声明认证策略.
+// fullPath 为 gin 的完整路径, 例如 "/user/:id".
+func (r *PolicyRegistry[T]) Register(method, fullPath string, p Policy) *PolicyRegistry[T] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[routeKey{method: method, fullPath: fullPath}] = p
+	return r
+}
+
+// Handle 注册 gin 路由并声明其认证策略.
+// routes 可以是 *gin.Engine 或 *gin.RouterGroup.
+func (r *PolicyRegistry[T]) Handle(routes interface {
+	gin.IRoutes
+	BasePath() string
+}, method, relativePath string, p Policy, handlers ...gin.HandlerFunc) {
+	r.Register(method, joinPaths(routes.BasePath(), relativePath), p)
+	routes.Handle(method, relativePath, handlers...)
+}
+
+// Lookup 查找路由的认证策略.
+func (r *PolicyRegistry[T]) Lookup(method, fullPath string) (Policy, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.policies[routeKey{method: method, fullPath: fullPath}]
+	return p, ok
+}
+
+// Validate 检查 engine 中的全部路由是否都声明了认证策略.
+// 通常在启动时调用, 返回的错误包含缺少策略的路由.
+func (r *PolicyRegistry[T]) Validate(engine *gin.Engine) error {
+	var missing []string
+	for _, route := range engine.Routes() {
+		if _, ok := r.Lookup(route.Method, route.Path); !ok {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("以下路由缺少认证策略: %s", strings.Join(missing, ", "))
+}
+
+// Build 构建按路由策略认证的中间件.
+// 没有匹配到路由 (例如 404) 的请求直接放行.
+func (r *PolicyRegistry[T]) Build() gin.HandlerFunc {
+	auth := r.auth.Build()
+	return func(c *gin.Context) {
+		fullPath := c.FullPath()
+		if fullPath == "" {
+			return
+		}
+		p, ok := r.Lookup(c.Request.Method, fullPath)
+		if !ok {
+			p = r.defaultPolicy
+		}
+
+		switch p.kind {
+		case policyPublic:
+			return
+		case policyOptional:
+			if r.auth.extractToken(c) == "" {
+				return
+			}
+		}
+		auth(c)
+		if c.IsAborted() || len(p.scopes) == 0 {
+			return
+		}
+
+		clm, ok := r.getClaims(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		scopes := r.getScopes(clm)
+		for _, s := range p.scopes {
+			if !slices.Contains(scopes, s) {
+				c.Header("WWW-Authenticate", fmt.Sprintf(`%s error="%s", scope="%s"`,
+					bearerPrefix, ErrCodeInsufficientScope, strings.Join(p.scopes, " ")))
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+	}
+}
+
+// scopesFromClaims 通过 JSON 获取 claims 中的 scope (以空格分隔) 或 scp 字段.
+func scopesFromClaims(clm any) []string {
+	var fields struct {
+		Scope string          `json:"scope"`
+		Scp   json.RawMessage `json:"scp"`
+	}
+	data, err := json.Marshal(clm)
+	if err != nil || json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	if fields.Scope != "" {
+		return strings.Fields(fields.Scope)
+	}
+	var scp jwt.ClaimStrings
+	if len(fields.Scp) > 0 && json.Unmarshal(fields.Scp, &scp) == nil {
+		return scp
+	}
+	return nil
+}
+
+// joinPaths 与 gin 拼接路由组路径的方式一致.
+func joinPaths(absolutePath, relativePath string) string {
+	if relativePath == "" {
+		return absolutePath
+	}
+	finalPath := path.Join(absolutePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(finalPath, "/") {
+		return finalPath + "/"
+	}
+	return finalPath
+}
@@ -0,0 +1,189 @@
+package jwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/udugong/token/jwtcore"
+
+	"github.com/udugong/ginx/auth/jwt/jwttest"
+)
+
+type scopeClaims struct {
+	Scope string `json:"scope,omitempty"`
+	jwtcore.RegisteredClaims
+}
+
+func TestPolicyRegistry_Build(t *testing.T) {
+	tm := jwttest.NewManager[scopeClaims](nil)
+	readToken := jwttest.Mint[scopeClaims](t, tm, scopeClaims{Scope: "orders:read profile"})
+	registry := NewPolicyRegistry[scopeClaims](NewMiddlewareBuilder[scopeClaims](tm))
+	server := gin.New()
+	server.Use(registry.Build())
+	ok := func(c *gin.Context) {
+		_, authed := ClaimsFromContext[scopeClaims](c.Request.Context())
+		if authed {
+			c.String(http.StatusOK, "authenticated")
+			return
+		}
+		c.String(http.StatusOK, "anonymous")
+	}
+	registry.Handle(server, http.MethodPost, "/login", Public(), ok)
+	registry.Handle(server, http.MethodGet, "/products", Optional(), ok)
+	v1 := server.Group("/v1")
+	registry.Handle(v1, http.MethodGet, "/orders/:id", Scopes("orders:read"), ok)
+	registry.Handle(v1, http.MethodDelete, "/orders/:id", Scopes("orders:write"), ok)
+	registry.Handle(server, http.MethodGet, "/profile", Authenticated(), ok)
+	// 没有声明策略, 使用默认策略
+	server.GET("/undeclared", ok)
+
+	tests := []struct {
+		name          string
+		req           *http.Request
+		wantCode      int
+		wantBody      string
+		wantChallenge string
+	}{
+		{
+			name:     "public",
+			req:      jwttest.NewRequest(http.MethodPost, "/login", nil),
+			wantCode: http.StatusOK,
+			wantBody: "anonymous",
+		},
+		{
+			name:     "optional_anonymous",
+			req:      jwttest.NewRequest(http.MethodGet, "/products", nil),
+			wantCode: http.StatusOK,
+			wantBody: "anonymous",
+		},
+		{
+			name:     "optional_authenticated",
+			req:      jwttest.NewRequest(http.MethodGet, "/products", nil, jwttest.Bearer(readToken)),
+			wantCode: http.StatusOK,
+			wantBody: "authenticated",
+		},
+		{
+			// 可选认证但 token 错误
+			name:     "optional_bad_token",
+			req:      jwttest.NewRequest(http.MethodGet, "/products", nil, jwttest.Bearer("bad_token")),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "authenticated_without_token",
+			req:      jwttest.NewRequest(http.MethodGet, "/profile", nil),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "scopes_pass",
+			req:      jwttest.NewRequest(http.MethodGet, "/v1/orders/1", nil, jwttest.Bearer(readToken)),
+			wantCode: http.StatusOK,
+			wantBody: "authenticated",
+		},
+		{
+			// 权限范围不足
+			name:          "insufficient_scope",
+			req:           jwttest.NewRequest(http.MethodDelete, "/v1/orders/1", nil, jwttest.Bearer(readToken)),
+			wantCode:      http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="orders:write"`,
+		},
+		{
+			name:     "undeclared_uses_default_policy",
+			req:      jwttest.NewRequest(http.MethodGet, "/undeclared", nil),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "not_found",
+			req:      jwttest.NewRequest(http.MethodGet, "/not-found", nil),
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, tt.req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, recorder.Body.String())
+			}
+			assert.Equal(t, tt.wantChallenge, recorder.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestPolicyRegistry_Validate(t *testing.T) {
+	handler := func(c *gin.Context) {}
+	tests := []struct {
+		name    string
+		setup   func(r *PolicyRegistry[scopeClaims], server *gin.Engine)
+		wantErr string
+	}{
+		{
+			name: "all_declared",
+			setup: func(r *PolicyRegistry[scopeClaims], server *gin.Engine) {
+				r.Handle(server, http.MethodGet, "/", Public(), handler)
+				r.Handle(server.Group("/api"), http.MethodGet, "/users/:id", Authenticated(), handler)
+				// 先注册 gin 路由再声明策略
+				server.POST("/users", handler)
+				r.Register(http.MethodPost, "/users", Scopes("users:write"))
+			},
+		},
+		{
+			name: "missing",
+			setup: func(r *PolicyRegistry[scopeClaims], server *gin.Engine) {
+				r.Handle(server, http.MethodGet, "/", Public(), handler)
+				server.POST("/users", handler)
+				server.GET("/api/users/:id", handler)
+			},
+			wantErr: "以下路由缺少认证策略: GET /api/users/:id, POST /users",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewPolicyRegistry[scopeClaims](NewMiddlewareBuilder[scopeClaims](nil))
+			server := gin.New()
+			tt.setup(r, server)
+			err := r.Validate(server)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestScopesFromClaims(t *testing.T) {
+	tests := []struct {
+		name string
+		clm  any
+		want []string
+	}{
+		{
+			name: "scope",
+			clm:  map[string]any{"scope": "a b"},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "scp_array",
+			clm:  map[string]any{"scp": []string{"a", "b"}},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "scp_string",
+			clm:  map[string]any{"scp": "a"},
+			want: []string{"a"},
+		},
+		{
+			name: "none",
+			clm:  map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, scopesFromClaims(tt.clm))
+		})
+	}
+}
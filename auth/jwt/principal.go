@@ -0,0 +1,176 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PrincipalKey 定义 Principal 在 gin.Context.Keys 中的 key.
+const PrincipalKey = "ginx/principal"
+
+// 日志属性的 key.
+const (
+	LogKeyUserID    = "user_id"
+	LogKeyTenantID  = "tenant_id"
+	LogKeySessionID = "session_id"
+)
+
+// Principal 定义从 claims 投影出的当前请求主体.
+// 处理函数不需要知道具体的 claims 类型.
+type Principal struct {
+	Subject   string
+	Tenant    string
+	Roles     []string
+	Scopes    []string
+	SessionID string
+}
+
+// HasRole 判断是否拥有角色.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// HasScope 判断是否拥有权限范围.
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// LogAttrs 返回主体的日志属性. 只包含非空的字段.
+func (p *Principal) LogAttrs() []slog.Attr {
+	attrs := make([]slog.Attr, 0, 3)
+	if p.Subject != "" {
+		attrs = append(attrs, slog.String(LogKeyUserID, p.Subject))
+	}
+	if p.Tenant != "" {
+		attrs = append(attrs, slog.String(LogKeyTenantID, p.Tenant))
+	}
+	if p.SessionID != "" {
+		attrs = append(attrs, slog.String(LogKeySessionID, p.SessionID))
+	}
+	return attrs
+}
+
+// ProjectPrincipal 通过 JSON 从 claims 中投影出 Principal:
+//   - Subject: sub
+//   - Tenant: tid 或 tenant_id
+//   - Roles: roles
+//   - Scopes: scope (以空格分隔) 或 scp
+//   - SessionID: sid
+//
+// 可以直接作为 MiddlewareBuilder.SetPrincipalFunc 的参数.
+func ProjectPrincipal[T jwt.Claims](clm T) Principal {
+	var fields struct {
+		Tid      string           `json:"tid"`
+		TenantID string           `json:"tenant_id"`
+		Roles    jwt.ClaimStrings `json:"roles"`
+		Sid      string           `json:"sid"`
+	}
+	if data, err := json.Marshal(clm); err == nil {
+		_ = json.Unmarshal(data, &fields)
+	}
+	sub, _ := clm.GetSubject()
+	tenant := fields.Tid
+	if tenant == "" {
+		tenant = fields.TenantID
+	}
+	return Principal{
+		Subject:   sub,
+		Tenant:    tenant,
+		Roles:     fields.Roles,
+		Scopes:    scopesFromClaims(clm),
+		SessionID: fields.Sid,
+	}
+}
+
+// principalKey 定义从 context.Context 中设置/获取 Principal 的 key.
+type principalKey struct{}
+
+// ContextWithPrincipal 为 Principal 创建 context.
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext 从 context 中获取 Principal.
+// 如果没有 Principal 则返回 false.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// GetPrincipal 从 gin.Context.Keys 中获取 Principal.
+// 如果没有 Principal 则返回 false.
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok && p != nil
+}
+
+// setPrincipal 把 Principal 设置到 context.Context 与 gin.Context.Keys 中.
+func setPrincipal(c *gin.Context, p *Principal) {
+	c.Set(PrincipalKey, p)
+	c.Request = c.Request.WithContext(ContextWithPrincipal(c.Request.Context(), p))
+}
+
+// PrincipalAttrs 返回 context 中 Principal 的日志属性.
+// 没有 Principal 时返回 nil.
+func PrincipalAttrs(ctx context.Context) []slog.Attr {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return p.LogAttrs()
+}
+
+// LoggerWithPrincipal 返回携带 context 中 Principal 日志属性的 logger.
+func LoggerWithPrincipal(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	attrs := PrincipalAttrs(ctx)
+	if len(attrs) == 0 {
+		return logger
+	}
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	return logger.With(args...)
+}
+
+// PrincipalHandler 为日志记录添加 context 中 Principal 的属性.
+// 使用 logger.InfoContext(c.Request.Context(), ...) 等方法记录日志时,
+// 每条日志自动携带 user_id 与 tenant_id:
+//
+//	logger := slog.New(ujwt.NewPrincipalHandler(slog.NewJSONHandler(os.Stdout, nil)))
+type PrincipalHandler struct {
+	slog.Handler
+}
+
+// NewPrincipalHandler 创建一个添加 Principal 日志属性的 slog.Handler.
+func NewPrincipalHandler(h slog.Handler) *PrincipalHandler {
+	return &PrincipalHandler{Handler: h}
+}
+
+// Handle 添加 Principal 的日志属性后交给下一个 Handler 处理.
+func (h *PrincipalHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := PrincipalAttrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs 实现 slog.Handler.
+func (h *PrincipalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &PrincipalHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 实现 slog.Handler.
+func (h *PrincipalHandler) WithGroup(name string) slog.Handler {
+	return &PrincipalHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+
+	"github.com/udugong/ginx/auth/jwt/jwttest"
+)
+
+type principalClaims struct {
+	Tid   string   `json:"tid,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	Sid   string   `json:"sid,omitempty"`
+	jwtcore.RegisteredClaims
+}
+
+func TestProjectPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		clm  principalClaims
+		want Principal
+	}{
+		{
+			name: "normal",
+			clm: principalClaims{
+				Tid:              "tenant-1",
+				Roles:            []string{"admin"},
+				Scope:            "orders:read orders:write",
+				Sid:              "session-1",
+				RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"},
+			},
+			want: Principal{
+				Subject:   "user-1",
+				Tenant:    "tenant-1",
+				Roles:     []string{"admin"},
+				Scopes:    []string{"orders:read", "orders:write"},
+				SessionID: "session-1",
+			},
+		},
+		{
+			name: "subject_only",
+			clm:  principalClaims{RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"}},
+			want: Principal{Subject: "user-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ProjectPrincipal(tt.clm))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_SetPrincipalFunc(t *testing.T) {
+	tm := jwttest.NewManager[principalClaims](nil)
+	tokenStr := jwttest.Mint[principalClaims](t, tm, principalClaims{
+		Tid:              "tenant-1",
+		Roles:            []string{"admin"},
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"},
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(NewPrincipalHandler(slog.NewJSONHandler(&buf, nil)))
+	server := gin.New()
+	server.Use(NewMiddlewareBuilder[principalClaims](tm).
+		SetPrincipalFunc(ProjectPrincipal[principalClaims]).Build())
+	server.GET("/profile", func(c *gin.Context) {
+		p, ok := PrincipalFromContext(c.Request.Context())
+		require.True(t, ok)
+		gp, ok := GetPrincipal(c)
+		require.True(t, ok)
+		assert.Same(t, p, gp)
+		assert.True(t, p.HasRole("admin"))
+		assert.False(t, p.HasScope("orders:read"))
+		logger.InfoContext(c.Request.Context(), "查看资料")
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, jwttest.NewRequest(http.MethodGet, "/profile", nil, jwttest.Bearer(tokenStr)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "user-1", record[LogKeyUserID])
+	assert.Equal(t, "tenant-1", record[LogKeyTenantID])
+	assert.NotContains(t, record, LogKeySessionID)
+}
+
+func TestLoggerWithPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want map[string]any
+	}{
+		{
+			name: "with_principal",
+			ctx: ContextWithPrincipal(context.Background(),
+				&Principal{Subject: "user-1", SessionID: "session-1"}),
+			want: map[string]any{LogKeyUserID: "user-1", LogKeySessionID: "session-1"},
+		},
+		{
+			name: "without_principal",
+			ctx:  context.Background(),
+			want: map[string]any{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := LoggerWithPrincipal(tt.ctx, slog.New(slog.NewJSONHandler(&buf, nil)))
+			logger.Info("msg")
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+			for _, k := range []string{"time", "level", "msg"} {
+				delete(record, k)
+			}
+			assert.Equal(t, tt.want, record)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 
@@ -40,6 +41,17 @@ type RefreshManager[T jwt.Claims] struct {
 	// responseHandler 响应函数.
 	// 默认返回 HTTP 响应码为 204 的响应.
 	responseHandler gin.HandlerFunc
+
+	// isReused 判断刷新令牌是否被重复使用.
+	// 默认使用 func(*gin.Context, T) bool { return false } 也就是不检测.
+	isReused func(*gin.Context, T) bool
+
+	// metrics 刷新令牌指标. 默认为 nil 不记录.
+	metrics *Metrics
+
+	// authenticateClient 令牌端点认证客户端.
+	// 默认为 nil 也就是不认证客户端.
+	authenticateClient ClientAuthenticator
 }
 
 // TokenSetterFunc 令牌设置函数.
@@ -68,6 +80,9 @@ func NewRefreshManager[T jwt.Claims](
 	m.responseHandler = func(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 	}
+	m.isReused = func(*gin.Context, T) bool {
+		return false
+	}
 	return m.WithOptions(options...)
 }
 
@@ -125,42 +140,86 @@ func WithResponseSetter[T jwt.Claims](fn gin.HandlerFunc) Option[T] {
 	})
 }
 
+// WithReuseDetector 设置检测刷新令牌是否被重复使用的方法.
+// 通常配合轮换刷新令牌使用, 被重复使用的刷新令牌会返回 401.
+func WithReuseDetector[T jwt.Claims](fn func(*gin.Context, T) bool) Option[T] {
+	return optionFunc[T](func(m *RefreshManager[T]) {
+		m.isReused = fn
+	})
+}
+
+// WithMetrics 设置刷新令牌指标.
+func WithMetrics[T jwt.Claims](metrics *Metrics) Option[T] {
+	return optionFunc[T](func(m *RefreshManager[T]) {
+		m.metrics = metrics
+	})
+}
+
+// WithClientAuthenticator 设置令牌端点认证客户端的方法.
+// 设置后 TokenHandler 要求请求携带客户端凭证.
+func WithClientAuthenticator[T jwt.Claims](fn ClientAuthenticator) Option[T] {
+	return optionFunc[T](func(m *RefreshManager[T]) {
+		m.authenticateClient = fn
+	})
+}
+
 // Handler 刷新令牌的 gin.HandlerFunc.
 func (m *RefreshManager[T]) Handler(c *gin.Context) {
 	m.refreshAuthHandler(c)
 	if c.IsAborted() {
+		m.metrics.observeRefresh(RefreshOutcomeUnauthorized)
 		return
 	}
 	clm, ok := m.getClaims(c)
 	if !ok {
 		// 不应该命中该分支
+		m.metrics.observeRefresh(RefreshOutcomeError)
 		c.Status(http.StatusInternalServerError)
 		log.Println("未知错误")
 		return
 	}
 
-	accessToken, err := m.accessTM.GenerateToken(clm)
+	if m.isReused(c, clm) {
+		m.metrics.observeRefresh(RefreshOutcomeReused)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, refreshToken, err := m.issue(clm)
 	if err != nil {
+		m.metrics.observeRefresh(RefreshOutcomeError)
 		c.Status(http.StatusInternalServerError)
-		log.Printf("生成 access token 失败; err: %v", err)
+		log.Println(err)
 		return
 	}
 
 	m.accessTokenSetterFn(c, accessToken)
-
-	// 轮换刷新令牌
-	if m.rotateRefreshToken {
-		refreshToken, err := m.refreshTM.GenerateToken(clm)
-		if err != nil {
-			c.Status(http.StatusInternalServerError)
-			log.Printf("生成 refresh token 失败; err: %v", err)
-			return
-		}
+	if refreshToken != "" {
 		m.refreshTokenSetterFn(c, refreshToken)
 	}
+	m.metrics.observeRefresh(RefreshOutcomeOK)
 	m.responseHandler(c)
 }
 
+// issue 生成资源令牌. 开启轮换时同时生成刷新令牌, 否则返回的刷新令牌为空.
+func (m *RefreshManager[T]) issue(clm T) (string, string, error) {
+	accessToken, err := m.accessTM.GenerateToken(clm)
+	if err != nil {
+		return "", "", fmt.Errorf("生成 access token 失败; err: %w", err)
+	}
+	if !m.rotateRefreshToken {
+		return accessToken, "", nil
+	}
+
+	// 轮换刷新令牌
+	refreshToken, err := m.refreshTM.GenerateToken(clm)
+	if err != nil {
+		return "", "", fmt.Errorf("生成 refresh token 失败; err: %w", err)
+	}
+	m.metrics.observeRotation()
+	return accessToken, refreshToken, nil
+}
+
 func (m *RefreshManager[T]) WithOptions(opts ...Option[T]) *RefreshManager[T] {
 	c := m.clone()
 	for _, opt := range opts {
@@ -0,0 +1,178 @@
+package jwt
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrCodeInsufficientUserAuthentication 认证强度不足的错误码.
+// 详见 https://datatracker.ietf.org/doc/html/rfc9470
+const ErrCodeInsufficientUserAuthentication = "insufficient_user_authentication"
+
+// AuthenticationContext 定义获取用户认证信息的接口.
+type AuthenticationContext interface {
+	// GetAuthTime 用户认证的时间.
+	GetAuthTime() *jwt.NumericDate
+	// GetACR 认证上下文类别 (Authentication Context Class Reference).
+	GetACR() string
+	// GetAMR 认证方式 (Authentication Methods References), 例如 pwd, otp.
+	GetAMR() []string
+}
+
+// AuthenticationClaims 定义用户认证信息的 claims.
+// 可以嵌入到自定义的 Claims 中以实现 AuthenticationContext 接口.
+// 详见 https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type AuthenticationClaims struct {
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+}
+
+// GetAuthTime implements the AuthenticationContext interface.
+func (c AuthenticationClaims) GetAuthTime() *jwt.NumericDate {
+	return c.AuthTime
+}
+
+// GetACR implements the AuthenticationContext interface.
+func (c AuthenticationClaims) GetACR() string {
+	return c.ACR
+}
+
+// GetAMR implements the AuthenticationContext interface.
+func (c AuthenticationClaims) GetAMR() []string {
+	return c.AMR
+}
+
+// StepUp 记录一次新的认证: 把 auth_time 更新为 at 并把 method 加入 amr.
+func (c *AuthenticationClaims) StepUp(method string, at time.Time) {
+	c.AuthTime = jwt.NewNumericDate(at)
+	if !slices.Contains(c.AMR, method) {
+		c.AMR = append(slices.Clone(c.AMR), method)
+	}
+}
+
+// StepUpBuilder 定义要求用户认证足够新或足够强的中间件构建器.
+// 需要在认证中间件之后使用. 通常每个路由创建一个构建器:
+//
+//	r.POST("/payouts", NewStepUpBuilder[Claims]().MaxAge(5*time.Minute).RequireACR("mfa").Build(), handler)
+type StepUpBuilder[T interface {
+	jwt.Claims
+	AuthenticationContext
+}] struct {
+	// getClaims 获取 Claims.
+	// 默认使用 ClaimsFromContext[T] 获取.
+	getClaims func(*gin.Context) (T, bool)
+
+	// maxAge 距离用户认证的最长时间. 为 0 时不限制.
+	maxAge time.Duration
+
+	// acrValues 允许的 acr. 为空时不限制.
+	acrValues []string
+
+	// amrValues 要求全部包含的 amr. 为空时不限制.
+	amrValues []string
+
+	timeFunc func() time.Time
+}
+
+// NewStepUpBuilder 创建一个要求用户认证足够新或足够强的中间件构建器.
+func NewStepUpBuilder[T interface {
+	jwt.Claims
+	AuthenticationContext
+}]() *StepUpBuilder[T] {
+	return &StepUpBuilder[T]{
+		getClaims: func(c *gin.Context) (T, bool) {
+			return ClaimsFromContext[T](c.Request.Context())
+		},
+		timeFunc: time.Now,
+	}
+}
+
+// SetGetClaimsFunc 设置获取 Claims 的方法.
+// 需要与认证中间件中设置 Claims 的方式匹配.
+func (b *StepUpBuilder[T]) SetGetClaimsFunc(fn func(*gin.Context) (T, bool)) *StepUpBuilder[T] {
+	b.getClaims = fn
+	return b
+}
+
+// MaxAge 要求用户在 d 时间内完成过认证.
+func (b *StepUpBuilder[T]) MaxAge(d time.Duration) *StepUpBuilder[T] {
+	b.maxAge = d
+	return b
+}
+
+// RequireACR 要求 acr 为 values 中的一个.
+func (b *StepUpBuilder[T]) RequireACR(values ...string) *StepUpBuilder[T] {
+	b.acrValues = values
+	return b
+}
+
+// RequireAMR 要求 amr 包含全部的 values.
+func (b *StepUpBuilder[T]) RequireAMR(values ...string) *StepUpBuilder[T] {
+	b.amrValues = values
+	return b
+}
+
+// Build 构建中间件.
+// 用户认证不满足要求时返回 401, 并在 WWW-Authenticate 中返回
+// insufficient_user_authentication 以及要求的 acr_values 与 max_age.
+func (b *StepUpBuilder[T]) Build() gin.HandlerFunc {
+	maxAge := b.maxAge
+	acrValues := slices.Clone(b.acrValues)
+	amrValues := slices.Clone(b.amrValues)
+	return func(c *gin.Context) {
+		clm, ok := b.getClaims(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if maxAge > 0 {
+			authTime := clm.GetAuthTime()
+			if authTime == nil || b.timeFunc().Sub(authTime.Time) > maxAge {
+				b.challenge(c, "A more recent authentication is required", maxAge, acrValues)
+				return
+			}
+		}
+		if len(acrValues) > 0 && !slices.Contains(acrValues, clm.GetACR()) {
+			b.challenge(c, "A different authentication level is required", maxAge, acrValues)
+			return
+		}
+		amr := clm.GetAMR()
+		for _, v := range amrValues {
+			if !slices.Contains(amr, v) {
+				b.challenge(c, "A different authentication method is required", maxAge, acrValues)
+				return
+			}
+		}
+	}
+}
+
+// challenge 返回 RFC 9470 定义的 401 响应.
+func (b *StepUpBuilder[T]) challenge(c *gin.Context, desc string,
+	maxAge time.Duration, acrValues []string) {
+	var sb strings.Builder
+	sb.WriteString(bearerPrefix)
+	sb.WriteString(` error="`)
+	sb.WriteString(ErrCodeInsufficientUserAuthentication)
+	sb.WriteString(`", error_description="`)
+	sb.WriteString(desc)
+	sb.WriteString(`"`)
+	if len(acrValues) > 0 {
+		sb.WriteString(`, acr_values="`)
+		sb.WriteString(strings.Join(acrValues, " "))
+		sb.WriteString(`"`)
+	}
+	if maxAge > 0 {
+		sb.WriteString(`, max_age=`)
+		sb.WriteString(strconv.FormatInt(int64(maxAge/time.Second), 10))
+	}
+	c.Header("WWW-Authenticate", sb.String())
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
@@ -0,0 +1,132 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+)
+
+type stepUpClaims struct {
+	jwtcore.RegisteredClaims
+	AuthenticationClaims
+}
+
+func TestStepUpBuilder_Build(t *testing.T) {
+	nowTime := time.UnixMilli(1695571200000)
+	tests := []struct {
+		name          string
+		builder       func() *StepUpBuilder[stepUpClaims]
+		claims        *stepUpClaims
+		wantCode      int
+		wantChallenge string
+	}{
+		{
+			name: "pass",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().MaxAge(5*time.Minute).
+					RequireACR("mfa", "phr").RequireAMR("pwd", "otp")
+			},
+			claims: &stepUpClaims{AuthenticationClaims: AuthenticationClaims{
+				AuthTime: jwt.NewNumericDate(nowTime.Add(-time.Minute)),
+				ACR:      "mfa",
+				AMR:      []string{"pwd", "otp"},
+			}},
+			wantCode: http.StatusOK,
+		},
+		{
+			// 认证时间过久
+			name: "too_old",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().MaxAge(5 * time.Minute)
+			},
+			claims: &stepUpClaims{AuthenticationClaims: AuthenticationClaims{
+				AuthTime: jwt.NewNumericDate(nowTime.Add(-10 * time.Minute)),
+			}},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=300`,
+		},
+		{
+			// 没有认证时间
+			name: "no_auth_time",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().MaxAge(5 * time.Minute)
+			},
+			claims:        &stepUpClaims{},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=300`,
+		},
+		{
+			// 认证强度不足
+			name: "acr_mismatch",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().RequireACR("mfa")
+			},
+			claims: &stepUpClaims{AuthenticationClaims: AuthenticationClaims{
+				ACR: "pwd",
+			}},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="A different authentication level is required", acr_values="mfa"`,
+		},
+		{
+			// 缺少认证方式
+			name: "amr_missing",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().RequireAMR("otp")
+			},
+			claims: &stepUpClaims{AuthenticationClaims: AuthenticationClaims{
+				AMR: []string{"pwd"},
+			}},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer error="insufficient_user_authentication", error_description="A different authentication method is required"`,
+		},
+		{
+			// 没有 claims
+			name: "no_claims",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().MaxAge(5 * time.Minute)
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "another_get_claims_func",
+			builder: func() *StepUpBuilder[stepUpClaims] {
+				return NewStepUpBuilder[stepUpClaims]().RequireACR("mfa").
+					SetGetClaimsFunc(func(*gin.Context) (stepUpClaims, bool) {
+						return stepUpClaims{AuthenticationClaims: AuthenticationClaims{ACR: "mfa"}}, true
+					})
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.builder()
+			b.timeFunc = func() time.Time { return nowTime }
+			server := gin.Default()
+			server.Use(func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Request = c.Request.WithContext(
+						ContextWithClaims(c.Request.Context(), *tt.claims))
+				}
+			})
+			server.GET("/", b.Build(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantChallenge, recorder.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenSourceWebSocketProtocol token 来源为 Sec-WebSocket-Protocol 请求头.
+const TokenSourceWebSocketProtocol = "websocket_protocol"
+
+// reauthMessageType 重新认证消息的类型.
+const reauthMessageType = "reauth"
+
+var (
+	// ErrStreamTokenExpired 长连接的 token 已过期.
+	ErrStreamTokenExpired = errors.New("长连接的 token 已过期")
+	// ErrStreamTokenRevoked 长连接的 token 已被吊销.
+	ErrStreamTokenRevoked = errors.New("长连接的 token 已被吊销")
+	// ErrStreamClosed 长连接已关闭.
+	ErrStreamClosed = errors.New("长连接已关闭")
+	// ErrSubjectMismatch 重新认证的 token 与原 token 的主体不一致.
+	ErrSubjectMismatch = errors.New("token 的主体不一致")
+)
+
+// WebSocketProtocolExtractor 从 Sec-WebSocket-Protocol 请求头中提取 token.
+// 浏览器无法为 WebSocket 设置 Authorization 请求头, 因此可以把 token
+// 作为子协议传递, 例如 new WebSocket(url, ["chat", "bearer.<token>"]).
+// 使用 prefix="bearer." 时提取到 "<token>".
+// 注意: 升级连接时服务端应选择其他的子协议返回, 不要回显 token.
+func WebSocketProtocolExtractor(prefix string) TokenExtractor {
+	return TokenExtractor{
+		Source: TokenSourceWebSocketProtocol,
+		Extract: func(c *gin.Context) string {
+			for _, v := range c.Request.Header.Values("Sec-WebSocket-Protocol") {
+				for _, protocol := range strings.Split(v, ",") {
+					tokenStr, ok := strings.CutPrefix(strings.TrimSpace(protocol), prefix)
+					if ok && tokenStr != "" {
+						return tokenStr
+					}
+				}
+			}
+			return ""
+		},
+	}
+}
+
+// StreamBuilder 定义长连接 (WebSocket, SSE) 认证的中间件构建器.
+// 使用 MiddlewareBuilder 认证建立连接的请求, 并为连接创建 StreamSession:
+//   - 请求的 context.Context 在 token 过期或被吊销时取消, Deadline() 返回 token 的过期时间.
+//   - 可以通过 StreamSession.Reauthenticate 使用新的 token 延长连接.
+type StreamBuilder[T jwt.Claims] struct {
+	// auth 认证建立连接的请求, 同时使用其 TokenManager 校验重新认证的 token.
+	auth *MiddlewareBuilder[T]
+
+	// getClaims 获取 Claims.
+	// 默认使用 ClaimsFromContext[T] 获取.
+	getClaims func(*gin.Context) (T, bool)
+
+	// isRevoked 判断 token 是否已被吊销.
+	// 默认为 nil 也就是不检查.
+	isRevoked func(context.Context, T) bool
+
+	// revocationCheckInterval 检查 token 是否已被吊销的间隔.
+	revocationCheckInterval time.Duration
+
+	timeFunc func() time.Time
+}
+
+// NewStreamBuilder 创建一个长连接认证的中间件构建器.
+// 通常 auth 需要支持从查询参数或 Sec-WebSocket-Protocol 中提取 token:
+//
+//	auth := NewMiddlewareBuilder[Claims](accessTM).SetExtractors(
+//		WebSocketProtocolExtractor("bearer."), QueryExtractor("access_token"))
+//	r.GET("/ws", NewStreamBuilder[Claims](auth).Build(), wsHandler)
+func NewStreamBuilder[T jwt.Claims](auth *MiddlewareBuilder[T]) *StreamBuilder[T] {
+	return &StreamBuilder[T]{
+		auth: auth,
+		getClaims: func(c *gin.Context) (T, bool) {
+			return ClaimsFromContext[T](c.Request.Context())
+		},
+		timeFunc: time.Now,
+	}
+}
+
+// SetGetClaimsFunc 设置获取 Claims 的方法.
+// 需要与 auth 中设置 Claims 的方式匹配.
+func (b *StreamBuilder[T]) SetGetClaimsFunc(fn func(*gin.Context) (T, bool)) *StreamBuilder[T] {
+	b.getClaims = fn
+	return b
+}
+
+// SetRevokedFunc 设置判断 token 是否已被吊销的方法, 每隔 interval 检查一次.
+// token 被吊销时取消连接的 context.Context.
+func (b *StreamBuilder[T]) SetRevokedFunc(fn func(context.Context, T) bool,
+	interval time.Duration) *StreamBuilder[T] {
+	b.isRevoked = fn
+	b.revocationCheckInterval = interval
+	return b
+}
+
+// Build 构建长连接认证的中间件.
+// 通过 StreamSessionFromContext 获取连接的 StreamSession.
+func (b *StreamBuilder[T]) Build() gin.HandlerFunc {
+	auth := b.auth.Build()
+	return func(c *gin.Context) {
+		auth(c)
+		if c.IsAborted() {
+			return
+		}
+		clm, ok := b.getClaims(c)
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		s := b.newSession(c.Request.Context(), clm)
+		defer s.Close()
+		c.Request = c.Request.WithContext(s.Context())
+		c.Next()
+	}
+}
+
+func (b *StreamBuilder[T]) newSession(parent context.Context, clm T) *StreamSession[T] {
+	ctx, cancel := context.WithCancelCause(parent)
+	s := &StreamSession[T]{
+		builder: b,
+		cancel:  cancel,
+	}
+	s.ctx = &streamContext{Context: context.WithValue(ctx, streamSessionKey{}, s), deadline: s.ExpiresAt}
+	s.setClaims(clm)
+	if b.isRevoked != nil && b.revocationCheckInterval > 0 {
+		go s.checkRevocation()
+	}
+	return s
+}
+
+// StreamSession 定义长连接的认证会话.
+// 并发安全.
+type StreamSession[T jwt.Claims] struct {
+	builder *StreamBuilder[T]
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	mu        sync.Mutex
+	claims    T
+	expiresAt time.Time
+	timer     *time.Timer
+	// generation 防止过期的定时器取消重新认证后的连接.
+	generation int
+}
+
+// streamSessionKey 定义从 context.Context 中获取 StreamSession 的 key.
+type streamSessionKey struct{}
+
+// StreamSessionFromContext 从 context 中获取长连接的认证会话.
+func StreamSessionFromContext[T jwt.Claims](ctx context.Context) (*StreamSession[T], bool) {
+	s, ok := ctx.Value(streamSessionKey{}).(*StreamSession[T])
+	return s, ok
+}
+
+// Context 返回连接的 context.Context.
+// token 过期或被吊销时取消, 通过 context.Cause 获取原因
+// (ErrStreamTokenExpired, ErrStreamTokenRevoked).
+func (s *StreamSession[T]) Context() context.Context {
+	return s.ctx
+}
+
+// Claims 返回当前的 claims.
+func (s *StreamSession[T]) Claims() T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claims
+}
+
+// ExpiresAt 返回当前 token 的过期时间. token 没有过期时间时返回零值.
+func (s *StreamSession[T]) ExpiresAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiresAt
+}
+
+// Reauthenticate 使用新的 token 重新认证, 成功后连接的过期时间延长为新 token 的过期时间.
+// 新 token 的主体必须与原 token 相同.
+func (s *StreamSession[T]) Reauthenticate(tokenStr string) error {
+	if s.ctx.Err() != nil {
+		return ErrStreamClosed
+	}
+	clm, err := s.builder.auth.TokenManager.VerifyToken(tokenStr)
+	if err != nil {
+		return err
+	}
+	oldSub, _ := s.Claims().GetSubject()
+	newSub, _ := clm.GetSubject()
+	if oldSub != newSub {
+		return ErrSubjectMismatch
+	}
+	if s.builder.isRevoked != nil && s.builder.isRevoked(s.ctx, clm) {
+		return ErrStreamTokenRevoked
+	}
+	s.setClaims(clm)
+	return nil
+}
+
+// HandleMessage 处理连接中的重新认证消息:
+//
+//	{"type": "reauth", "token": "xxxx"}
+//
+// 不是重新认证消息时返回 false.
+func (s *StreamSession[T]) HandleMessage(data []byte) (bool, error) {
+	var msg struct {
+		Type  string `json:"type"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != reauthMessageType {
+		return false, nil
+	}
+	return true, s.Reauthenticate(msg.Token)
+}
+
+// Close 关闭会话并取消连接的 context.Context.
+func (s *StreamSession[T]) Close() {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.cancel(ErrStreamClosed)
+}
+
+// setClaims 更新 claims 并按过期时间重置定时器.
+func (s *StreamSession[T]) setClaims(clm T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = clm
+	s.generation++
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.expiresAt = time.Time{}
+	exp, _ := clm.GetExpirationTime()
+	if exp == nil {
+		return
+	}
+	s.expiresAt = exp.Time
+	gen := s.generation
+	s.timer = time.AfterFunc(exp.Sub(s.builder.timeFunc()), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.generation == gen {
+			s.cancel(ErrStreamTokenExpired)
+		}
+	})
+}
+
+func (s *StreamSession[T]) checkRevocation() {
+	ticker := time.NewTicker(s.builder.revocationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.builder.isRevoked(s.ctx, s.Claims()) {
+				s.cancel(ErrStreamTokenRevoked)
+				return
+			}
+		}
+	}
+}
+
+// streamContext 使 Deadline() 返回 token 的过期时间.
+type streamContext struct {
+	context.Context
+	deadline func() time.Time
+}
+
+func (c *streamContext) Deadline() (time.Time, bool) {
+	d := c.deadline()
+	parent, ok := c.Context.Deadline()
+	switch {
+	case d.IsZero():
+		return parent, ok
+	case ok && parent.Before(d):
+		return parent, true
+	default:
+		return d, true
+	}
+}
@@ -0,0 +1,176 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+
+	"github.com/udugong/ginx/auth/jwt/jwttest"
+)
+
+func TestWebSocketProtocolExtractor(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+		want      string
+	}{
+		{
+			name:      "normal",
+			protocols: []string{"chat, bearer.token"},
+			want:      "token",
+		},
+		{
+			name:      "multiple_headers",
+			protocols: []string{"chat", "bearer.token"},
+			want:      "token",
+		},
+		{
+			name:      "not_found",
+			protocols: []string{"chat"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/ws", nil)
+			require.NoError(t, err)
+			for _, p := range tt.protocols {
+				req.Header.Add("Sec-WebSocket-Protocol", p)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			e := WebSocketProtocolExtractor("bearer.")
+			assert.Equal(t, TokenSourceWebSocketProtocol, e.Source)
+			assert.Equal(t, tt.want, e.Extract(c))
+		})
+	}
+}
+
+func TestStreamBuilder_Build(t *testing.T) {
+	// jwt.NewNumericDate 会截断到秒, 这里直接构造以精确控制过期时间
+	newClaims := func(sub string, expire time.Duration) Claims {
+		return Claims{RegisteredClaims: jwtcore.RegisteredClaims{
+			Subject: sub, ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(expire)},
+		}}
+	}
+	tests := []struct {
+		name string
+		// handler 连接的处理函数, 返回连接结束的原因
+		handler   func(t *testing.T, tm *jwttest.Manager[Claims], s *StreamSession[Claims]) error
+		clm       Claims
+		revoked   bool
+		wantCause error
+	}{
+		{
+			// token 过期时取消连接
+			name: "expired",
+			clm:  newClaims("1", 50*time.Millisecond),
+			handler: func(t *testing.T, _ *jwttest.Manager[Claims], s *StreamSession[Claims]) error {
+				deadline, ok := s.Context().Deadline()
+				assert.True(t, ok)
+				assert.Equal(t, s.ExpiresAt(), deadline)
+				<-s.Context().Done()
+				return context.Cause(s.Context())
+			},
+			wantCause: ErrStreamTokenExpired,
+		},
+		{
+			// 重新认证延长连接
+			name: "reauthenticate",
+			clm:  newClaims("1", 100*time.Millisecond),
+			handler: func(t *testing.T, tm *jwttest.Manager[Claims], s *StreamSession[Claims]) error {
+				fresh := jwttest.Mint[Claims](t, tm, newClaims("1", time.Hour))
+				handled, err := s.HandleMessage([]byte(`{"type":"reauth","token":"` + fresh + `"}`))
+				require.True(t, handled)
+				require.NoError(t, err)
+				select {
+				case <-s.Context().Done():
+					return context.Cause(s.Context())
+				case <-time.After(200 * time.Millisecond):
+				}
+				assert.True(t, s.ExpiresAt().After(time.Now().Add(time.Minute)))
+				return nil
+			},
+		},
+		{
+			// 重新认证的主体不一致
+			name: "reauthenticate_subject_mismatch",
+			clm:  newClaims("1", time.Hour),
+			handler: func(t *testing.T, tm *jwttest.Manager[Claims], s *StreamSession[Claims]) error {
+				other := jwttest.Mint[Claims](t, tm, newClaims("2", time.Hour))
+				return s.Reauthenticate(other)
+			},
+			wantCause: ErrSubjectMismatch,
+		},
+		{
+			// 不是重新认证的消息
+			name: "other_message",
+			clm:  newClaims("1", time.Hour),
+			handler: func(t *testing.T, _ *jwttest.Manager[Claims], s *StreamSession[Claims]) error {
+				handled, err := s.HandleMessage([]byte(`{"type":"chat"}`))
+				assert.False(t, handled)
+				return err
+			},
+		},
+		{
+			// token 被吊销时取消连接
+			name:    "revoked",
+			clm:     newClaims("1", time.Hour),
+			revoked: true,
+			handler: func(t *testing.T, _ *jwttest.Manager[Claims], s *StreamSession[Claims]) error {
+				<-s.Context().Done()
+				return context.Cause(s.Context())
+			},
+			wantCause: ErrStreamTokenRevoked,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := jwttest.NewManager[Claims](nil)
+			tokenStr := jwttest.Mint[Claims](t, tm, tt.clm)
+			auth := NewMiddlewareBuilder[Claims](tm).SetExtractors(
+				WebSocketProtocolExtractor("bearer."), QueryExtractor("access_token"))
+			var revoked atomic.Bool
+			b := NewStreamBuilder[Claims](auth).SetRevokedFunc(
+				func(context.Context, Claims) bool {
+					return revoked.Load()
+				}, 10*time.Millisecond)
+			revoked.Store(tt.revoked)
+
+			var gotCause error
+			server := gin.New()
+			server.GET("/ws", b.Build(), func(c *gin.Context) {
+				s, ok := StreamSessionFromContext[Claims](c.Request.Context())
+				require.True(t, ok)
+				gotCause = tt.handler(t, tm, s)
+				c.Status(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, jwttest.NewRequest(http.MethodGet, "/ws", nil,
+				jwttest.Query("access_token", tokenStr)))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.ErrorIs(t, gotCause, tt.wantCause)
+		})
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		tm := jwttest.NewManager[Claims](nil)
+		server := gin.New()
+		server.GET("/ws", NewStreamBuilder[Claims](NewMiddlewareBuilder[Claims](tm)).Build(),
+			func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, jwttest.NewRequest(http.MethodGet, "/ws", nil))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
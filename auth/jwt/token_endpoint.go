@@ -0,0 +1,148 @@
+package jwt
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// grantTypeRefreshToken 刷新令牌的授权类型.
+const grantTypeRefreshToken = "refresh_token"
+
+// TokenResponse 定义令牌端点的成功响应.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// TokenHandler 符合 OAuth 2.0 的令牌端点的 gin.HandlerFunc.
+// 请求为 application/x-www-form-urlencoded 格式:
+//
+//	grant_type=refresh_token&refresh_token=xxxx
+//
+// 设置了 WithClientAuthenticator 时, 客户端凭证可以通过 Basic 认证或表单中的
+// client_id 与 client_secret 传递.
+//
+// 设置了 WithClientAuthenticator 并且 Claims 嵌入了 ClientClaims 时, 刷新令牌只能由记录的客户端使用,
+// 否则返回 invalid_grant; 刷新令牌没有记录客户端时, 轮换的刷新令牌记录本次认证的客户端.
+// 没有设置 WithClientAuthenticator 时无法确认客户端, 不检查也不记录客户端.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6749#section-6
+func (m *RefreshManager[T]) TokenHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	// clientID 认证通过的客户端
+	var clientID string
+	if m.authenticateClient != nil {
+		id, secret, ok := clientCredentials(c)
+		if !ok || !m.authenticateClient(c.Request.Context(), id, secret) {
+			m.metrics.observeRefresh(RefreshOutcomeUnauthorized)
+			if _, _, basic := c.Request.BasicAuth(); basic {
+				c.Header("WWW-Authenticate", `Basic realm="token"`)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, OAuthError{Error: ErrCodeInvalidClient})
+			return
+		}
+		clientID = id
+	}
+
+	switch c.PostForm("grant_type") {
+	case grantTypeRefreshToken:
+	case "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{
+			Error: ErrCodeInvalidRequest, ErrorDescription: "缺少 grant_type"})
+		return
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{Error: ErrCodeUnsupportedGrantType})
+		return
+	}
+
+	refreshTokenStr := c.PostForm("refresh_token")
+	if refreshTokenStr == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{
+			Error: ErrCodeInvalidRequest, ErrorDescription: "缺少 refresh_token"})
+		return
+	}
+
+	clm, err := m.refreshTM.VerifyToken(refreshTokenStr)
+	if err != nil {
+		m.metrics.observeRefresh(RefreshOutcomeUnauthorized)
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{Error: ErrCodeInvalidGrant})
+		return
+	}
+	// 在检测重复使用之前检查客户端, 其他客户端不能消耗刷新令牌
+	if m.authenticateClient != nil && !bindClient(&clm, clientID) {
+		m.metrics.observeRefresh(RefreshOutcomeUnauthorized)
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{
+			Error: ErrCodeInvalidGrant, ErrorDescription: "刷新令牌不是签发给该客户端的"})
+		return
+	}
+	if m.isReused(c, clm) {
+		m.metrics.observeRefresh(RefreshOutcomeReused)
+		c.AbortWithStatusJSON(http.StatusBadRequest, OAuthError{Error: ErrCodeInvalidGrant})
+		return
+	}
+
+	accessToken, refreshToken, err := m.issue(clm)
+	if err != nil {
+		m.metrics.observeRefresh(RefreshOutcomeError)
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, OAuthError{Error: ErrCodeServerError})
+		return
+	}
+	m.metrics.observeRefresh(RefreshOutcomeOK)
+	c.JSON(http.StatusOK, TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    bearerPrefix,
+		ExpiresIn:    expiresIn(accessToken),
+		RefreshToken: refreshToken,
+	})
+}
+
+// bindClient 检查刷新令牌是否签发给 clientID.
+// clm 记录了客户端时, 与 clientID 不同返回 false; 没有记录时把 clientID 记录到 clm 中.
+// clm 没有实现 GetClientID 或 SetClientID 时不检查或不记录.
+func bindClient[T jwt.Claims](clm *T, clientID string) bool {
+	if c, ok := any(*clm).(interface{ GetClientID() string }); ok {
+		if bound := c.GetClientID(); bound != "" {
+			return bound == clientID
+		}
+	}
+	if clientID == "" {
+		return true
+	}
+	// T 为结构体时通过指针设置, T 为指针时直接设置
+	if c, ok := any(clm).(interface{ SetClientID(string) }); ok {
+		c.SetClientID(clientID)
+	} else if c, ok := any(*clm).(interface{ SetClientID(string) }); ok {
+		c.SetClientID(clientID)
+	}
+	return true
+}
+
+// expiresIn 获取刚签发的 jwt 的有效时间, 单位为秒.
+// 优先使用 exp 与 iat 的差值, 无法获取时返回 0.
+func expiresIn(tokenStr string) int64 {
+	var clm jwt.RegisteredClaims
+	_, _, err := jwt.NewParser().ParseUnverified(tokenStr, &clm)
+	if err != nil || clm.ExpiresAt == nil {
+		return 0
+	}
+	var d time.Duration
+	if clm.IssuedAt != nil {
+		d = clm.ExpiresAt.Sub(clm.IssuedAt.Time)
+	} else {
+		d = time.Until(clm.ExpiresAt.Time)
+	}
+	if d < 0 {
+		return 0
+	}
+	return int64(d.Round(time.Second).Seconds())
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidCookie cookie 中的会话 id 校验失败.
+var ErrInvalidCookie = errors.New("会话 cookie 不合法")
+
+// Codec 定义会话 id 与 cookie 值之间的编解码.
+type Codec interface {
+	// Encode 把会话 id 编码为 cookie 值.
+	Encode(id string) (string, error)
+	// Decode 把 cookie 值解码为会话 id. 校验失败时返回 ErrInvalidCookie.
+	Decode(value string) (string, error)
+}
+
+// SignedCodec 使用 HMAC-SHA256 对会话 id 签名.
+// cookie 值的格式为 "<id>.<signature>".
+type SignedCodec struct {
+	keys [][]byte
+}
+
+// NewSignedCodec 创建一个签名的编解码器.
+// 使用第一个 key 签名, 使用全部的 key 校验, 以便轮换密钥.
+func NewSignedCodec(key []byte, oldKeys ...[]byte) *SignedCodec {
+	return &SignedCodec{keys: append([][]byte{key}, oldKeys...)}
+}
+
+func (s *SignedCodec) Encode(id string) (string, error) {
+	return id + "." + s.sign(s.keys[0], id), nil
+}
+
+func (s *SignedCodec) Decode(value string) (string, error) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range s.keys {
+		if hmac.Equal([]byte(sig), []byte(s.sign(key, id))) {
+			return id, nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+func (s *SignedCodec) sign(key []byte, id string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptedCodec 使用 AES-GCM 加密会话 id.
+// 客户端无法读取或篡改会话 id.
+type EncryptedCodec struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCodec 创建一个加密的编解码器.
+// key 的长度必须为 16, 24 或 32 字节. 使用第一个 key 加密, 使用全部的 key 解密, 以便轮换密钥.
+func NewEncryptedCodec(key []byte, oldKeys ...[]byte) (*EncryptedCodec, error) {
+	keys := append([][]byte{key}, oldKeys...)
+	aeads := make([]cipher.AEAD, 0, len(keys))
+	for _, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	return &EncryptedCodec{aeads: aeads}, nil
+}
+
+func (e *EncryptedCodec) Encode(id string) (string, error) {
+	aead := e.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(id)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(id), nil)), nil
+}
+
+func (e *EncryptedCodec) Decode(value string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, aead := range e.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if id, err := aead.Open(nil, nonce, ciphertext, nil); err == nil && len(id) > 0 {
+			return string(id), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
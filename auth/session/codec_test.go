@@ -0,0 +1,109 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	newEncrypted := func(key []byte, oldKeys ...[]byte) Codec {
+		codec, err := NewEncryptedCodec(key, oldKeys...)
+		require.NoError(t, err)
+		return codec
+	}
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	tests := []struct {
+		name string
+		// encoder 编码使用的 Codec
+		encoder Codec
+		// decoder 解码使用的 Codec
+		decoder Codec
+		// tamper 修改编码后的值
+		tamper  func(string) string
+		wantErr error
+	}{
+		{
+			name:    "signed",
+			encoder: NewSignedCodec(newKey),
+			decoder: NewSignedCodec(newKey),
+		},
+		{
+			// 轮换密钥后仍可以解码旧 cookie
+			name:    "signed_rotated",
+			encoder: NewSignedCodec(oldKey),
+			decoder: NewSignedCodec(newKey, oldKey),
+		},
+		{
+			name:    "signed_wrong_key",
+			encoder: NewSignedCodec(oldKey),
+			decoder: NewSignedCodec(newKey),
+			wantErr: ErrInvalidCookie,
+		},
+		{
+			name:    "signed_tampered",
+			encoder: NewSignedCodec(newKey),
+			decoder: NewSignedCodec(newKey),
+			tamper: func(s string) string {
+				return "other" + s
+			},
+			wantErr: ErrInvalidCookie,
+		},
+		{
+			name:    "encrypted",
+			encoder: newEncrypted(newKey),
+			decoder: newEncrypted(newKey),
+		},
+		{
+			name:    "encrypted_rotated",
+			encoder: newEncrypted(oldKey),
+			decoder: newEncrypted(newKey, oldKey),
+		},
+		{
+			name:    "encrypted_wrong_key",
+			encoder: newEncrypted(oldKey),
+			decoder: newEncrypted(newKey),
+			wantErr: ErrInvalidCookie,
+		},
+		{
+			name:    "encrypted_tampered",
+			encoder: newEncrypted(newKey),
+			decoder: newEncrypted(newKey),
+			tamper: func(s string) string {
+				return s[:len(s)-2] + "AA"
+			},
+			wantErr: ErrInvalidCookie,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := tt.encoder.Encode("session-id")
+			require.NoError(t, err)
+			if tt.tamper != nil {
+				value = tt.tamper(value)
+			}
+			id, err := tt.decoder.Decode(value)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, "session-id", id)
+			}
+		})
+	}
+}
+
+func TestEncryptedCodec_Encode(t *testing.T) {
+	codec, err := NewEncryptedCodec([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	v1, err := codec.Encode("session-id")
+	require.NoError(t, err)
+	v2, err := codec.Encode("session-id")
+	require.NoError(t, err)
+	// 每次加密使用随机的 nonce
+	assert.NotEqual(t, v1, v2)
+	assert.NotContains(t, v1, "session-id")
+
+	_, err = NewEncryptedCodec([]byte("short"))
+	assert.Error(t, err)
+}
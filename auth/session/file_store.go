@@ -0,0 +1,114 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileSuffix 会话文件的后缀.
+const fileSuffix = ".session"
+
+// FileStore 基于文件的 Store. 每个会话保存为目录中的一个文件.
+// 文件名为会话 id 的 SHA-256, 文件内容为 8 字节的过期时间 (Unix 纳秒) 加会话数据.
+type FileStore struct {
+	dir      string
+	timeFunc func() time.Time
+}
+
+// NewFileStore 创建一个基于文件的 Store. dir 不存在时创建.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, timeFunc: time.Now}, nil
+}
+
+func (s *FileStore) Get(_ context.Context, id string) ([]byte, error) {
+	name := s.filename(id)
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.expired(b) {
+		_ = os.Remove(name)
+		return nil, ErrNotFound
+	}
+	return b[8:], nil
+}
+
+func (s *FileStore) Set(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(b, uint64(s.timeFunc().Add(ttl).UnixNano()))
+	b = append(b, data...)
+
+	// 先写入临时文件再重命名, 避免读取到写了一半的文件
+	f, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.filename(id))
+}
+
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	err := os.Remove(s.filename(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup 删除全部过期的会话文件.
+func (s *FileStore) Cleanup() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		name := filepath.Join(s.dir, e.Name())
+		b, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if s.expired(b) {
+			_ = os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// filename 使用会话 id 的哈希作为文件名, 避免路径穿越.
+func (s *FileStore) filename(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileSuffix)
+}
+
+func (s *FileStore) expired(b []byte) bool {
+	if len(b) < 8 {
+		return true
+	}
+	expiresAt := int64(binary.BigEndian.Uint64(b[:8]))
+	return s.timeFunc().UnixNano() >= expiresAt
+}
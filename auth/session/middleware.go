@@ -0,0 +1,243 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CookieOptions 定义会话 cookie 的属性.
+type CookieOptions struct {
+	Name     string
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// MiddlewareBuilder 定义服务端会话的中间件构建器.
+//   - cookie 中只保存经过签名或加密的会话 id, 会话数据保存在 Store 中.
+//   - 超过空闲时间没有访问或超过绝对时间的会话失效.
+//   - 会话在响应头写入之前自动保存, 新创建且没有修改的会话不会保存.
+type MiddlewareBuilder struct {
+	store Store
+	codec Codec
+
+	// cookie 会话 cookie 的属性.
+	// 默认为 Name="ginx_session", Path="/", HttpOnly=true, SameSite=Lax.
+	cookie CookieOptions
+
+	// idleTimeout 空闲超时时间, 必须大于 0. 默认为 30 分钟.
+	idleTimeout time.Duration
+
+	// absoluteTimeout 绝对超时时间, 从会话创建开始计算. 为 0 时不限制.
+	// 默认为 12 小时.
+	absoluteTimeout time.Duration
+
+	timeFunc func() time.Time
+}
+
+// NewMiddlewareBuilder 创建一个服务端会话的中间件构建器.
+// codec 可以使用 NewSignedCodec 或 NewEncryptedCodec.
+func NewMiddlewareBuilder(store Store, codec Codec) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		store: store,
+		codec: codec,
+		cookie: CookieOptions{
+			Name:     "ginx_session",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		idleTimeout:     30 * time.Minute,
+		absoluteTimeout: 12 * time.Hour,
+		timeFunc:        time.Now,
+	}
+}
+
+// SetCookieOptions 设置会话 cookie 的属性.
+func (b *MiddlewareBuilder) SetCookieOptions(opts CookieOptions) *MiddlewareBuilder {
+	b.cookie = opts
+	return b
+}
+
+// SetIdleTimeout 设置空闲超时时间.
+func (b *MiddlewareBuilder) SetIdleTimeout(d time.Duration) *MiddlewareBuilder {
+	b.idleTimeout = d
+	return b
+}
+
+// SetAbsoluteTimeout 设置绝对超时时间. 为 0 时不限制.
+func (b *MiddlewareBuilder) SetAbsoluteTimeout(d time.Duration) *MiddlewareBuilder {
+	b.absoluteTimeout = d
+	return b
+}
+
+// Build 构建会话中间件.
+// 通过 GetSession 获取会话. 读取 Store 失败时返回 500.
+func (b *MiddlewareBuilder) Build() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s, err := b.load(c)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Set(sessionKey, s)
+
+		w := &responseWriter{ResponseWriter: c.Writer}
+		w.save = func() {
+			if err := b.save(c, s); err != nil {
+				log.Println(err)
+			}
+		}
+		c.Writer = w
+		c.Next()
+		// 处理函数没有写入响应时保存
+		w.saveOnce()
+	}
+}
+
+// load 从 cookie 与 Store 中加载会话. 会话不存在、不合法或已过期时创建新的会话.
+func (b *MiddlewareBuilder) load(c *gin.Context) (*Session, error) {
+	now := b.timeFunc()
+	value, err := c.Cookie(b.cookie.Name)
+	if err != nil || value == "" {
+		return newSession(now)
+	}
+	id, err := b.codec.Decode(value)
+	if err != nil {
+		return newSession(now)
+	}
+	ctx := c.Request.Context()
+	data, err := b.store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return newSession(now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err = json.Unmarshal(data, &rec); err != nil {
+		return newSession(now)
+	}
+	if b.expired(rec, now) {
+		if err = b.store.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		return newSession(now)
+	}
+	return &Session{id: id, rec: rec}, nil
+}
+
+// expired 判断会话是否已超过空闲时间或绝对时间.
+func (b *MiddlewareBuilder) expired(rec record, now time.Time) bool {
+	if now.Sub(rec.LastAccessedAt) >= b.idleTimeout {
+		return true
+	}
+	return b.absoluteTimeout > 0 && now.Sub(rec.CreatedAt) >= b.absoluteTimeout
+}
+
+// save 保存会话并按需设置 cookie.
+func (b *MiddlewareBuilder) save(c *gin.Context, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx := c.Request.Context()
+
+	if s.oldID != "" {
+		if err := b.store.Delete(ctx, s.oldID); err != nil {
+			return err
+		}
+	}
+	if s.destroyed {
+		if !s.isNew || s.oldID != "" {
+			b.setCookie(c, "", -1)
+		}
+		return b.store.Delete(ctx, s.id)
+	}
+	// 新创建且没有修改的会话不保存, 避免为每个匿名请求创建会话
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	now := b.timeFunc()
+	s.rec.LastAccessedAt = now
+	ttl := b.idleTimeout
+	if b.absoluteTimeout > 0 {
+		if remaining := s.rec.CreatedAt.Add(b.absoluteTimeout).Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl <= 0 {
+		// 请求期间超过了绝对时间. 不能保存, 一些 Store (例如 Redis) 把不大于 0 的 ttl 视为永不过期
+		if !s.isNew || s.oldID != "" {
+			b.setCookie(c, "", -1)
+		}
+		return b.store.Delete(ctx, s.id)
+	}
+	data, err := json.Marshal(s.rec)
+	if err != nil {
+		return err
+	}
+	if err = b.store.Set(ctx, s.id, data, ttl); err != nil {
+		return err
+	}
+	if s.isNew || s.oldID != "" {
+		value, err := b.codec.Encode(s.id)
+		if err != nil {
+			return err
+		}
+		b.setCookie(c, value, 0)
+	}
+	return nil
+}
+
+func (b *MiddlewareBuilder) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     b.cookie.Name,
+		Value:    value,
+		Path:     b.cookie.Path,
+		Domain:   b.cookie.Domain,
+		MaxAge:   maxAge,
+		Secure:   b.cookie.Secure,
+		HttpOnly: b.cookie.HttpOnly,
+		SameSite: b.cookie.SameSite,
+	})
+}
+
+// responseWriter 在写入响应头之前保存会话, 以便设置 cookie.
+type responseWriter struct {
+	gin.ResponseWriter
+	once sync.Once
+	save func()
+}
+
+func (w *responseWriter) saveOnce() {
+	w.once.Do(w.save)
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	w.saveOnce()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.saveOnce()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.saveOnce()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseWriter) Flush() {
+	w.saveOnce()
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,253 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient 保存 cookie 并按顺序发送请求.
+type testClient struct {
+	t      *testing.T
+	server *gin.Engine
+	cookie *http.Cookie
+}
+
+func (tc *testClient) do(method, target string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, target, nil)
+	require.NoError(tc.t, err)
+	if tc.cookie != nil {
+		req.AddCookie(tc.cookie)
+	}
+	recorder := httptest.NewRecorder()
+	tc.server.ServeHTTP(recorder, req)
+	for _, c := range recorder.Result().Cookies() {
+		if c.MaxAge < 0 {
+			tc.cookie = nil
+			continue
+		}
+		tc.cookie = c
+	}
+	return recorder
+}
+
+func newTestServer(t *testing.T) (*testClient, *MemoryStore, func(time.Duration)) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.timeFunc = func() time.Time { return now }
+	b := NewMiddlewareBuilder(store, NewSignedCodec([]byte("sign key"))).
+		SetIdleTimeout(10 * time.Minute).
+		SetAbsoluteTimeout(time.Hour)
+	b.timeFunc = func() time.Time { return now }
+
+	server := gin.New()
+	server.Use(b.Build())
+	server.GET("/anonymous", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	server.POST("/login", func(c *gin.Context) {
+		s, _ := GetSession(c)
+		require.NoError(t, s.Regenerate())
+		require.NoError(t, s.Set("uid", 1))
+		s.AddFlash("登录成功")
+		c.Status(http.StatusNoContent)
+	})
+	server.GET("/profile", func(c *gin.Context) {
+		s, _ := GetSession(c)
+		var uid int
+		if !s.Get("uid", &uid) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.String(http.StatusOK, strings.Join(s.Flashes(), ","))
+	})
+	server.POST("/logout", func(c *gin.Context) {
+		s, _ := GetSession(c)
+		s.Destroy()
+		c.Status(http.StatusNoContent)
+	})
+	return &testClient{t: t, server: server}, store, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	tests := []struct {
+		name string
+		// steps 按顺序执行的步骤
+		steps func(t *testing.T, tc *testClient, store *MemoryStore, advance func(time.Duration))
+	}{
+		{
+			name: "anonymous_not_saved",
+			steps: func(t *testing.T, tc *testClient, store *MemoryStore, _ func(time.Duration)) {
+				recorder := tc.do(http.MethodGet, "/anonymous")
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Empty(t, recorder.Result().Cookies())
+				assert.Empty(t, store.sessions)
+			},
+		},
+		{
+			name: "login_and_flash",
+			steps: func(t *testing.T, tc *testClient, store *MemoryStore, _ func(time.Duration)) {
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				require.NotNil(t, tc.cookie)
+				assert.True(t, tc.cookie.HttpOnly)
+				assert.Equal(t, http.SameSiteLaxMode, tc.cookie.SameSite)
+				// 闪存消息只读取一次
+				recorder := tc.do(http.MethodGet, "/profile")
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Equal(t, "登录成功", recorder.Body.String())
+				recorder = tc.do(http.MethodGet, "/profile")
+				assert.Equal(t, http.StatusOK, recorder.Code)
+				assert.Empty(t, recorder.Body.String())
+				assert.Len(t, store.sessions, 1)
+			},
+		},
+		{
+			// 登录时重新生成会话 id, 旧的会话 id 失效
+			name: "regenerate_on_login",
+			steps: func(t *testing.T, tc *testClient, store *MemoryStore, _ func(time.Duration)) {
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				before := tc.cookie
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				assert.NotEqual(t, before.Value, tc.cookie.Value)
+				assert.Len(t, store.sessions, 1)
+
+				after := tc.cookie
+				tc.cookie = before
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+				tc.cookie = after
+				assert.Equal(t, http.StatusOK, tc.do(http.MethodGet, "/profile").Code)
+			},
+		},
+		{
+			name: "destroy",
+			steps: func(t *testing.T, tc *testClient, store *MemoryStore, _ func(time.Duration)) {
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				old := tc.cookie
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/logout").Code)
+				assert.Nil(t, tc.cookie)
+				assert.Empty(t, store.sessions)
+				// 服务端已删除, 旧的 cookie 无法使用
+				tc.cookie = old
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+			},
+		},
+		{
+			name: "idle_timeout",
+			steps: func(t *testing.T, tc *testClient, _ *MemoryStore, advance func(time.Duration)) {
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				// 访问会刷新空闲时间
+				advance(9 * time.Minute)
+				assert.Equal(t, http.StatusOK, tc.do(http.MethodGet, "/profile").Code)
+				advance(9 * time.Minute)
+				assert.Equal(t, http.StatusOK, tc.do(http.MethodGet, "/profile").Code)
+				advance(10 * time.Minute)
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+			},
+		},
+		{
+			name: "absolute_timeout",
+			steps: func(t *testing.T, tc *testClient, _ *MemoryStore, advance func(time.Duration)) {
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				for i := 0; i < 6; i++ {
+					advance(9 * time.Minute)
+					assert.Equal(t, http.StatusOK, tc.do(http.MethodGet, "/profile").Code)
+				}
+				advance(9 * time.Minute)
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+			},
+		},
+		{
+			name: "tampered_cookie",
+			steps: func(t *testing.T, tc *testClient, _ *MemoryStore, _ func(time.Duration)) {
+				assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+				tc.cookie.Value = "x" + tc.cookie.Value
+				assert.Equal(t, http.StatusUnauthorized, tc.do(http.MethodGet, "/profile").Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, store, advance := newTestServer(t)
+			tt.steps(t, tc, store, advance)
+		})
+	}
+}
+
+// ttlStore 记录 Set 的 ttl.
+type ttlStore struct {
+	*MemoryStore
+	ttls []time.Duration
+}
+
+func (s *ttlStore) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	s.ttls = append(s.ttls, ttl)
+	return s.MemoryStore.Set(ctx, id, data, ttl)
+}
+
+func TestMiddlewareBuilder_AbsoluteTimeoutDuringRequest(t *testing.T) {
+	now := time.Now()
+	store := &ttlStore{MemoryStore: NewMemoryStore()}
+	store.timeFunc = func() time.Time { return now }
+	b := NewMiddlewareBuilder(store, NewSignedCodec([]byte("sign key"))).
+		SetIdleTimeout(2 * time.Hour).
+		SetAbsoluteTimeout(time.Hour)
+	b.timeFunc = func() time.Time { return now }
+	server := gin.New()
+	server.Use(b.Build())
+	server.POST("/login", func(c *gin.Context) {
+		s, _ := GetSession(c)
+		require.NoError(t, s.Set("uid", 1))
+		c.Status(http.StatusNoContent)
+	})
+	server.POST("/slow", func(c *gin.Context) {
+		s, _ := GetSession(c)
+		require.NoError(t, s.Set("uid", 2))
+		// 请求期间超过绝对时间
+		now = now.Add(2 * time.Minute)
+		c.Status(http.StatusNoContent)
+	})
+	tc := &testClient{t: t, server: server}
+
+	assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/login").Code)
+	require.NotNil(t, tc.cookie)
+	now = now.Add(59 * time.Minute)
+	assert.Equal(t, http.StatusNoContent, tc.do(http.MethodPost, "/slow").Code)
+	// 删除会话并使 cookie 过期, 不以不大于 0 的 ttl 保存
+	assert.Nil(t, tc.cookie)
+	assert.Equal(t, []time.Duration{time.Hour}, store.ttls)
+	assert.Empty(t, store.sessions)
+}
+
+// errStore 读取时返回错误的 Store.
+type errStore struct {
+	Store
+}
+
+func (errStore) Get(context.Context, string) ([]byte, error) {
+	return nil, assert.AnError
+}
+
+func TestMiddlewareBuilder_StoreError(t *testing.T) {
+	codec := NewSignedCodec([]byte("sign key"))
+	server := gin.New()
+	server.Use(NewMiddlewareBuilder(errStore{Store: NewMemoryStore()}, codec).Build())
+	server.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	value, err := codec.Encode("id")
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "ginx_session", Value: value})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
@@ -0,0 +1,189 @@
+// Package session 提供基于 cookie 的服务端会话, 可以作为无状态 JWT 之外的选择.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionKey 定义 Session 在 gin.Context.Keys 中的 key.
+const sessionKey = "ginx/session"
+
+// idSize 会话 id 的随机字节数.
+const idSize = 32
+
+// record 定义保存到 Store 中的会话数据.
+type record struct {
+	Values         map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes        []string                   `json:"flashes,omitempty"`
+	CreatedAt      time.Time                  `json:"created_at"`
+	LastAccessedAt time.Time                  `json:"last_accessed_at"`
+}
+
+// Session 定义一个请求的会话.
+// 值使用 JSON 编码保存. 并发安全.
+type Session struct {
+	mu sync.Mutex
+	id string
+	// oldID 重新生成 id 前的会话 id, 保存时从 Store 中删除.
+	oldID     string
+	rec       record
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// GetSession 从 gin.Context 中获取会话.
+// 没有使用会话中间件时返回 false.
+func GetSession(c *gin.Context) (*Session, bool) {
+	v, ok := c.Get(sessionKey)
+	if !ok {
+		return nil, false
+	}
+	s, ok := v.(*Session)
+	return s, ok
+}
+
+func newSession(now time.Time) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		id:    id,
+		rec:   record{CreatedAt: now, LastAccessedAt: now},
+		isNew: true,
+	}, nil
+}
+
+// ID 返回会话 id.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew 是否为本次请求新创建的会话.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// CreatedAt 返回会话的创建时间.
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.CreatedAt
+}
+
+// Get 获取 key 对应的值并解码到 v 中. 值不存在或解码失败时返回 false.
+func (s *Session) Get(key string, v any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.rec.Values[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// Has 判断 key 是否存在.
+func (s *Session) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.rec.Values[key]
+	return ok
+}
+
+// Set 设置 key 对应的值. 值需要可以被 JSON 编码.
+func (s *Session) Set(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = make(map[string]json.RawMessage)
+	}
+	s.rec.Values[key] = raw
+	s.modified = true
+	return nil
+}
+
+// Delete 删除 key 对应的值.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.modified = true
+	}
+}
+
+// Clear 删除全部的值.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Values = nil
+	s.rec.Flashes = nil
+	s.modified = true
+}
+
+// AddFlash 添加一条闪存消息. 闪存消息在下一次通过 Flashes 读取后删除.
+func (s *Session) AddFlash(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Flashes = append(s.rec.Flashes, msg)
+	s.modified = true
+}
+
+// Flashes 读取并删除全部的闪存消息.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.rec.Flashes
+	if len(flashes) > 0 {
+		s.rec.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Regenerate 重新生成会话 id 并保留会话数据, 旧的会话 id 失效.
+// 在登录等权限变化时调用以防止会话固定攻击.
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = id
+	s.modified = true
+	return nil
+}
+
+// Destroy 销毁会话. 从 Store 中删除并使 cookie 过期.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+func newID() (string, error) {
+	b := make([]byte, idSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,84 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 会话不存在或已过期.
+var ErrNotFound = errors.New("会话不存在")
+
+// Store 定义服务端会话数据的存储.
+// data 为编码后的会话数据, 存储不需要关心其格式.
+type Store interface {
+	// Get 获取会话数据. 不存在或已过期时返回 ErrNotFound.
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Set 保存会话数据, ttl 后过期.
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete 删除会话数据. 不存在时不返回错误.
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryStore 基于内存的 Store.
+// 并发安全. 适用于测试或单实例部署.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	timeFunc func() time.Time
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore 创建一个基于内存的 Store.
+// 过期的会话在 Get 或 Cleanup 时删除.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+		timeFunc: time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !s.timeFunc().Before(e.expiresAt) {
+		delete(s.sessions, id)
+		return nil, ErrNotFound
+	}
+	return e.data, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = memoryEntry{data: data, expiresAt: s.timeFunc().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Cleanup 删除全部过期的会话. 可以定期调用以限制内存占用.
+func (s *MemoryStore) Cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeFunc()
+	for id, e := range s.sessions {
+		if !now.Before(e.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name string
+		// newStore 创建 Store 以及使其时间前进的方法
+		newStore func(t *testing.T) (Store, func(time.Duration))
+	}{
+		{
+			name: "memory",
+			newStore: func(t *testing.T) (Store, func(time.Duration)) {
+				now := time.Now()
+				s := NewMemoryStore()
+				s.timeFunc = func() time.Time { return now }
+				return s, func(d time.Duration) { now = now.Add(d) }
+			},
+		},
+		{
+			name: "file",
+			newStore: func(t *testing.T) (Store, func(time.Duration)) {
+				now := time.Now()
+				s, err := NewFileStore(t.TempDir())
+				require.NoError(t, err)
+				s.timeFunc = func() time.Time { return now }
+				return s, func(d time.Duration) { now = now.Add(d) }
+			},
+		},
+		{
+			name: "redis",
+			newStore: func(t *testing.T) (Store, func(time.Duration)) {
+				mr := miniredis.RunT(t)
+				client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				t.Cleanup(func() { _ = client.Close() })
+				return NewRedisStore(client, "session:"), mr.FastForward
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, advance := tt.newStore(t)
+
+			_, err := s.Get(ctx, "id-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, s.Set(ctx, "id-1", []byte("data-1"), time.Minute))
+			data, err := s.Get(ctx, "id-1")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data-1"), data)
+
+			// 覆盖
+			require.NoError(t, s.Set(ctx, "id-1", []byte("data-2"), time.Minute))
+			data, err = s.Get(ctx, "id-1")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data-2"), data)
+
+			// 删除
+			require.NoError(t, s.Delete(ctx, "id-1"))
+			_, err = s.Get(ctx, "id-1")
+			assert.ErrorIs(t, err, ErrNotFound)
+			require.NoError(t, s.Delete(ctx, "id-1"))
+
+			// 过期
+			require.NoError(t, s.Set(ctx, "id-2", []byte("data"), time.Minute))
+			advance(time.Minute)
+			_, err = s.Get(ctx, "id-2")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestMemoryStore_Cleanup(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.timeFunc = func() time.Time { return now }
+	ctx := context.Background()
+	require.NoError(t, s.Set(ctx, "short", []byte("data"), time.Second))
+	require.NoError(t, s.Set(ctx, "long", []byte("data"), time.Hour))
+	now = now.Add(time.Minute)
+	s.Cleanup()
+	assert.Len(t, s.sessions, 1)
+	assert.Contains(t, s.sessions, "long")
+}
+
+func TestFileStore_Cleanup(t *testing.T) {
+	now := time.Now()
+	s, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	s.timeFunc = func() time.Time { return now }
+	ctx := context.Background()
+	require.NoError(t, s.Set(ctx, "short", []byte("data"), time.Second))
+	require.NoError(t, s.Set(ctx, "long", []byte("data"), time.Hour))
+	now = now.Add(time.Minute)
+	require.NoError(t, s.Cleanup())
+	assert.NoFileExists(t, s.filename("short"))
+	assert.FileExists(t, s.filename("long"))
+}
@@ -0,0 +1,415 @@
+package totp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/udugong/token"
+
+	ujwt "github.com/udugong/ginx/auth/jwt"
+)
+
+// AMR 通过 TOTP 或恢复码完成认证后加入 amr 的值.
+// 详见 https://datatracker.ietf.org/doc/html/rfc8176#section-2
+const AMR = "otp"
+
+const (
+	// defaultRecoveryCodeCount 默认生成恢复码的数量.
+	defaultRecoveryCodeCount = 10
+
+	// defaultMaxAttempts 默认允许连续校验失败的次数.
+	defaultMaxAttempts = 5
+
+	// defaultLockout 默认校验失败的次数的统计窗口, 达到上限后在窗口结束前拒绝校验.
+	defaultLockout = 15 * time.Minute
+)
+
+// EnrollResponse 定义注册的响应.
+type EnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// ConfirmResponse 定义确认注册的响应.
+// 恢复码只返回一次, 需要提示用户妥善保存.
+type ConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// VerifyResponse 定义校验的响应.
+type VerifyResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// codeRequest 定义提交密码或恢复码的请求.
+type codeRequest struct {
+	Code         string `json:"code" form:"code"`
+	RecoveryCode string `json:"recovery_code" form:"recovery_code"`
+}
+
+// Handler 定义 TOTP 注册与校验的处理函数.
+// 需要在认证中间件之后使用, 以 claims 的 sub 作为用户标识:
+//   - Enroll: 生成待确认的密钥与 otpauth:// URI. 已有生效的密钥时要求先通过 Verify 校验.
+//   - Confirm: 使用认证器应用生成的密码确认密钥, 返回恢复码.
+//   - Verify: 校验密码或恢复码, 使用资源令牌管理器签发 amr 包含 "otp" 的新 access token.
+type Handler[T interface {
+	jwt.Claims
+	ujwt.AuthenticationContext
+}, PT interface {
+	*T
+	StepUp(method string, at time.Time)
+}] struct {
+	// issuer 显示在认证器应用中的发行者.
+	issuer string
+
+	totp          *TOTP
+	accessTM      token.Manager[T]
+	secrets       SecretStore
+	recoveryCodes RecoveryCodeStore
+
+	// getClaims 获取 Claims.
+	// 默认使用 ujwt.ClaimsFromContext[T] 获取.
+	getClaims func(*gin.Context) (T, bool)
+
+	// accountName 显示在认证器应用中的账号名.
+	// 默认使用 claims 的 sub.
+	accountName func(T) string
+
+	// recoveryCodeCount 生成恢复码的数量. 默认为 10.
+	recoveryCodeCount int
+
+	// attempts 校验失败次数的存储.
+	// 默认使用 secrets, 没有实现 AttemptStore 时使用 NewMemoryStore.
+	attempts AttemptStore
+
+	// maxAttempts 在 lockout 内允许校验失败的次数. 默认为 5. 小于等于 0 时不限制.
+	maxAttempts int
+
+	// lockout 校验失败的次数的统计窗口. 默认为 15 分钟.
+	lockout time.Duration
+
+	timeFunc func() time.Time
+}
+
+// NewHandler 创建一个 TOTP 注册与校验的处理函数.
+// accessTM 用于签发通过 TOTP 校验后的 access token.
+//
+//	store := totp.NewMemoryStore()
+//	h := totp.NewHandler[Claims]("ginx", accessTM, store, store)
+func NewHandler[T interface {
+	jwt.Claims
+	ujwt.AuthenticationContext
+}, PT interface {
+	*T
+	StepUp(method string, at time.Time)
+}](issuer string, accessTM token.Manager[T], secrets SecretStore,
+	recoveryCodes RecoveryCodeStore) *Handler[T, PT] {
+	attempts, ok := secrets.(AttemptStore)
+	if !ok {
+		attempts = NewMemoryStore()
+	}
+	return &Handler[T, PT]{
+		issuer:        issuer,
+		totp:          New(),
+		accessTM:      accessTM,
+		secrets:       secrets,
+		recoveryCodes: recoveryCodes,
+		getClaims: func(c *gin.Context) (T, bool) {
+			return ujwt.ClaimsFromContext[T](c.Request.Context())
+		},
+		accountName: func(clm T) string {
+			sub, _ := clm.GetSubject()
+			return sub
+		},
+		recoveryCodeCount: defaultRecoveryCodeCount,
+		attempts:          attempts,
+		maxAttempts:       defaultMaxAttempts,
+		lockout:           defaultLockout,
+		timeFunc:          time.Now,
+	}
+}
+
+// SetTOTP 设置 TOTP 的参数.
+func (h *Handler[T, PT]) SetTOTP(t *TOTP) *Handler[T, PT] {
+	h.totp = t
+	return h
+}
+
+// SetGetClaimsFunc 设置获取 Claims 的方法.
+// 需要与认证中间件中设置 Claims 的方式匹配.
+func (h *Handler[T, PT]) SetGetClaimsFunc(fn func(*gin.Context) (T, bool)) *Handler[T, PT] {
+	h.getClaims = fn
+	return h
+}
+
+// SetAccountNameFunc 设置显示在认证器应用中的账号名.
+func (h *Handler[T, PT]) SetAccountNameFunc(fn func(T) string) *Handler[T, PT] {
+	h.accountName = fn
+	return h
+}
+
+// SetRecoveryCodeCount 设置生成恢复码的数量.
+func (h *Handler[T, PT]) SetRecoveryCodeCount(n int) *Handler[T, PT] {
+	h.recoveryCodeCount = n
+	return h
+}
+
+// SetAttemptStore 设置校验失败次数的存储.
+// 多实例部署时需要使用共享的存储.
+func (h *Handler[T, PT]) SetAttemptStore(store AttemptStore) *Handler[T, PT] {
+	h.attempts = store
+	return h
+}
+
+// SetMaxAttempts 设置在统计窗口内允许校验失败的次数. 小于等于 0 时不限制.
+func (h *Handler[T, PT]) SetMaxAttempts(n int) *Handler[T, PT] {
+	h.maxAttempts = n
+	return h
+}
+
+// SetLockout 设置校验失败的次数的统计窗口.
+// 失败次数达到上限后, 在第一次失败的 lockout 之后才能再次校验.
+func (h *Handler[T, PT]) SetLockout(d time.Duration) *Handler[T, PT] {
+	h.lockout = d
+	return h
+}
+
+// Enroll 生成待确认的密钥.
+// 返回密钥与 otpauth:// URI.
+// 已有生效的密钥时, 要求 amr 包含 "otp", 否则返回 403, 避免只凭密码就替换密钥.
+func (h *Handler[T, PT]) Enroll(c *gin.Context) {
+	clm, sub, ok := h.subject(c)
+	if !ok || !h.canEnroll(c, clm, sub) {
+		return
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = h.secrets.SavePendingSecret(c.Request.Context(), sub, secret); err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, EnrollResponse{
+		Secret: secret,
+		URI:    h.totp.URI(h.issuer, h.accountName(clm), secret),
+	})
+}
+
+// Confirm 使用密码确认待确认的密钥.
+// 确认后密钥生效, 并返回新生成的恢复码. 密码错误时返回 401,
+// 连续失败的次数达到上限时返回 429.
+// 与 Enroll 相同, 已有生效的密钥时要求 amr 包含 "otp".
+func (h *Handler[T, PT]) Confirm(c *gin.Context) {
+	clm, sub, ok := h.subject(c)
+	if !ok || !h.canEnroll(c, clm, sub) {
+		return
+	}
+	var req codeRequest
+	if err := c.ShouldBind(&req); err != nil || req.Code == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	ctx := c.Request.Context()
+	if !h.allowAttempt(c, sub) {
+		return
+	}
+	secret, err := h.secrets.PendingSecret(ctx, sub)
+	switch {
+	case errors.Is(err, ErrSecretNotFound):
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	verified, err := h.validate(ctx, sub, secret, req.Code)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		h.fail(c)
+		return
+	}
+	h.resetAttempts(ctx, sub)
+
+	codes, err := GenerateRecoveryCodes(h.recoveryCodeCount)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = HashRecoveryCode(code)
+	}
+	if err = h.secrets.ActivateSecret(ctx, sub, hashes); err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, ConfirmResponse{RecoveryCodes: codes})
+}
+
+// Verify 校验密码或恢复码.
+// 通过后在 claims 中记录本次认证 (auth_time 与 amr 中的 "otp"),
+// 并使用资源令牌管理器签发新的 access token. 校验失败时返回 401,
+// 连续失败的次数达到上限时返回 429.
+// 同一个时间步的密码只能使用一次, 也不能使用比上次更早的时间步的密码.
+func (h *Handler[T, PT]) Verify(c *gin.Context) {
+	clm, sub, ok := h.subject(c)
+	if !ok {
+		return
+	}
+	var req codeRequest
+	if err := c.ShouldBind(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	ctx := c.Request.Context()
+	if !h.allowAttempt(c, sub) {
+		return
+	}
+
+	var verified bool
+	if req.Code != "" {
+		secret, err := h.secrets.Secret(ctx, sub)
+		switch {
+		case errors.Is(err, ErrSecretNotFound):
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		verified, err = h.validate(ctx, sub, secret, req.Code)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var err error
+		verified, err = h.recoveryCodes.ConsumeRecoveryCode(ctx, sub, HashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	if !verified {
+		h.fail(c)
+		return
+	}
+	h.resetAttempts(ctx, sub)
+
+	PT(&clm).StepUp(AMR, h.timeFunc())
+	accessToken, err := h.accessTM.GenerateToken(clm)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, VerifyResponse{AccessToken: accessToken})
+}
+
+// subject 获取 claims 与用户标识. 获取失败时返回 401.
+func (h *Handler[T, PT]) subject(c *gin.Context) (T, string, bool) {
+	clm, ok := h.getClaims(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return clm, "", false
+	}
+	sub, err := clm.GetSubject()
+	if err != nil || sub == "" {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return clm, "", false
+	}
+	return clm, sub, true
+}
+
+// allowAttempt 在校验之前预留一次校验, 先记为失败, 通过后由 resetAttempts 清除.
+// 先读取再记录失败时, 并发的请求都可以在记录之前通过检查. 超过上限时返回 429.
+func (h *Handler[T, PT]) allowAttempt(c *gin.Context, sub string) bool {
+	if h.maxAttempts <= 0 {
+		return true
+	}
+	n, err := h.attempts.AddFailure(c.Request.Context(), sub, h.lockout)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+	if n > h.maxAttempts {
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// fail 返回 401. 失败已经在 allowAttempt 中记录.
+func (h *Handler[T, PT]) fail(c *gin.Context) {
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// resetAttempts 校验通过后清除失败的次数.
+func (h *Handler[T, PT]) resetAttempts(ctx context.Context, sub string) {
+	if h.maxAttempts <= 0 {
+		return
+	}
+	if err := h.attempts.ResetFailures(ctx, sub); err != nil {
+		log.Println(err)
+	}
+}
+
+// validate 校验密码, 并记录密码的时间步. 同一个时间步或者更早的密码只能使用一次.
+func (h *Handler[T, PT]) validate(ctx context.Context, sub, secret, code string) (bool, error) {
+	counter, ok := h.totp.ValidateCounter(secret, code, h.timeFunc())
+	if !ok {
+		return false, nil
+	}
+	return h.secrets.UseCounter(ctx, sub, counter)
+}
+
+// canEnroll 判断是否可以注册新的密钥.
+// 已有生效的密钥时要求 amr 包含 "otp", 否则返回 403.
+func (h *Handler[T, PT]) canEnroll(c *gin.Context, clm T, sub string) bool {
+	_, err := h.secrets.Secret(c.Request.Context(), sub)
+	switch {
+	case errors.Is(err, ErrSecretNotFound):
+		return true
+	case err != nil:
+		log.Println(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+	if !slices.Contains(clm.GetAMR(), AMR) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// RequireVerified 创建要求通过 TOTP 校验的中间件构建器, 也就是 amr 包含 "otp".
+// 需要在认证中间件之后使用. 可以通过 MaxAge 要求在一段时间内完成过校验:
+//
+//	r.Use(auth.Build(), totp.RequireVerified[Claims]().MaxAge(10*time.Minute).Build())
+func RequireVerified[T interface {
+	jwt.Claims
+	ujwt.AuthenticationContext
+}]() *ujwt.StepUpBuilder[T] {
+	return ujwt.NewStepUpBuilder[T]().RequireAMR(AMR)
+}
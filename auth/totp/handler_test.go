@@ -0,0 +1,235 @@
+package totp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/udugong/token/jwtcore"
+
+	ujwt "github.com/udugong/ginx/auth/jwt"
+	"github.com/udugong/ginx/auth/jwt/jwttest"
+)
+
+type Claims struct {
+	jwtcore.RegisteredClaims
+	ujwt.AuthenticationClaims
+}
+
+// newServer 创建注册了 TOTP 处理函数与受保护路由的服务.
+func newServer(tm *jwttest.Manager[Claims], h *Handler[Claims, *Claims]) *gin.Engine {
+	server := gin.New()
+	server.Use(ujwt.NewMiddlewareBuilder[Claims](tm).Build())
+	server.POST("/2fa/enroll", h.Enroll)
+	server.POST("/2fa/confirm", h.Confirm)
+	server.POST("/2fa/verify", h.Verify)
+	server.GET("/admin", RequireVerified[Claims]().Build(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return server
+}
+
+func do(t *testing.T, server *gin.Engine, method, target, token, body string) *httptest.ResponseRecorder {
+	var opts []jwttest.RequestOption
+	if token != "" {
+		opts = append(opts, jwttest.Bearer(token))
+	}
+	req := jwttest.NewRequest(method, target, strings.NewReader(body), opts...)
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	tm := jwttest.NewManager[Claims](nil)
+	store := NewMemoryStore()
+	h := NewHandler[Claims]("ginx", tm, store, store).SetRecoveryCodeCount(2)
+	h.timeFunc = func() time.Time { return now }
+	server := newServer(tm, h)
+	accessToken := jwttest.Mint[Claims](t, tm, Claims{
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"},
+		AuthenticationClaims: ujwt.AuthenticationClaims{
+			AMR: []string{"pwd"},
+		},
+	})
+
+	// 没有通过 TOTP 校验不能访问
+	assert.Equal(t, http.StatusUnauthorized, do(t, server, http.MethodGet, "/admin", accessToken, "").Code)
+	// 没有注册时不能校验
+	assert.Equal(t, http.StatusUnauthorized,
+		do(t, server, http.MethodPost, "/2fa/verify", accessToken, `{"code":"123456"}`).Code)
+
+	// 注册
+	recorder := do(t, server, http.MethodPost, "/2fa/enroll", accessToken, "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var enroll EnrollResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &enroll))
+	assert.Contains(t, enroll.URI, "otpauth://totp/ginx:user-1?")
+	assert.Contains(t, enroll.URI, "secret="+enroll.Secret)
+
+	// 确认
+	code, err := h.totp.GenerateCode(enroll.Secret, now)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized,
+		do(t, server, http.MethodPost, "/2fa/confirm", accessToken, `{"code":"000000"}`).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		do(t, server, http.MethodPost, "/2fa/confirm", accessToken, `{}`).Code)
+	recorder = do(t, server, http.MethodPost, "/2fa/confirm", accessToken, `{"code":"`+code+`"}`)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var confirm ConfirmResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &confirm))
+	require.Len(t, confirm.RecoveryCodes, 2)
+	// 已经有生效的密钥, 只凭密码不能再次确认
+	assert.Equal(t, http.StatusForbidden,
+		do(t, server, http.MethodPost, "/2fa/confirm", accessToken, `{"code":"`+code+`"}`).Code)
+
+	// 下一个时间步的密码, 在允许的偏移内
+	next, err := h.totp.GenerateCode(enroll.Secret, now.Add(30*time.Second))
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "wrong_code", body: `{"code":"000000"}`, wantCode: http.StatusUnauthorized},
+		// 确认时使用过的密码不能重放
+		{name: "replayed_code", body: `{"code":"` + code + `"}`, wantCode: http.StatusUnauthorized},
+		{name: "code", body: `{"code":"` + next + `"}`, wantCode: http.StatusOK},
+		{name: "replayed_next", body: `{"code":"` + next + `"}`, wantCode: http.StatusUnauthorized},
+		{name: "recovery_code", body: `{"recovery_code":"` + confirm.RecoveryCodes[0] + `"}`, wantCode: http.StatusOK},
+		// 恢复码只能使用一次
+		{name: "used_recovery_code", body: `{"recovery_code":"` + confirm.RecoveryCodes[0] + `"}`, wantCode: http.StatusUnauthorized},
+		{name: "empty", body: `{}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := do(t, server, http.MethodPost, "/2fa/verify", accessToken, tt.body)
+			require.Equal(t, tt.wantCode, recorder.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var verify VerifyResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &verify))
+			clm, err := tm.VerifyToken(verify.AccessToken)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"pwd", AMR}, clm.AMR)
+			assert.Equal(t, now, clm.AuthTime.Time)
+			// step-up token 可以访问受保护的路由
+			assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/admin", verify.AccessToken, "").Code)
+		})
+	}
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	tm := jwttest.NewManager[Claims](nil)
+	store := NewMemoryStore()
+	h := NewHandler[Claims]("ginx", tm, store, store).
+		SetGetClaimsFunc(func(c *gin.Context) (Claims, bool) {
+			return Claims{}, true
+		})
+	server := gin.New()
+	server.POST("/2fa/enroll", h.Enroll)
+	// 没有 sub
+	assert.Equal(t, http.StatusUnauthorized, do(t, server, http.MethodPost, "/2fa/enroll", "", "").Code)
+	_, err := store.PendingSecret(context.Background(), "")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestHandler_Reenroll(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	tm := jwttest.NewManager[Claims](nil)
+	store := NewMemoryStore()
+	h := NewHandler[Claims]("ginx", tm, store, store)
+	h.timeFunc = func() time.Time { return now }
+	server := newServer(tm, h)
+	accessToken := jwttest.Mint[Claims](t, tm, Claims{
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"},
+		AuthenticationClaims: ujwt.AuthenticationClaims{
+			AMR: []string{"pwd"},
+		},
+	})
+	ctx := context.Background()
+	require.NoError(t, store.SavePendingSecret(ctx, "user-1", sha1Secret))
+	require.NoError(t, store.ActivateSecret(ctx, "user-1", nil))
+
+	// 已有生效的密钥, 只凭密码不能替换
+	assert.Equal(t, http.StatusForbidden, do(t, server, http.MethodPost, "/2fa/enroll", accessToken, "").Code)
+	assert.Equal(t, http.StatusForbidden,
+		do(t, server, http.MethodPost, "/2fa/confirm", accessToken, `{"code":"123456"}`).Code)
+	_, err := store.PendingSecret(ctx, "user-1")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	// 通过 TOTP 校验后可以替换
+	code, err := h.totp.GenerateCode(sha1Secret, now)
+	require.NoError(t, err)
+	recorder := do(t, server, http.MethodPost, "/2fa/verify", accessToken, `{"code":"`+code+`"}`)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var verify VerifyResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &verify))
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodPost, "/2fa/enroll", verify.AccessToken, "").Code)
+}
+
+func TestHandler_Throttle(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	tm := jwttest.NewManager[Claims](nil)
+	store := NewMemoryStore()
+	store.timeFunc = func() time.Time { return now }
+	h := NewHandler[Claims]("ginx", tm, store, store).SetMaxAttempts(2).SetLockout(time.Minute)
+	h.timeFunc = func() time.Time { return now }
+	server := newServer(tm, h)
+	accessToken := jwttest.Mint[Claims](t, tm, Claims{
+		RegisteredClaims: jwtcore.RegisteredClaims{Subject: "user-1"},
+	})
+	ctx := context.Background()
+	require.NoError(t, store.SavePendingSecret(ctx, "user-1", sha1Secret))
+	require.NoError(t, store.ActivateSecret(ctx, "user-1", []string{HashRecoveryCode("recovery")}))
+
+	verify := func(body string) int {
+		return do(t, server, http.MethodPost, "/2fa/verify", accessToken, body).Code
+	}
+	code := func() string {
+		code, err := h.totp.GenerateCode(sha1Secret, now)
+		require.NoError(t, err)
+		return `{"code":"` + code + `"}`
+	}
+	assert.Equal(t, http.StatusUnauthorized, verify(`{"code":"000000"}`))
+	// 通过后清除失败的次数
+	assert.Equal(t, http.StatusOK, verify(code()))
+	assert.Equal(t, http.StatusUnauthorized, verify(`{"recovery_code":"wrong"}`))
+	assert.Equal(t, http.StatusUnauthorized, verify(`{"code":"000000"}`))
+	// 达到上限后正确的密码与恢复码也被拒绝
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusTooManyRequests, verify(code()))
+	assert.Equal(t, http.StatusTooManyRequests, verify(`{"recovery_code":"recovery"}`))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, verify(code()))
+
+	// 并发的请求在记录失败之前不能同时通过检查
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- verify(`{"code":"000000"}`)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	got := map[int]int{}
+	for code := range codes {
+		got[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 2, http.StatusTooManyRequests: 8}, got)
+}
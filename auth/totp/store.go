@@ -0,0 +1,191 @@
+package totp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSecretNotFound 没有找到用户的密钥.
+var ErrSecretNotFound = errors.New("totp 密钥不存在")
+
+// SecretStore 定义 TOTP 密钥的存储.
+// 用户注册时先保存为待确认的密钥, 使用认证器应用生成的密码确认后才生效.
+type SecretStore interface {
+	// SavePendingSecret 保存待确认的密钥, 覆盖之前待确认的密钥.
+	SavePendingSecret(ctx context.Context, subject, secret string) error
+	// PendingSecret 获取待确认的密钥. 不存在时返回 ErrSecretNotFound.
+	PendingSecret(ctx context.Context, subject string) (string, error)
+	// ActivateSecret 使待确认的密钥生效, 替换已生效的密钥, 并保存新的恢复码的哈希值, 替换之前的全部恢复码.
+	// 需要原子地完成, 避免密钥与恢复码不一致. 没有待确认的密钥时返回 ErrSecretNotFound.
+	ActivateSecret(ctx context.Context, subject string, recoveryCodeHashes []string) error
+	// Secret 获取已生效的密钥. 不存在时返回 ErrSecretNotFound.
+	Secret(ctx context.Context, subject string) (string, error)
+	// UseCounter 记录通过校验的密码的时间步.
+	// counter 不大于上次记录的时间步时返回 false, 表示密码被重放. 需要原子地比较并记录.
+	UseCounter(ctx context.Context, subject string, counter int64) (bool, error)
+}
+
+// RecoveryCodeStore 定义恢复码的存储.
+// 只保存恢复码的哈希值, 通过 HashRecoveryCode 计算.
+type RecoveryCodeStore interface {
+	// ConsumeRecoveryCode 使用恢复码. 恢复码存在时删除并返回 true.
+	ConsumeRecoveryCode(ctx context.Context, subject, hash string) (bool, error)
+}
+
+// AttemptStore 定义校验失败次数的存储, 用于限制暴力破解密码与恢复码.
+type AttemptStore interface {
+	// AddFailure 增加一次校验失败, 返回增加后的次数. 需要原子地增加并返回.
+	// Handler 在校验之前调用以预留一次校验, 并发的请求也不能超过上限.
+	// 失败次数在第一次失败的 window 之后过期.
+	AddFailure(ctx context.Context, subject string, window time.Duration) (int, error)
+	// ResetFailures 清除校验失败的次数.
+	ResetFailures(ctx context.Context, subject string) error
+}
+
+// recoveryCodeSize 恢复码的字节数.
+const recoveryCodeSize = 10
+
+// GenerateRecoveryCodes 生成 n 个恢复码, 格式为 xxxxxxxx-xxxxxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	b := make([]byte, recoveryCodeSize)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(b32.EncodeToString(b))
+		codes[i] = s[:len(s)/2] + "-" + s[len(s)/2:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode 计算恢复码的哈希值.
+// 忽略大小写、空格与连字符. 恢复码是高熵的随机值, 因此使用 SHA-256 即可.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryStore 基于内存的 SecretStore、RecoveryCodeStore 与 AttemptStore.
+// 并发安全. 适用于测试或单实例部署.
+type MemoryStore struct {
+	mu            sync.Mutex
+	pending       map[string]string
+	secrets       map[string]string
+	counters      map[string]int64
+	recoveryCodes map[string]map[string]struct{}
+	failures      map[string]failures
+
+	timeFunc func() time.Time
+}
+
+// failures 校验失败的次数与过期时间.
+type failures struct {
+	count    int
+	expireAt time.Time
+}
+
+// NewMemoryStore 创建一个基于内存的存储.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pending:       make(map[string]string),
+		secrets:       make(map[string]string),
+		counters:      make(map[string]int64),
+		recoveryCodes: make(map[string]map[string]struct{}),
+		failures:      make(map[string]failures),
+		timeFunc:      time.Now,
+	}
+}
+
+func (s *MemoryStore) SavePendingSecret(_ context.Context, subject, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[subject] = secret
+	return nil
+}
+
+func (s *MemoryStore) PendingSecret(_ context.Context, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.pending[subject]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *MemoryStore) ActivateSecret(_ context.Context, subject string, recoveryCodeHashes []string) error {
+	codes := make(map[string]struct{}, len(recoveryCodeHashes))
+	for _, h := range recoveryCodeHashes {
+		codes[h] = struct{}{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.pending[subject]
+	if !ok {
+		return ErrSecretNotFound
+	}
+	delete(s.pending, subject)
+	s.secrets[subject] = secret
+	s.recoveryCodes[subject] = codes
+	return nil
+}
+
+func (s *MemoryStore) Secret(_ context.Context, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[subject]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *MemoryStore) UseCounter(_ context.Context, subject string, counter int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.counters[subject]; ok && counter <= last {
+		return false, nil
+	}
+	s.counters[subject] = counter
+	return true, nil
+}
+
+func (s *MemoryStore) ConsumeRecoveryCode(_ context.Context, subject, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := s.recoveryCodes[subject]
+	if _, ok := codes[hash]; !ok {
+		return false, nil
+	}
+	delete(codes, hash)
+	return true, nil
+}
+
+func (s *MemoryStore) AddFailure(_ context.Context, subject string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeFunc()
+	f, ok := s.failures[subject]
+	if !ok || !now.Before(f.expireAt) {
+		f = failures{expireAt: now.Add(window)}
+	}
+	f.count++
+	s.failures[subject] = f
+	return f.count, nil
+}
+
+func (s *MemoryStore) ResetFailures(_ context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, subject)
+	return nil
+}
@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	assert.Len(t, codes, 10)
+	seen := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{8}-[a-z2-7]{8}$`, code)
+		seen[code] = struct{}{}
+	}
+	assert.Len(t, seen, len(codes))
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcdefgh-ijklmnop")
+	assert.Equal(t, want, HashRecoveryCode("ABCDEFGH IJKLMNOP"))
+	assert.Equal(t, want, HashRecoveryCode("abcdefghijklmnop"))
+	assert.NotEqual(t, want, HashRecoveryCode("abcdefgh-ijklmnoq"))
+	assert.NotContains(t, want, "abcdefgh")
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	_, err := s.Secret(ctx, "user-1")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	assert.ErrorIs(t, s.ActivateSecret(ctx, "user-1", []string{"h0"}), ErrSecretNotFound)
+
+	require.NoError(t, s.SavePendingSecret(ctx, "user-1", "secret"))
+	secret, err := s.PendingSecret(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+	require.NoError(t, s.ActivateSecret(ctx, "user-1", []string{"h0"}))
+	secret, err = s.Secret(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", secret)
+	_, err = s.PendingSecret(ctx, "user-1")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	// 生效时同时保存恢复码
+	ok, err := s.ConsumeRecoveryCode(ctx, "user-1", "h0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 恢复码只能使用一次
+	ok, err = s.ConsumeRecoveryCode(ctx, "user-1", "h0")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.ConsumeRecoveryCode(ctx, "user-2", "h0")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 只接受比上次更晚的时间步
+	ok, err = s.UseCounter(ctx, "user-1", 10)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.UseCounter(ctx, "user-1", 10)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.UseCounter(ctx, "user-1", 9)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.UseCounter(ctx, "user-1", 11)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.UseCounter(ctx, "user-2", 9)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestMemoryStore_Failures(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1695571200, 0)
+	s := NewMemoryStore()
+	s.timeFunc = func() time.Time { return now }
+
+	n, err := s.AddFailure(ctx, "user-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	now = now.Add(30 * time.Second)
+	n, err = s.AddFailure(ctx, "user-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// 从第一次失败开始计算窗口
+	now = now.Add(30 * time.Second)
+	n, err = s.AddFailure(ctx, "user-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, s.ResetFailures(ctx, "user-1"))
+	n, err = s.AddFailure(ctx, "user-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
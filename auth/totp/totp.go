@@ -0,0 +1,221 @@
+// Package totp 实现 RFC 6238 基于时间的一次性密码 (TOTP), 用于多因素认证.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithm 定义 HMAC 使用的哈希算法.
+type Algorithm string
+
+const (
+	AlgorithmSHA1   Algorithm = "SHA1"
+	AlgorithmSHA256 Algorithm = "SHA256"
+	AlgorithmSHA512 Algorithm = "SHA512"
+)
+
+// ErrInvalidSecret 密钥不是合法的 base32 字符串.
+var ErrInvalidSecret = errors.New("totp 密钥不合法")
+
+// secretSize 生成密钥的字节数. RFC 4226 推荐 160 位.
+const secretSize = 20
+
+// b32 不带填充的 base32 编码, 与认证器应用保持一致.
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP 定义 RFC 6238 基于时间的一次性密码的生成与校验.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6238
+type TOTP struct {
+	// period 时间步长. 默认为 30 秒.
+	period time.Duration
+
+	// digits 密码的位数. 默认为 6.
+	digits int
+
+	// skew 校验时前后允许偏移的时间步数, 用于容忍时钟误差. 默认为 1.
+	skew int
+
+	// algorithm HMAC 使用的哈希算法. 默认为 SHA1.
+	algorithm Algorithm
+
+	timeFunc func() time.Time
+}
+
+// Option 定义 TOTP 的选项.
+type Option func(*TOTP)
+
+// WithPeriod 设置时间步长. 必须是不小于 1 秒的整数秒.
+func WithPeriod(period time.Duration) Option {
+	return func(t *TOTP) {
+		t.period = period
+	}
+}
+
+// WithDigits 设置密码的位数.
+func WithDigits(digits int) Option {
+	return func(t *TOTP) {
+		t.digits = digits
+	}
+}
+
+// WithSkew 设置校验时前后允许偏移的时间步数.
+func WithSkew(skew int) Option {
+	return func(t *TOTP) {
+		t.skew = skew
+	}
+}
+
+// WithAlgorithm 设置 HMAC 使用的哈希算法.
+// 注意: 部分认证器应用只支持 SHA1.
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(t *TOTP) {
+		t.algorithm = algorithm
+	}
+}
+
+// New 创建一个 TOTP.
+// 时间步长不是不小于 1 秒的整数秒时 panic.
+func New(opts ...Option) *TOTP {
+	t := &TOTP{
+		period:    30 * time.Second,
+		digits:    6,
+		skew:      1,
+		algorithm: AlgorithmSHA1,
+		timeFunc:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.period < time.Second || t.period%time.Second != 0 {
+		panic("totp: 时间步长必须是不小于 1 秒的整数秒")
+	}
+	return t
+}
+
+// GenerateSecret 生成一个随机的 base32 编码的密钥.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// GenerateCode 生成 at 时刻的密码.
+func (t *TOTP) GenerateCode(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return t.code(key, t.counter(at)), nil
+}
+
+// Validate 校验当前时刻的密码.
+func (t *TOTP) Validate(secret, code string) bool {
+	return t.ValidateAt(secret, code, t.timeFunc())
+}
+
+// ValidateAt 校验 at 时刻的密码, 前后允许偏移 skew 个时间步.
+// 不能防止密码在有效期内被重放, 需要时使用 ValidateCounter.
+func (t *TOTP) ValidateAt(secret, code string, at time.Time) bool {
+	_, ok := t.ValidateCounter(secret, code, at)
+	return ok
+}
+
+// ValidateCounter 校验 at 时刻的密码, 返回密码对应的时间步.
+// 调用方需要记录已使用的时间步, 并拒绝不大于它的时间步, 防止密码被重放.
+// 详见 https://datatracker.ietf.org/doc/html/rfc6238#section-5.2
+func (t *TOTP) ValidateCounter(secret, code string, at time.Time) (int64, bool) {
+	if len(code) != t.digits {
+		return 0, false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	counter := t.counter(at)
+	for i := -t.skew; i <= t.skew; i++ {
+		if counter+int64(i) < 0 {
+			continue
+		}
+		want := t.code(key, counter+int64(i))
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return counter + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// URI 生成认证器应用使用的 otpauth:// URI, 通常以二维码的形式展示给用户.
+// 详见 https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func (t *TOTP) URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", string(t.algorithm))
+	v.Set("digits", strconv.Itoa(t.digits))
+	v.Set("period", strconv.FormatInt(int64(t.period/time.Second), 10))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+func (t *TOTP) counter(at time.Time) int64 {
+	return at.Unix() / int64(t.period/time.Second)
+}
+
+// code 实现 RFC 4226 的 HOTP 算法.
+func (t *TOTP) code(key []byte, counter int64) string {
+	mac := hmac.New(t.hash(), key)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	mod := int64(1)
+	for i := 0; i < t.digits; i++ {
+		mod *= 10
+	}
+	s := strconv.FormatInt(value%mod, 10)
+	return strings.Repeat("0", t.digits-len(s)) + s
+}
+
+func (t *TOTP) hash() func() hash.Hash {
+	switch t.algorithm {
+	case AlgorithmSHA256:
+		return sha256.New
+	case AlgorithmSHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// decodeSecret 解码 base32 密钥, 忽略大小写、空格与填充.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := b32.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
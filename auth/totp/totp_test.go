@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// RFC 6238 附录 B 中测试使用的密钥
+	sha1Secret   = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	sha256Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA"
+	sha512Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA"
+)
+
+func TestTOTP_GenerateCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		secret    string
+		unix      int64
+		want      string
+	}{
+		{name: "sha1_59", algorithm: AlgorithmSHA1, secret: sha1Secret, unix: 59, want: "94287082"},
+		{name: "sha1_1111111109", algorithm: AlgorithmSHA1, secret: sha1Secret, unix: 1111111109, want: "07081804"},
+		{name: "sha1_1234567890", algorithm: AlgorithmSHA1, secret: sha1Secret, unix: 1234567890, want: "89005924"},
+		{name: "sha1_20000000000", algorithm: AlgorithmSHA1, secret: sha1Secret, unix: 20000000000, want: "65353130"},
+		{name: "sha256_59", algorithm: AlgorithmSHA256, secret: sha256Secret, unix: 59, want: "46119246"},
+		{name: "sha256_1111111111", algorithm: AlgorithmSHA256, secret: sha256Secret, unix: 1111111111, want: "67062674"},
+		{name: "sha512_59", algorithm: AlgorithmSHA512, secret: sha512Secret, unix: 59, want: "90693936"},
+		{name: "sha512_2000000000", algorithm: AlgorithmSHA512, secret: sha512Secret, unix: 2000000000, want: "38618901"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totp := New(WithDigits(8), WithAlgorithm(tt.algorithm))
+			code, err := totp.GenerateCode(tt.secret, time.Unix(tt.unix, 0))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+		})
+	}
+
+	t.Run("invalid_secret", func(t *testing.T) {
+		_, err := New().GenerateCode("not base32!", time.Now())
+		assert.ErrorIs(t, err, ErrInvalidSecret)
+	})
+}
+
+func TestTOTP_ValidateAt(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	totp := New()
+	current, err := totp.GenerateCode(sha1Secret, now)
+	require.NoError(t, err)
+	previous, err := totp.GenerateCode(sha1Secret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+	stale, err := totp.GenerateCode(sha1Secret, now.Add(-60*time.Second))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		totp   *TOTP
+		secret string
+		code   string
+		want   bool
+	}{
+		{name: "current", totp: totp, secret: sha1Secret, code: current, want: true},
+		{name: "within_skew", totp: totp, secret: sha1Secret, code: previous, want: true},
+		{name: "outside_skew", totp: totp, secret: sha1Secret, code: stale},
+		{name: "wider_skew", totp: New(WithSkew(2)), secret: sha1Secret, code: stale, want: true},
+		{name: "no_skew", totp: New(WithSkew(0)), secret: sha1Secret, code: previous},
+		// 小写且带空格的密钥
+		{name: "normalized_secret", totp: totp, secret: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code: current, want: true},
+		{name: "wrong_length", totp: totp, secret: sha1Secret, code: current[:5]},
+		{name: "invalid_secret", totp: totp, secret: "!", code: current},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.totp.ValidateAt(tt.secret, tt.code, now))
+		})
+	}
+}
+
+func TestTOTP_ValidateCounter(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	totp := New()
+	previous, err := totp.GenerateCode(sha1Secret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+	counter, ok := totp.ValidateCounter(sha1Secret, previous, now)
+	assert.True(t, ok)
+	// 返回密码对应的时间步, 而不是当前的时间步
+	assert.Equal(t, now.Unix()/30-1, counter)
+	_, ok = totp.ValidateCounter(sha1Secret, "000000", now)
+	assert.False(t, ok)
+}
+
+func TestTOTP_URI(t *testing.T) {
+	uri := New().URI("ginx", "alice@example.com", sha1Secret)
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/ginx:alice@example.com", u.Path)
+	assert.Equal(t, url.Values{
+		"secret":    {sha1Secret},
+		"issuer":    {"ginx"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}, u.Query())
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	key, err := decodeSecret(secret)
+	require.NoError(t, err)
+	assert.Len(t, key, secretSize)
+
+	other, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+}
+
+func TestNew_InvalidPeriod(t *testing.T) {
+	assert.Panics(t, func() { New(WithPeriod(0)) })
+	assert.Panics(t, func() { New(WithPeriod(500 * time.Millisecond)) })
+	assert.Panics(t, func() { New(WithPeriod(1500 * time.Millisecond)) })
+	assert.NotPanics(t, func() { New(WithPeriod(time.Minute)) })
+}
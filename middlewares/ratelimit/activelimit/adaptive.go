@@ -0,0 +1,250 @@
+package activelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+var (
+	_ CostLimiter = (*AdaptiveLimiter)(nil)
+	_ Observer    = (*AdaptiveLimiter)(nil)
+)
+
+// LimitAlgorithm 根据请求的耗时与结果计算新的并发上限.
+// 由 AdaptiveLimiter 在持有锁时调用, 实现不需要并发安全.
+type LimitAlgorithm interface {
+	// Update 返回新的并发上限.
+	// limit: 当前的并发上限; inflight: 请求结束时的活跃请求数, 包含该请求.
+	Update(limit float64, inflight int, latency time.Duration, outcome Outcome) float64
+}
+
+// AdaptiveLimiter 自适应的活跃请求数限流器.
+// 不使用固定的上限, 而是由 LimitAlgorithm 根据 Builder 报告的请求耗时与结果调整并发上限.
+// 限制的是整个服务的并发数, 忽略 key. 与 Limiter 接口的约定一致, Limit 总是增加计数. 并发安全.
+type AdaptiveLimiter struct {
+	algorithm LimitAlgorithm
+	minLimit  int
+	maxLimit  int
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+}
+
+// AdaptiveOption 定义 AdaptiveLimiter 的选项.
+type AdaptiveOption func(*AdaptiveLimiter)
+
+// WithInitialLimit 设置初始的并发上限. 默认为 20.
+func WithInitialLimit(n int) AdaptiveOption {
+	return func(l *AdaptiveLimiter) {
+		l.limit = float64(n)
+	}
+}
+
+// WithLimitRange 设置并发上限的范围. 默认为 [1, 1000].
+func WithLimitRange(minLimit, maxLimit int) AdaptiveOption {
+	return func(l *AdaptiveLimiter) {
+		l.minLimit = minLimit
+		l.maxLimit = maxLimit
+	}
+}
+
+// NewAdaptiveLimiter 创建一个自适应的活跃请求数限流器.
+// algorithm: 调整并发上限的算法, 例如 NewAIMD 与 NewGradient.
+func NewAdaptiveLimiter(algorithm LimitAlgorithm, opts ...AdaptiveOption) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		algorithm: algorithm,
+		minLimit:  1,
+		maxLimit:  1000,
+		limit:     20,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.limit = l.clamp(l.limit)
+	return l
+}
+
+func (l *AdaptiveLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+// LimitDecision 与 Limit 相同, 同时返回当前的并发上限.
+func (l *AdaptiveLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+func (l *AdaptiveLimiter) LimitN(_ context.Context, _ string, n int) (ratelimit.Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight += n
+	limit := int(l.limit)
+	return ratelimit.Decision{
+		Limited:   l.inflight > limit,
+		Limit:     limit,
+		Remaining: limit - l.inflight,
+	}, nil
+}
+
+func (l *AdaptiveLimiter) Decr(ctx context.Context, key string) error {
+	return l.DecrN(ctx, key, 1)
+}
+
+func (l *AdaptiveLimiter) DecrN(_ context.Context, _ string, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight = max(l.inflight-n, 0)
+	return nil
+}
+
+// Observe 根据请求的耗时与结果调整并发上限.
+func (l *AdaptiveLimiter) Observe(_ context.Context, _ string, latency time.Duration, outcome Outcome) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = l.clamp(l.algorithm.Update(l.limit, l.inflight, latency, outcome))
+}
+
+// CurrentLimit 返回当前的并发上限.
+func (l *AdaptiveLimiter) CurrentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight 返回活跃请求数.
+func (l *AdaptiveLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.minLimit), math.Min(float64(l.maxLimit), limit))
+}
+
+// AIMD 加性增、乘性减算法.
+// 请求成功时上限加 1, 出现错误、被丢弃或者耗时超过 timeout 时上限乘以 backoff.
+// 活跃请求数不到上限的一半时说明上限不是瓶颈, 不增加上限.
+type AIMD struct {
+	backoff float64
+	timeout time.Duration
+}
+
+// AIMDOption 定义 AIMD 的选项.
+type AIMDOption func(*AIMD)
+
+// WithBackoff 设置减小上限时乘以的系数, 取值为 (0, 1). 默认为 0.9.
+func WithBackoff(backoff float64) AIMDOption {
+	return func(a *AIMD) {
+		a.backoff = backoff
+	}
+}
+
+// WithTimeout 设置视为拥塞的耗时. 默认为 0, 只根据请求的结果判断.
+func WithTimeout(timeout time.Duration) AIMDOption {
+	return func(a *AIMD) {
+		a.timeout = timeout
+	}
+}
+
+// NewAIMD 创建一个 AIMD 算法.
+func NewAIMD(opts ...AIMDOption) *AIMD {
+	a := &AIMD{backoff: 0.9}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *AIMD) Update(limit float64, inflight int, latency time.Duration, outcome Outcome) float64 {
+	if outcome != OutcomeSuccess || (a.timeout > 0 && latency > a.timeout) {
+		return limit * a.backoff
+	}
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Gradient 梯度算法, 参考 Netflix concurrency-limits 的 Gradient2.
+// 比较长期平均耗时与本次耗时: 耗时变长说明开始排队, 按比例减小上限; 否则增加 sqrt(limit) 的排队空间.
+//
+//	gradient = max(0.5, min(1, tolerance * longRTT / rtt))
+//	limit = limit * (1 - smoothing) + (limit * gradient + sqrt(limit)) * smoothing
+//
+// 请求被丢弃时上限减半; 出现错误时耗时没有参考价值, 忽略本次结果.
+type Gradient struct {
+	tolerance  float64
+	smoothing  float64
+	longWindow int
+
+	// longRTT 耗时的指数移动平均, 单位为秒.
+	longRTT float64
+}
+
+// GradientOption 定义 Gradient 的选项.
+type GradientOption func(*Gradient)
+
+// WithTolerance 设置允许的耗时增长倍数, 耗时不超过长期平均耗时的 tolerance 倍时不减小上限. 默认为 1.5.
+func WithTolerance(tolerance float64) GradientOption {
+	return func(g *Gradient) {
+		g.tolerance = tolerance
+	}
+}
+
+// WithSmoothing 设置上限变化的平滑系数, 取值为 (0, 1]. 默认为 0.2.
+func WithSmoothing(smoothing float64) GradientOption {
+	return func(g *Gradient) {
+		g.smoothing = smoothing
+	}
+}
+
+// WithLongWindow 设置长期平均耗时的样本数. 默认为 600.
+func WithLongWindow(n int) GradientOption {
+	return func(g *Gradient) {
+		g.longWindow = n
+	}
+}
+
+// NewGradient 创建一个梯度算法.
+func NewGradient(opts ...GradientOption) *Gradient {
+	g := &Gradient{
+		tolerance:  1.5,
+		smoothing:  0.2,
+		longWindow: 600,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+func (g *Gradient) Update(limit float64, inflight int, latency time.Duration, outcome Outcome) float64 {
+	switch outcome {
+	case OutcomeError:
+		return limit
+	case OutcomeDropped:
+		return limit / 2
+	}
+	rtt := latency.Seconds()
+	if rtt <= 0 {
+		return limit
+	}
+	if g.longRTT == 0 {
+		g.longRTT = rtt
+	} else {
+		g.longRTT += (rtt - g.longRTT) / float64(g.longWindow)
+	}
+	// 活跃请求数不到上限的一半时说明上限不是瓶颈, 不调整上限
+	if float64(inflight)*2 < limit {
+		return limit
+	}
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/rtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + newLimit*g.smoothing
+}
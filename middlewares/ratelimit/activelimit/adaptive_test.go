@@ -0,0 +1,151 @@
+package activelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIMD_Update(t *testing.T) {
+	tests := []struct {
+		name     string
+		aimd     *AIMD
+		limit    float64
+		inflight int
+		latency  time.Duration
+		outcome  Outcome
+		want     float64
+	}{
+		{
+			name:     "increase",
+			aimd:     NewAIMD(),
+			limit:    10,
+			inflight: 5,
+			want:     11,
+		},
+		{
+			// 活跃请求数不到上限的一半
+			name:     "app_limited",
+			aimd:     NewAIMD(),
+			limit:    10,
+			inflight: 4,
+			want:     10,
+		},
+		{
+			name:     "error",
+			aimd:     NewAIMD(WithBackoff(0.5)),
+			limit:    10,
+			inflight: 10,
+			outcome:  OutcomeError,
+			want:     5,
+		},
+		{
+			name:     "dropped",
+			aimd:     NewAIMD(),
+			limit:    10,
+			inflight: 10,
+			outcome:  OutcomeDropped,
+			want:     9,
+		},
+		{
+			name:     "timeout",
+			aimd:     NewAIMD(WithTimeout(time.Second)),
+			limit:    10,
+			inflight: 10,
+			latency:  2 * time.Second,
+			want:     9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.aimd.Update(tt.limit, tt.inflight, tt.latency, tt.outcome)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestGradient_Update(t *testing.T) {
+	g := NewGradient(WithSmoothing(1), WithTolerance(1))
+	// 耗时稳定时增加 sqrt(limit)
+	limit := g.Update(16, 16, 100*time.Millisecond, OutcomeSuccess)
+	assert.InDelta(t, 20, limit, 1e-9)
+
+	// 耗时变长时按比例减小, 最少减到一半
+	limit = g.Update(100, 100, 10*time.Second, OutcomeSuccess)
+	assert.InDelta(t, 60, limit, 1e-9)
+
+	// 活跃请求数不到上限的一半时不调整
+	assert.InDelta(t, 100, g.Update(100, 10, 10*time.Second, OutcomeSuccess), 1e-9)
+
+	// 错误时忽略, 被丢弃时减半
+	assert.InDelta(t, 100, g.Update(100, 100, 0, OutcomeError), 1e-9)
+	assert.InDelta(t, 50, g.Update(100, 100, 0, OutcomeDropped), 1e-9)
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := NewAdaptiveLimiter(NewAIMD(WithBackoff(0.5)),
+		WithInitialLimit(2), WithLimitRange(1, 3))
+	ctx := context.Background()
+	for _, want := range []bool{false, false, true} {
+		limited, err := l.Limit(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, want, limited)
+	}
+	require.NoError(t, l.Decr(ctx, "a"))
+	assert.Equal(t, 2, l.Inflight())
+
+	// 增加到上限的范围为止
+	l.Observe(ctx, "a", time.Millisecond, OutcomeSuccess)
+	l.Observe(ctx, "a", time.Millisecond, OutcomeSuccess)
+	assert.Equal(t, 3, l.CurrentLimit())
+	limited, err := l.Limit(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, limited)
+
+	// 减小到上限的范围为止
+	for i := 0; i < 3; i++ {
+		l.Observe(ctx, "a", time.Millisecond, OutcomeError)
+	}
+	assert.Equal(t, 1, l.CurrentLimit())
+}
+
+func TestAdaptiveLimiter_Builder(t *testing.T) {
+	l := NewAdaptiveLimiter(NewAIMD(WithBackoff(0.5)), WithInitialLimit(8))
+	server := gin.New()
+	server.Use(NewBuilder(l).Build())
+	server.GET("/:code", func(ctx *gin.Context) {
+		switch ctx.Param("code") {
+		case "503":
+			ctx.Status(http.StatusServiceUnavailable)
+		case "500":
+			ctx.Status(http.StatusInternalServerError)
+		default:
+			ctx.Status(http.StatusOK)
+		}
+	})
+	tests := []struct {
+		name      string
+		target    string
+		wantLimit int
+	}{
+		// 活跃请求数为 1, 不到上限的一半
+		{name: "ok", target: "/200", wantLimit: 8},
+		{name: "dropped", target: "/503", wantLimit: 4},
+		{name: "error", target: "/500", wantLimit: 2},
+		{name: "increase", target: "/200", wantLimit: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.wantLimit, l.CurrentLimit())
+			assert.Equal(t, 0, l.Inflight())
+		})
+	}
+}
@@ -1,17 +1,34 @@
 package activelimit
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
+// releaseTimeout 释放租约的超时时间.
+// 释放租约使用不随请求取消的 context, 需要单独限制时间.
+const releaseTimeout = 5 * time.Second
+
 type Builder struct {
 	limiter  Limiter
 	genKeyFn func(ctx *gin.Context) string
+	costFn   func(ctx *gin.Context) int
 	logger   *slog.Logger
+	failure  ratelimit.FailurePolicy
+	fallback Limiter
+
+	// failedKey 在 gin.Context.Keys 中记录本次请求是否没有使用 limiter 的结果,
+	// 此时 fail-open 不需要减少活跃请求数, fallback 需要减少备用限流器的活跃请求数.
+	// 每个 Builder 使用不同的 key, 多个 Builder 可以用于同一个请求.
+	failedKey string
 }
 
 // NewBuilder
@@ -22,7 +39,8 @@ func NewBuilder(limiter Limiter) *Builder {
 		genKeyFn: func(ctx *gin.Context) string {
 			return "all_req_active_limiter"
 		},
-		logger: slog.Default(),
+		logger:    slog.Default(),
+		failedKey: "ginx/activelimit_failed/" + newLeaseID(),
 	}
 }
 
@@ -31,6 +49,28 @@ func (b *Builder) SetKeyGenFunc(fn func(*gin.Context) string) *Builder {
 	return b
 }
 
+// SetCostFunc 设置请求的 cost, 即一个请求占用多少个活跃请求的名额. 默认为 1, 小于 1 时按 1 计算.
+// 同一个请求需要返回相同的值. cost 超过上限的请求总是返回 429.
+// Limiter 需要实现 CostLimiter, 否则返回 500.
+func (b *Builder) SetCostFunc(fn func(*gin.Context) int) *Builder {
+	b.costFn = fn
+	return b
+}
+
+// SetFailurePolicy 设置限流器出现错误时的处理方式, 默认返回 500.
+// fail-open 放行的请求结束时不减少活跃请求数.
+func (b *Builder) SetFailurePolicy(policy ratelimit.FailurePolicy) *Builder {
+	b.failure = policy
+	return b
+}
+
+// SetFallbackLimiter 设置 ratelimit.FailFallback 使用的备用限流器, 一般为上限按实例数缩小的 MemoryLimiter.
+// 使用备用限流器的请求结束时减少备用限流器的活跃请求数.
+func (b *Builder) SetFallbackLimiter(limiter Limiter) *Builder {
+	b.fallback = limiter
+	return b
+}
+
 func (b *Builder) SetLogger(logger *slog.Logger) *Builder {
 	b.logger = logger
 	return b
@@ -50,8 +90,22 @@ func (b *Builder) SetKeyGenFuncByIP() *Builder {
 	return b
 }
 
+// SetKeyGenFuncByClientIP 设置根据 r 解析的客户端 IP 进行限流.
+// 与 SetKeyGenFuncByIP 不同, 只信任 r 中配置的代理, 并且 IPv6 地址按网段聚合.
+func (b *Builder) SetKeyGenFuncByClientIP(r *ratelimit.ClientIPResolver) *Builder {
+	b.genKeyFn = func(ctx *gin.Context) string {
+		return "ip_active_limiter:" + r.Key(ctx)
+	}
+	return b
+}
+
+// Build 构建活跃请求数限流中间件.
+// 为每个请求生成租约 id 并设置到请求的 context.Context 中,
+// Limit 与 Decr 可以通过 LeaseIDFromContext 获取.
 func (b *Builder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		ctx.Request = ctx.Request.WithContext(
+			ContextWithLeaseID(ctx.Request.Context(), newLeaseID()))
 		limited, err := b.limit(ctx)
 		if err != nil {
 			b.logger.LogAttrs(ctx.Request.Context(), slog.LevelError,
@@ -70,14 +124,82 @@ func (b *Builder) Build() gin.HandlerFunc {
 			ctx.AbortWithStatus(http.StatusTooManyRequests)
 			return
 		}
+		start := time.Now()
+		ctx.Next()
+		b.observe(ctx, time.Since(start))
+	}
+}
+
+// BuildShadow 构建影子活跃请求数限流中间件, 只记录决策, 总是放行请求. 详见 ratelimit.ShadowOpts.
+// 使用新生成的租约 id, 请求结束后减少活跃请求数, 并向实现了 Observer 的 Limiter 报告请求的耗时与结果.
+func (b *Builder) BuildShadow(opts ratelimit.ShadowOpts) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// 总是使用自己的租约 id, 与同一个请求中其他 Builder 的租约互不影响
+		c := ContextWithLeaseID(ctx.Request.Context(), newLeaseID())
+		key, cost := b.genKeyFn(ctx), b.cost(ctx)
+		if _, err := opts.Decide(c, b.logger, b.limiter, key, cost); err == nil {
+			defer func() {
+				if err := b.decrWith(c, b.limiter, key, cost); err != nil {
+					b.logger.LogAttrs(c, slog.LevelError, "影子限流器出现错误", slog.Any("err", err))
+				}
+			}()
+		}
+		start := time.Now()
 		ctx.Next()
+		b.observe(ctx, time.Since(start))
 	}
 }
 
 func (b *Builder) limit(ctx *gin.Context) (bool, error) {
-	return b.limiter.Limit(ctx.Request.Context(), b.genKeyFn(ctx))
+	limited, failed, err := b.failure.Limit(ctx, b.logger, b.limiter, b.fallback, b.genKeyFn(ctx), b.cost(ctx))
+	ctx.Set(b.failedKey, failed)
+	return limited, err
 }
 
 func (b *Builder) decr(ctx *gin.Context) error {
-	return b.limiter.Decr(ctx.Request.Context(), b.genKeyFn(ctx))
+	limiter := b.limiter
+	if ctx.GetBool(b.failedKey) {
+		if b.failure.Mode != ratelimit.FailFallback {
+			return nil
+		}
+		limiter = b.fallback
+	}
+	return b.decrWith(ctx.Request.Context(), limiter, b.genKeyFn(ctx), b.cost(ctx))
+}
+
+// decrWith 减少活跃请求数.
+// 使用不随请求取消的 context, 避免客户端断开连接后租约直到过期才释放.
+func (b *Builder) decrWith(c context.Context, limiter Limiter, key string, cost int) error {
+	c, cancel := context.WithTimeout(context.WithoutCancel(c), releaseTimeout)
+	defer cancel()
+	if l, ok := limiter.(CostLimiter); ok {
+		return l.DecrN(c, key, cost)
+	}
+	return limiter.Decr(c, key)
+}
+
+func (b *Builder) cost(ctx *gin.Context) int {
+	return ratelimit.Cost(ctx, b.costFn)
+}
+
+// observe 向实现了 Observer 的 Limiter 报告请求的耗时与结果.
+func (b *Builder) observe(ctx *gin.Context, latency time.Duration) {
+	o, ok := b.limiter.(Observer)
+	if !ok {
+		return
+	}
+	o.Observe(ctx.Request.Context(), b.genKeyFn(ctx), latency, outcomeOf(ctx))
+}
+
+func outcomeOf(ctx *gin.Context) Outcome {
+	status := ctx.Writer.Status()
+	switch {
+	case status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout,
+		errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded):
+		return OutcomeDropped
+	case status >= http.StatusInternalServerError:
+		return OutcomeError
+	default:
+		return OutcomeSuccess
+	}
 }
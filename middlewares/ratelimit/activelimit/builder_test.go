@@ -6,10 +6,14 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
 func TestBuilder_SetKeyGenFunc(t *testing.T) {
@@ -233,6 +237,83 @@ func TestBuilder_Build(t *testing.T) {
 	}
 }
 
+func TestBuilder_SetCostFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		limiter  Limiter
+		cost     int
+		wantCode int
+	}{
+		{
+			name:     "cost",
+			limiter:  NewMemoryLimiter(10),
+			cost:     6,
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:     "within_limit",
+			limiter:  NewMemoryLimiter(10),
+			cost:     4,
+			wantCode: http.StatusOK,
+		},
+		{
+			// 超过上限时总是限流
+			name:     "exceed_capacity",
+			limiter:  NewMemoryLimiter(10),
+			cost:     11,
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:     "not_supported",
+			limiter:  &mockLimiter{},
+			cost:     2,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			server := gin.New()
+			server.Use(NewBuilder(tt.limiter).SetCostFunc(func(ctx *gin.Context) int {
+				if ctx.FullPath() == "/slow" {
+					return 5
+				}
+				return tt.cost
+			}).Build())
+			server.GET("/slow", func(ctx *gin.Context) {
+				close(entered)
+				<-release
+				ctx.Status(http.StatusOK)
+			})
+			server.GET("/limit", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			if l, ok := tt.limiter.(*MemoryLimiter); ok {
+				// 先占用 5 个名额
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					recorder := httptest.NewRecorder()
+					server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+					assert.Equal(t, http.StatusOK, recorder.Code)
+				}()
+				<-entered
+				defer func() {
+					close(release)
+					wg.Wait()
+					// 按相同的 cost 释放
+					assert.Equal(t, 0, l.Active())
+				}()
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+			assert.Equal(t, tt.wantCode, recorder.Code)
+		})
+	}
+}
+
 func (b *Builder) RegisterRoutes(server *gin.Engine) {
 	server.GET("/limit", func(ctx *gin.Context) {
 		ctx.Status(http.StatusOK)
@@ -252,3 +333,73 @@ func (l *mockLimiter) Limit(_ context.Context, _ string) (bool, error) {
 func (l *mockLimiter) Decr(_ context.Context, _ string) error {
 	return l.decrErr
 }
+
+func TestBuilder_SetFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     ratelimit.FailureMode
+		wantCode int
+		// 预期请求处理时备用限流器的活跃请求数
+		wantActive int
+	}{
+		{name: "fail_closed", mode: ratelimit.FailClosed, wantCode: http.StatusInternalServerError},
+		{name: "fail_open", mode: ratelimit.FailOpen, wantCode: http.StatusOK},
+		{name: "fallback", mode: ratelimit.FailFallback, wantCode: http.StatusOK, wantActive: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := NewMemoryLimiter(1)
+			// fail-open 与 fallback 不会调用 limiter 的 Decr
+			limiter := &mockLimiter{limitErr: errors.New("mock error"), decrErr: errors.New("unexpected decr")}
+			server := gin.New()
+			server.Use(NewBuilder(limiter).
+				SetFailurePolicy(ratelimit.FailurePolicy{Mode: tt.mode}).
+				SetFallbackLimiter(fallback).Build())
+			var active int
+			server.GET("/limit", func(ctx *gin.Context) {
+				active = fallback.Active()
+				ctx.Status(http.StatusOK)
+			})
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantActive, active)
+			assert.Equal(t, 0, fallback.Active())
+		})
+	}
+}
+
+func TestBuilder_BuildShadow(t *testing.T) {
+	shadow := NewMemoryLimiter(1)
+	server := gin.New()
+	server.Use(NewBuilder(shadow).BuildShadow(ratelimit.ShadowOpts{Name: "new"}))
+	// 影子限流在前, 现有的限流重新设置租约 id 时不影响影子限流的释放
+	server.Use(NewBuilder(NewMemoryLimiter(10)).Build())
+	var active []int
+	server.GET("/limit", func(ctx *gin.Context) {
+		active = append(active, shadow.Active())
+		if len(active) == 1 {
+			// 嵌套的请求超过影子限流的上限, 仍然放行
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		}
+		ctx.Status(http.StatusOK)
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []int{1, 2}, active)
+	assert.Equal(t, 0, shadow.Active())
+}
+
+func TestBuilder_SetKeyGenFuncByClientIP(t *testing.T) {
+	r, err := ratelimit.NewClientIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	b := NewBuilder(&mockLimiter{}).SetKeyGenFuncByClientIP(r)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.RemoteAddr = "10.0.0.1:1234"
+	ctx.Request.Header.Set("X-Forwarded-For", "2001:db8:1:2:3:4:5:6")
+	assert.Equal(t, "ip_active_limiter:2001:db8:1:2::/64", b.genKeyFn(ctx))
+}
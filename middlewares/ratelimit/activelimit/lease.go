@@ -0,0 +1,30 @@
+package activelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// leaseIDKey 定义从 context.Context 中设置/获取租约 id 的 key.
+type leaseIDKey struct{}
+
+// ContextWithLeaseID 为请求的租约 id 创建 context.
+// Builder 在调用 Limit 之前为每个请求生成租约 id, Limit 与 Decr 使用同一个 id.
+func ContextWithLeaseID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, leaseIDKey{}, id)
+}
+
+// LeaseIDFromContext 从 context 中获取请求的租约 id.
+// 没有租约 id 时返回 false.
+func LeaseIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(leaseIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// newLeaseID 生成一个随机的租约 id.
+func newLeaseID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
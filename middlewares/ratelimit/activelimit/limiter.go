@@ -1,6 +1,11 @@
 package activelimit
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
 
 // Limiter 活跃请求数限流
 type Limiter interface {
@@ -13,3 +18,44 @@ type Limiter interface {
 	// Decr 活跃请求数减少1
 	Decr(ctx context.Context, key string) error
 }
+
+// DecisionLimiter 可选的 Limiter 接口, 返回限流的额度信息.
+// Builder 使用实现了该接口的 Limiter 时, 会设置 RateLimit-* 响应头, 限流时设置 Retry-After.
+// 活跃请求数与时间无关, Decision 的 Window 为 0.
+type DecisionLimiter interface {
+	Limiter
+
+	// LimitDecision 与 Limit 相同, 同时返回额度信息.
+	LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error)
+}
+
+// CostLimiter 可选的 Limiter 接口, 支持一个请求占用多个活跃请求的名额.
+// Builder 通过 SetCostFunc 设置了请求的 cost 时, Limiter 需要实现该接口.
+type CostLimiter interface {
+	DecisionLimiter
+
+	// LimitN 与 LimitDecision 相同, 活跃请求数原子地增加 n.
+	// n 超过上限时总是限流.
+	LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error)
+
+	// DecrN 活跃请求数减少 n
+	DecrN(ctx context.Context, key string, n int) error
+}
+
+// Outcome 请求的结果.
+type Outcome int
+
+const (
+	// OutcomeSuccess 请求成功.
+	OutcomeSuccess Outcome = iota
+	// OutcomeError 服务端错误, 即 5xx 响应.
+	OutcomeError
+	// OutcomeDropped 请求超时或者被下游拒绝, 即 503/504 响应或者请求的 context 超时.
+	OutcomeDropped
+)
+
+// Observer 可选的 Limiter 接口.
+// Builder 使用实现了该接口的 Limiter 时, 在没有被限流的请求结束后报告请求的耗时与结果.
+type Observer interface {
+	Observe(ctx context.Context, key string, latency time.Duration, outcome Outcome)
+}
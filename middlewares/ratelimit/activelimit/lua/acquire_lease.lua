@@ -0,0 +1,26 @@
+-- KEYS[1]: 限流的 key
+-- ARGV[1]: 当前时间 (毫秒)
+-- ARGV[2]: 租约的过期时间 (毫秒)
+-- ARGV[3]: 最大活跃请求数
+-- ARGV[4]: 租约 id
+-- ARGV[5]: key 的过期时间 (毫秒)
+-- ARGV[6]: 占用的名额, 第 i (i > 1) 个名额的成员为 "租约 id:i"
+-- 返回 {是否限流 (1 表示限流, 0 表示通过), 活跃请求数}
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local expireAt = tonumber(ARGV[2])
+local maxActive = tonumber(ARGV[3])
+local n = tonumber(ARGV[6])
+
+-- 清理已过期的租约, 例如持有者崩溃后没有释放的租约
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+local cnt = redis.call('ZCARD', key)
+if cnt + n > maxActive then
+    return {1, cnt}
+end
+redis.call('ZADD', key, expireAt, ARGV[4])
+for i = 2, n do
+    redis.call('ZADD', key, expireAt, ARGV[4] .. ':' .. i)
+end
+redis.call('PEXPIRE', key, ARGV[5])
+return {0, cnt + n}
@@ -0,0 +1,141 @@
+package activelimit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+var _ CostLimiter = (*MemoryLimiter)(nil)
+
+// MemoryLimiter 基于内存的活跃请求数限流器.
+// 同时限制全部 key 的活跃请求总数与每个 key 的活跃请求数,
+// 例如总共最多 500 个, 每个 IP 最多 5 个活跃请求.
+// 与 Limiter 接口的约定一致, Limit 总是增加计数, 需要调用 Decr 减少.
+// 计数为 0 的 key 会被删除, 内存占用不会随着 key 的数量增长. 并发安全.
+type MemoryLimiter struct {
+	// maxActive 活跃请求总数的上限. 为 0 时不限制.
+	maxActive int
+	// maxPerKey 返回 key 的活跃请求数上限. 为 nil 或返回 0 时不限制.
+	maxPerKey func(key string) int
+
+	mu     sync.Mutex
+	total  int
+	counts map[string]int
+}
+
+// MemoryOption 定义 MemoryLimiter 的选项.
+type MemoryOption func(*MemoryLimiter)
+
+// WithMaxPerKey 设置每个 key 的活跃请求数上限.
+func WithMaxPerKey(n int) MemoryOption {
+	return WithMaxPerKeyFunc(func(string) int {
+		return n
+	})
+}
+
+// WithMaxPerKeyFunc 设置返回 key 的活跃请求数上限的方法, 可以为不同的 key 设置不同的上限.
+// 返回 0 时不限制.
+func WithMaxPerKeyFunc(fn func(key string) int) MemoryOption {
+	return func(l *MemoryLimiter) {
+		l.maxPerKey = fn
+	}
+}
+
+// NewMemoryLimiter 创建一个基于内存的活跃请求数限流器.
+// maxActive: 活跃请求总数的上限, 为 0 时不限制.
+func NewMemoryLimiter(maxActive int, opts ...MemoryOption) *MemoryLimiter {
+	l := &MemoryLimiter{
+		maxActive: maxActive,
+		counts:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *MemoryLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+// LimitDecision 与 Limit 相同, 同时返回额度信息.
+// 同时限制总数与每个 key 时, 返回触发限流或者剩余额度较少的一个.
+func (l *MemoryLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+func (l *MemoryLimiter) LimitN(_ context.Context, key string, n int) (ratelimit.Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total += n
+	cnt := l.counts[key] + n
+	l.counts[key] = cnt
+	var d ratelimit.Decision
+	if l.maxActive > 0 {
+		d = ratelimit.Decision{
+			Limited:   l.total > l.maxActive,
+			Limit:     l.maxActive,
+			Remaining: l.maxActive - l.total,
+		}
+	}
+	if d.Limited || l.maxPerKey == nil {
+		return d, nil
+	}
+	if m := l.maxPerKey(key); m > 0 && (d.Limit == 0 || m-cnt < d.Remaining) {
+		d = ratelimit.Decision{
+			Limited:   cnt > m,
+			Limit:     m,
+			Remaining: m - cnt,
+		}
+	}
+	return d, nil
+}
+
+func (l *MemoryLimiter) Decr(ctx context.Context, key string) error {
+	return l.DecrN(ctx, key, 1)
+}
+
+func (l *MemoryLimiter) DecrN(_ context.Context, key string, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cnt, ok := l.counts[key]
+	if !ok {
+		return nil
+	}
+	n = min(n, cnt)
+	l.total -= n
+	if cnt <= n {
+		delete(l.counts, key)
+		return nil
+	}
+	l.counts[key] = cnt - n
+	return nil
+}
+
+// Active 返回活跃请求总数.
+func (l *MemoryLimiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+// ActiveByKey 返回 key 的活跃请求数.
+func (l *MemoryLimiter) ActiveByKey(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[key]
+}
+
+// Snapshot 返回全部活跃请求数不为 0 的 key 及其活跃请求数.
+func (l *MemoryLimiter) Snapshot() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make(map[string]int, len(l.counts))
+	for k, v := range l.counts {
+		res[k] = v
+	}
+	return res
+}
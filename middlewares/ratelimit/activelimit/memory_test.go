@@ -0,0 +1,280 @@
+package activelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	type step struct {
+		// decr 为 true 时调用 Decr, 否则调用 Limit
+		decr bool
+		key  string
+		want bool
+	}
+	tests := []struct {
+		name       string
+		limiter    *MemoryLimiter
+		steps      []step
+		wantActive int
+		wantKeys   map[string]int
+	}{
+		{
+			name:    "global",
+			limiter: NewMemoryLimiter(2),
+			steps: []step{
+				{key: "a"},
+				{key: "b"},
+				{key: "c", want: true},
+				{decr: true, key: "c"},
+				{decr: true, key: "a"},
+				{key: "c"},
+			},
+			wantActive: 2,
+			wantKeys:   map[string]int{"b": 1, "c": 1},
+		},
+		{
+			name:    "per_key",
+			limiter: NewMemoryLimiter(0, WithMaxPerKey(1)),
+			steps: []step{
+				{key: "a"},
+				{key: "a", want: true},
+				{key: "b"},
+				{decr: true, key: "a"},
+				{decr: true, key: "a"},
+				{key: "a"},
+			},
+			wantActive: 2,
+			wantKeys:   map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name: "per_key_func",
+			limiter: NewMemoryLimiter(3, WithMaxPerKeyFunc(func(key string) int {
+				if key == "vip" {
+					return 0
+				}
+				return 1
+			})),
+			steps: []step{
+				{key: "vip"},
+				{key: "vip"},
+				{key: "a"},
+				// 超过总数
+				{key: "vip", want: true},
+				{decr: true, key: "vip"},
+				{key: "a", want: true},
+			},
+			wantActive: 4,
+			wantKeys:   map[string]int{"vip": 2, "a": 2},
+		},
+		{
+			// 没有计数的 key 不影响总数
+			name:    "decr_unknown_key",
+			limiter: NewMemoryLimiter(1),
+			steps: []step{
+				{decr: true, key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+			},
+			wantActive: 2,
+			wantKeys:   map[string]int{"a": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			for i, s := range tt.steps {
+				if s.decr {
+					require.NoError(t, tt.limiter.Decr(ctx, s.key))
+					continue
+				}
+				limited, err := tt.limiter.Limit(ctx, s.key)
+				require.NoError(t, err)
+				assert.Equal(t, s.want, limited, "step %d", i)
+			}
+			assert.Equal(t, tt.wantActive, tt.limiter.Active())
+			assert.Equal(t, tt.wantKeys, tt.limiter.Snapshot())
+			for k, v := range tt.wantKeys {
+				assert.Equal(t, v, tt.limiter.ActiveByKey(k))
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter_LimitDecision(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *MemoryLimiter
+		keys    []string
+		want    ratelimit.Decision
+	}{
+		{
+			name:    "unlimited",
+			limiter: NewMemoryLimiter(0),
+			keys:    []string{"a"},
+			want:    ratelimit.Decision{},
+		},
+		{
+			name:    "global",
+			limiter: NewMemoryLimiter(3, WithMaxPerKey(5)),
+			keys:    []string{"a", "b"},
+			want:    ratelimit.Decision{Limit: 3, Remaining: 1},
+		},
+		{
+			// 每个 key 的剩余额度较少
+			name:    "per_key",
+			limiter: NewMemoryLimiter(10, WithMaxPerKey(2)),
+			keys:    []string{"a", "a"},
+			want:    ratelimit.Decision{Limit: 2, Remaining: 0},
+		},
+		{
+			name:    "global_limited",
+			limiter: NewMemoryLimiter(2, WithMaxPerKey(5)),
+			keys:    []string{"b", "a", "a"},
+			want:    ratelimit.Decision{Limited: true, Limit: 2, Remaining: -1},
+		},
+		{
+			name:    "per_key_limited",
+			limiter: NewMemoryLimiter(10, WithMaxPerKey(1)),
+			keys:    []string{"a", "a"},
+			want:    ratelimit.Decision{Limited: true, Limit: 1, Remaining: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d ratelimit.Decision
+			for _, key := range tt.keys {
+				var err error
+				d, err = tt.limiter.LimitDecision(context.Background(), key)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, d)
+		})
+	}
+}
+
+func TestMemoryLimiter_LimitN(t *testing.T) {
+	l := NewMemoryLimiter(10, WithMaxPerKey(6))
+	ctx := context.Background()
+	steps := []struct {
+		key  string
+		n    int
+		want ratelimit.Decision
+	}{
+		{key: "a", n: 4, want: ratelimit.Decision{Limit: 6, Remaining: 2}},
+		{key: "a", n: 3, want: ratelimit.Decision{Limited: true, Limit: 6, Remaining: -1}},
+		// 限流时同样增加计数, 超过总数
+		{key: "b", n: 5, want: ratelimit.Decision{Limited: true, Limit: 10, Remaining: -2}},
+	}
+	for i, s := range steps {
+		d, err := l.LimitN(ctx, s.key, s.n)
+		require.NoError(t, err)
+		assert.Equal(t, s.want, d, "step %d", i)
+	}
+	assert.Equal(t, map[string]int{"a": 7, "b": 5}, l.Snapshot())
+	require.NoError(t, l.DecrN(ctx, "a", 3))
+	require.NoError(t, l.DecrN(ctx, "b", 5))
+	// 减少的数量超过计数时按计数减少
+	require.NoError(t, l.DecrN(ctx, "a", 10))
+	assert.Equal(t, 0, l.Active())
+	assert.Empty(t, l.Snapshot())
+}
+
+func TestMemoryLimiter_Cleanup(t *testing.T) {
+	l := NewMemoryLimiter(0, WithMaxPerKey(5))
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		_, err := l.Limit(ctx, key)
+		require.NoError(t, err)
+		require.NoError(t, l.Decr(ctx, key))
+	}
+	assert.Empty(t, l.Snapshot())
+	assert.Equal(t, 0, l.Active())
+}
+
+func TestMemoryLimiter_Concurrent(t *testing.T) {
+	l := NewMemoryLimiter(50, WithMaxPerKey(10))
+	// 同时持有名额的请求数
+	var holding, maxHolding atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				limited, err := l.Limit(context.Background(), key)
+				assert.NoError(t, err)
+				if !limited {
+					n := holding.Add(1)
+					for cur := maxHolding.Load(); n > cur && !maxHolding.CompareAndSwap(cur, n); {
+						cur = maxHolding.Load()
+					}
+					holding.Add(-1)
+				}
+				assert.NoError(t, l.Decr(context.Background(), key))
+			}
+		}(strconv.Itoa(i % 10))
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxHolding.Load(), int64(50))
+	assert.Equal(t, 0, l.Active())
+	assert.Empty(t, l.Snapshot())
+}
+
+func TestMemoryLimiter_Builder(t *testing.T) {
+	l := NewMemoryLimiter(0, WithMaxPerKey(1))
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server := gin.New()
+	server.Use(NewBuilder(l).SetKeyGenFuncByIP().Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		if ctx.Query("slow") != "" {
+			close(entered)
+			<-release
+		}
+		ctx.Status(http.StatusOK)
+	})
+	do := func(target, ip string) int {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		require.NoError(t, err)
+		req.RemoteAddr = ip + ":80"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		if recorder.Code == http.StatusTooManyRequests {
+			assert.Equal(t, http.Header{
+				"Ratelimit-Limit":     {"1"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Policy":    {"1"},
+				"Retry-After":         {"1"},
+			}, recorder.Header())
+		}
+		return recorder.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, do("/limit?slow=1", "10.0.0.1"))
+	}()
+	<-entered
+	assert.Equal(t, 1, l.ActiveByKey("ip_active_limiter:10.0.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, do("/limit", "10.0.0.1"))
+	// 每个 IP 单独计数
+	assert.Equal(t, http.StatusOK, do("/limit", "10.0.0.2"))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 0, l.Active())
+}
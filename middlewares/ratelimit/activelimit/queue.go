@@ -0,0 +1,317 @@
+package activelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 排队的结果.
+const (
+	QueueOutcomeAcquired = "acquired"
+	QueueOutcomeFull     = "full"
+	QueueOutcomeTimeout  = "timeout"
+	QueueOutcomeCanceled = "canceled"
+)
+
+// QueueOpts 定义排队模式的配置.
+type QueueOpts struct {
+	// MaxQueue 等待队列的最大长度. 队列已满时返回 503. 为 0 时不排队, 直接返回 503.
+	MaxQueue int
+
+	// MaxWait 最长等待时间. 请求的 context 有截止时间时以较早的为准.
+	// 为 0 时只使用 context 的截止时间.
+	MaxWait time.Duration
+
+	// LIFOThreshold 队列长度达到该值时视为过载, 改为后进先出:
+	// 过载时较早的请求更可能已经超时, 优先处理最新的请求.
+	// 为 0 时始终先进先出.
+	LIFOThreshold int
+
+	// Metrics 排队指标. 为 nil 时不记录.
+	Metrics *QueueMetrics
+}
+
+// QueueMetricsOpts 定义排队指标的配置.
+type QueueMetricsOpts struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels prometheus.Labels
+
+	// Buckets 等待时间直方图的桶, 单位为秒.
+	// 默认使用 prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// QueueMetrics 定义排队的 prometheus 指标.
+// 为 nil 时不记录任何指标.
+type QueueMetrics struct {
+	depth        prometheus.Gauge
+	waitDuration *prometheus.HistogramVec
+}
+
+// NewQueueMetrics 创建排队指标并注册到 reg 中.
+// reg 为 nil 时使用 prometheus.DefaultRegisterer. 注册失败时 panic.
+func NewQueueMetrics(reg prometheus.Registerer, opts QueueMetricsOpts) *QueueMetrics {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	m := &QueueMetrics{
+		depth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "active_limit_queue_depth",
+			Help:        "等待活跃请求名额的请求数",
+			ConstLabels: opts.ConstLabels,
+		}),
+		waitDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "active_limit_queue_wait_seconds",
+			Help:        "排队等待的时间, 按结果区分",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     buckets,
+		}, []string{"outcome"}),
+	}
+	reg.MustRegister(m.depth, m.waitDuration)
+	return m
+}
+
+func (m *QueueMetrics) setDepth(n int) {
+	if m == nil {
+		return
+	}
+	m.depth.Set(float64(n))
+}
+
+func (m *QueueMetrics) observeWait(outcome string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.waitDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+// BuildQueued 构建排队模式的活跃请求数限流中间件.
+// 与 Build 不同, 达到上限的请求进入有界的等待队列, 由本中间件中结束的请求唤醒后重试.
+// 队列已满、等待超时或者请求被取消时返回 503.
+// 使用 Redis 等多个实例共享的 Limiter 时, 其他实例释放的名额不会唤醒等待的请求.
+func (b *Builder) BuildQueued(opts QueueOpts) gin.HandlerFunc {
+	q := &waitQueue{
+		maxLen:        opts.MaxQueue,
+		lifoThreshold: opts.LIFOThreshold,
+		metrics:       opts.Metrics,
+	}
+	return func(ctx *gin.Context) {
+		ctx.Request = ctx.Request.WithContext(
+			ContextWithLeaseID(ctx.Request.Context(), newLeaseID()))
+		limited, err := b.limit(ctx)
+		if err != nil {
+			b.logger.LogAttrs(ctx.Request.Context(), slog.LevelError,
+				"限流器出现错误", slog.Any("err", err))
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if limited {
+			// Limit 总是增加计数, 排队前先归还
+			b.release(ctx, nil)
+			if err = b.wait(ctx, q, opts.MaxWait); err != nil {
+				if !errors.Is(err, errQueueRejected) {
+					b.logger.LogAttrs(ctx.Request.Context(), slog.LevelError,
+						"限流器出现错误", slog.Any("err", err))
+					ctx.AbortWithStatus(http.StatusInternalServerError)
+					return
+				}
+				ctx.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer b.release(ctx, q)
+		start := time.Now()
+		ctx.Next()
+		b.observe(ctx, time.Since(start))
+	}
+}
+
+// errQueueRejected 队列已满或者等待超时.
+var errQueueRejected = errors.New("排队失败")
+
+// wait 排队直到得到名额. 得到名额时返回 nil.
+func (b *Builder) wait(ctx *gin.Context, q *waitQueue, maxWait time.Duration) error {
+	start := time.Now()
+	w, ok := q.push()
+	if !ok {
+		q.metrics.observeWait(QueueOutcomeFull, 0)
+		return errQueueRejected
+	}
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		// 入队后再重试一次, 避免错过入队前释放的名额
+		gen := q.generation()
+		limited, err := b.limit(ctx)
+		if err != nil {
+			q.remove(w)
+			return err
+		}
+		if !limited {
+			q.remove(w)
+			q.metrics.observeWait(QueueOutcomeAcquired, time.Since(start))
+			return nil
+		}
+		// 重试期间有名额释放时, 被唤醒的请求可能因为这次重试的计数没有得到名额,
+		// 归还计数后把唤醒传递下去, 避免名额空闲
+		b.release(ctx, nil)
+		if q.generation() != gen {
+			q.notify()
+		}
+		select {
+		case <-w.ready:
+			// 被唤醒时已经出队, 放回下一个被唤醒的位置后重试
+			q.requeue(w)
+		case <-timeout:
+			q.remove(w)
+			q.metrics.observeWait(QueueOutcomeTimeout, time.Since(start))
+			return errQueueRejected
+		case <-ctx.Request.Context().Done():
+			q.remove(w)
+			outcome := QueueOutcomeCanceled
+			if errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded) {
+				outcome = QueueOutcomeTimeout
+			}
+			q.metrics.observeWait(outcome, time.Since(start))
+			return errQueueRejected
+		}
+	}
+}
+
+// release 减少活跃请求数, q 不为 nil 时唤醒一个等待的请求.
+func (b *Builder) release(ctx *gin.Context, q *waitQueue) {
+	if err := b.decr(ctx); err != nil {
+		b.logger.LogAttrs(ctx.Request.Context(), slog.LevelError,
+			"限流器出现错误", slog.Any("err", err))
+	}
+	if q != nil {
+		q.notify()
+	}
+}
+
+// waitQueue 有界的等待队列. 并发安全.
+type waitQueue struct {
+	maxLen        int
+	lifoThreshold int
+	metrics       *QueueMetrics
+
+	mu      sync.Mutex
+	waiters []*waiter
+	// gen 每次唤醒时增加.
+	gen uint64
+}
+
+type waiter struct {
+	// ready 被唤醒时写入, 容量为 1.
+	ready chan struct{}
+}
+
+// push 加入队尾. 队列已满时返回 false.
+func (q *waitQueue) push() (*waiter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) >= q.maxLen {
+		return nil, false
+	}
+	w := &waiter{ready: make(chan struct{}, 1)}
+	q.waiters = append(q.waiters, w)
+	q.metrics.setDepth(len(q.waiters))
+	return w, true
+}
+
+// requeue 把被唤醒后重试的请求放回下一个被唤醒的位置, 不受队列长度的限制.
+// 过载时放回队尾, 否则放回队首.
+func (q *waitQueue) requeue(w *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.lifo(len(q.waiters) + 1) {
+		q.waiters = append(q.waiters, w)
+	} else {
+		q.waiters = append(q.waiters, nil)
+		copy(q.waiters[1:], q.waiters)
+		q.waiters[0] = w
+	}
+	q.metrics.setDepth(len(q.waiters))
+}
+
+// remove 把 w 移出队列.
+// w 已经被唤醒出队时, 把唤醒转交给下一个等待的请求, 避免名额空闲.
+func (q *waitQueue) remove(w *waiter) {
+	q.mu.Lock()
+	for i, v := range q.waiters {
+		if v == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.metrics.setDepth(len(q.waiters))
+			q.mu.Unlock()
+			return
+		}
+	}
+	q.mu.Unlock()
+	select {
+	case <-w.ready:
+		q.notify()
+	default:
+	}
+}
+
+// notify 唤醒一个等待的请求. 过载时唤醒最新的请求, 否则唤醒最早的请求.
+func (q *waitQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.gen++
+	n := len(q.waiters)
+	if n == 0 {
+		return
+	}
+	var w *waiter
+	if q.lifo(n) {
+		w = q.waiters[n-1]
+		q.waiters[n-1] = nil
+		q.waiters = q.waiters[:n-1]
+	} else {
+		w = q.waiters[0]
+		q.waiters[0] = nil
+		q.waiters = q.waiters[1:]
+	}
+	q.metrics.setDepth(len(q.waiters))
+	w.ready <- struct{}{}
+}
+
+// lifo 返回队列长度为 n 时是否过载.
+func (q *waitQueue) lifo(n int) bool {
+	return q.lifoThreshold > 0 && n >= q.lifoThreshold
+}
+
+// generation 返回唤醒的次数.
+func (q *waitQueue) generation() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.gen
+}
+
+// Len 返回等待的请求数.
+func (q *waitQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
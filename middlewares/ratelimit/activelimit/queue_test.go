@@ -0,0 +1,286 @@
+package activelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitQueue_Notify(t *testing.T) {
+	tests := []struct {
+		name          string
+		lifoThreshold int
+		// want 依次唤醒的请求在队列中的序号
+		want []int
+	}{
+		{name: "fifo", want: []int{0, 1, 2, 3}},
+		// 队列长度达到 3 时后进先出
+		{name: "lifo_under_overload", lifoThreshold: 3, want: []int{3, 2, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &waitQueue{maxLen: 4, lifoThreshold: tt.lifoThreshold}
+			waiters := make([]*waiter, 4)
+			for i := range waiters {
+				w, ok := q.push()
+				require.True(t, ok)
+				waiters[i] = w
+			}
+			_, ok := q.push()
+			assert.False(t, ok)
+
+			for _, want := range tt.want {
+				q.notify()
+				for i, w := range waiters {
+					select {
+					case <-w.ready:
+						assert.Equal(t, want, i)
+					default:
+					}
+				}
+			}
+			assert.Equal(t, 0, q.Len())
+		})
+	}
+}
+
+func TestWaitQueue_Remove(t *testing.T) {
+	q := &waitQueue{maxLen: 2}
+	a, _ := q.push()
+	b, _ := q.push()
+	// a 被唤醒后放弃, 唤醒转交给 b
+	q.notify()
+	q.remove(a)
+	select {
+	case <-b.ready:
+	default:
+		t.Fatal("没有唤醒 b")
+	}
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestWaitQueue_Requeue(t *testing.T) {
+	tests := []struct {
+		name          string
+		lifoThreshold int
+	}{
+		{name: "fifo"},
+		{name: "lifo_under_overload", lifoThreshold: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &waitQueue{maxLen: 4, lifoThreshold: tt.lifoThreshold}
+			waiters := make([]*waiter, 4)
+			for i := range waiters {
+				waiters[i], _ = q.push()
+			}
+			// 重试失败放回队列后, 下一次仍然唤醒同一个请求
+			q.notify()
+			var woken *waiter
+			for _, w := range waiters {
+				select {
+				case <-w.ready:
+					woken = w
+				default:
+				}
+			}
+			require.NotNil(t, woken)
+			q.requeue(woken)
+			assert.Equal(t, 4, q.Len())
+			q.notify()
+			select {
+			case <-woken.ready:
+			default:
+				t.Fatal("没有唤醒放回队列的请求")
+			}
+		})
+	}
+}
+
+// hookLimiter 每次调用 Limit 之后调用 after, n 为调用的次数.
+type hookLimiter struct {
+	limiter *MemoryLimiter
+	after   func(n int)
+
+	mu sync.Mutex
+	n  int
+}
+
+func (l *hookLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	limited, err := l.limiter.Limit(ctx, key)
+	l.mu.Lock()
+	l.n++
+	n := l.n
+	l.mu.Unlock()
+	l.after(n)
+	return limited, err
+}
+
+func (l *hookLimiter) Decr(ctx context.Context, key string) error {
+	return l.limiter.Decr(ctx, key)
+}
+
+func TestBuilder_BuildQueuedRetry(t *testing.T) {
+	// slow 占用唯一的名额, a 与 b 依次排队.
+	// b 入队后重试期间 slow 结束并唤醒 a, a 因为 b 重试的计数没有得到名额,
+	// b 归还计数后需要再次唤醒 a.
+	release := make(chan struct{})
+	aQueued := make(chan struct{})
+	aRetried := make(chan struct{})
+	l := &hookLimiter{limiter: NewMemoryLimiter(1)}
+	l.after = func(n int) {
+		switch n {
+		case 3:
+			// a 入队后的重试
+			close(aQueued)
+		case 5:
+			// b 入队后的重试
+			close(release)
+			<-aRetried
+		case 6:
+			// a 被唤醒后的重试
+			close(aRetried)
+		}
+	}
+	entered := make(chan struct{})
+	server := gin.New()
+	server.Use(NewBuilder(l).BuildQueued(QueueOpts{MaxQueue: 2, MaxWait: time.Second}))
+	server.GET("/slow", func(ctx *gin.Context) {
+		close(entered)
+		<-release
+		ctx.Status(http.StatusOK)
+	})
+	server.GET("/queued", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	serve := func(path string) <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			code <- recorder.Code
+		}()
+		return code
+	}
+	slow := serve("/slow")
+	<-entered
+	a := serve("/queued")
+	<-aQueued
+	b := serve("/queued")
+
+	start := time.Now()
+	assert.Equal(t, http.StatusOK, <-slow)
+	assert.Equal(t, http.StatusOK, <-a)
+	assert.Equal(t, http.StatusOK, <-b)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, 0, l.limiter.Active())
+}
+
+func TestBuilder_BuildQueued(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     QueueOpts
+		timeout  time.Duration
+		wantCode int
+		// wantOutcome 排队请求的结果
+		wantOutcome string
+	}{
+		{
+			name:        "acquired",
+			opts:        QueueOpts{MaxQueue: 1},
+			wantCode:    http.StatusOK,
+			wantOutcome: QueueOutcomeAcquired,
+		},
+		{
+			name:        "full",
+			opts:        QueueOpts{},
+			wantCode:    http.StatusServiceUnavailable,
+			wantOutcome: QueueOutcomeFull,
+		},
+		{
+			name:        "max_wait",
+			opts:        QueueOpts{MaxQueue: 1, MaxWait: 10 * time.Millisecond},
+			wantCode:    http.StatusServiceUnavailable,
+			wantOutcome: QueueOutcomeTimeout,
+		},
+		{
+			// 最长等待时间来自请求的截止时间
+			name:        "deadline",
+			opts:        QueueOpts{MaxQueue: 1},
+			timeout:     10 * time.Millisecond,
+			wantCode:    http.StatusServiceUnavailable,
+			wantOutcome: QueueOutcomeTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			tt.opts.Metrics = NewQueueMetrics(reg, QueueMetricsOpts{})
+			l := NewMemoryLimiter(1)
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			server := gin.New()
+			server.Use(NewBuilder(l).BuildQueued(tt.opts))
+			server.GET("/slow", func(ctx *gin.Context) {
+				close(entered)
+				<-release
+				ctx.Status(http.StatusOK)
+			})
+			server.GET("/queued", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+				assert.Equal(t, http.StatusOK, recorder.Code)
+			}()
+			<-entered
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			req := httptest.NewRequest(http.MethodGet, "/queued", nil).WithContext(ctx)
+			recorder := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				server.ServeHTTP(recorder, req)
+			}()
+			if tt.wantCode == http.StatusOK {
+				require.Eventually(t, func() bool {
+					return testutil.ToFloat64(tt.opts.Metrics.depth) == 1
+				}, time.Second, time.Millisecond)
+				close(release)
+				<-done
+			} else {
+				<-done
+				close(release)
+			}
+			wg.Wait()
+
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			// 只有排队请求的结果
+			assert.Equal(t, 1, testutil.CollectAndCount(tt.opts.Metrics.waitDuration))
+			assert.True(t, tt.opts.Metrics.waitDuration.DeleteLabelValues(tt.wantOutcome))
+			assert.Equal(t, float64(0), testutil.ToFloat64(tt.opts.Metrics.depth))
+			assert.Equal(t, 0, l.Active())
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package activelimit
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+//go:embed lua/acquire_lease.lua
+var acquireLeaseLua string
+
+var acquireLeaseScript = redis.NewScript(acquireLeaseLua)
+
+// ErrLeaseIDNotFound context 中没有租约 id.
+var ErrLeaseIDNotFound = errors.New("context 中没有租约 id")
+
+var _ CostLimiter = (*RedisLimiter)(nil)
+
+// RedisLimiter 基于 Redis 租约的活跃请求数限流器, 可以在多个实例之间共享.
+// 每个活跃请求是有序集合中的一个租约, 分数为租约的过期时间.
+// 持有者崩溃没有调用 Decr 时, 租约在过期后被自动清理, 不会永久占用名额.
+// 需要通过 Builder 使用, 或者通过 ContextWithLeaseID 为每个请求设置租约 id.
+type RedisLimiter struct {
+	client    redis.Cmdable
+	prefix    string
+	maxActive int
+	// leaseTTL 租约的有效期, 需要大于请求的最长处理时间.
+	leaseTTL time.Duration
+	timeFunc func() time.Time
+}
+
+// RedisOption 定义 RedisLimiter 的选项.
+type RedisOption func(*RedisLimiter)
+
+// WithKeyPrefix 设置 Redis key 的前缀. 默认为 "ginx:active:".
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(l *RedisLimiter) {
+		l.prefix = prefix
+	}
+}
+
+// NewRedisLimiter 创建一个基于 Redis 租约的活跃请求数限流器.
+// maxActive: 最大活跃请求数; leaseTTL: 租约的有效期, 需要大于请求的最长处理时间.
+func NewRedisLimiter(client redis.Cmdable, maxActive int, leaseTTL time.Duration,
+	opts ...RedisOption) *RedisLimiter {
+	l := &RedisLimiter{
+		client:    client,
+		prefix:    "ginx:active:",
+		maxActive: maxActive,
+		leaseTTL:  leaseTTL,
+		timeFunc:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Limit 为 context 中的租约 id 申请租约. 限流时不会保存租约.
+func (l *RedisLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+// LimitDecision 与 Limit 相同, 同时返回额度信息.
+func (l *RedisLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+// LimitN 为 context 中的租约 id 申请占用 n 个名额的租约. 限流时不会保存租约.
+func (l *RedisLimiter) LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error) {
+	id, ok := LeaseIDFromContext(ctx)
+	if !ok {
+		return ratelimit.Decision{}, ErrLeaseIDNotFound
+	}
+	now := l.timeFunc()
+	res, err := acquireLeaseScript.Run(ctx, l.client, []string{l.prefix + key},
+		now.UnixMilli(), now.Add(l.leaseTTL).UnixMilli(), l.maxActive, id,
+		l.leaseTTL.Milliseconds(), n).Int64Slice()
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("redis 申请租约失败: %w", err)
+	}
+	if len(res) != 2 {
+		return ratelimit.Decision{}, fmt.Errorf("redis 申请租约失败: 无效的返回值 %v", res)
+	}
+	return ratelimit.Decision{
+		Limited:   res[0] == 1,
+		Limit:     l.maxActive,
+		Remaining: l.maxActive - int(res[1]),
+	}, nil
+}
+
+// Decr 释放 context 中租约 id 对应的租约. 租约不存在时不返回错误.
+func (l *RedisLimiter) Decr(ctx context.Context, key string) error {
+	return l.DecrN(ctx, key, 1)
+}
+
+// DecrN 释放 context 中租约 id 对应的占用 n 个名额的租约. 租约不存在时不返回错误.
+// ctx 已经取消时释放失败, 租约直到过期才被清理. Builder 使用不随请求取消的 context 调用.
+func (l *RedisLimiter) DecrN(ctx context.Context, key string, n int) error {
+	id, ok := LeaseIDFromContext(ctx)
+	if !ok {
+		return ErrLeaseIDNotFound
+	}
+	members := make([]any, 0, n)
+	members = append(members, id)
+	for i := 2; i <= n; i++ {
+		members = append(members, id+":"+strconv.Itoa(i))
+	}
+	if err := l.client.ZRem(ctx, l.prefix+key, members...).Err(); err != nil {
+		return fmt.Errorf("redis 释放租约失败: %w", err)
+	}
+	return nil
+}
+
+// Active 返回 key 当前未过期的租约数量.
+func (l *RedisLimiter) Active(ctx context.Context, key string) (int64, error) {
+	return l.client.ZCount(ctx, l.prefix+key,
+		fmt.Sprintf("(%d", l.timeFunc().UnixMilli()), "+inf").Result()
+}
@@ -0,0 +1,241 @@
+package activelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func newRedisClient(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func TestRedisLimiter(t *testing.T) {
+	_, client := newRedisClient(t)
+	now := time.UnixMilli(1695571200000)
+	l := NewRedisLimiter(client, 2, time.Minute, WithKeyPrefix("test:"))
+	l.timeFunc = func() time.Time { return now }
+	lease := func(id string) context.Context {
+		return ContextWithLeaseID(context.Background(), id)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		limited, err := l.Limit(lease(id), "key")
+		require.NoError(t, err)
+		assert.False(t, limited)
+	}
+	limited, err := l.Limit(lease("c"), "key")
+	require.NoError(t, err)
+	assert.True(t, limited)
+	// 限流时不保存租约, 释放不存在的租约不影响其他租约
+	require.NoError(t, l.Decr(lease("c"), "key"))
+	active, err := l.Active(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), active)
+
+	// 释放后可以申请
+	require.NoError(t, l.Decr(lease("a"), "key"))
+	limited, err = l.Limit(lease("c"), "key")
+	require.NoError(t, err)
+	assert.False(t, limited)
+
+	// 其他 key 不受影响
+	limited, err = l.Limit(lease("d"), "other")
+	require.NoError(t, err)
+	assert.False(t, limited)
+}
+
+func TestRedisLimiter_LimitDecision(t *testing.T) {
+	_, client := newRedisClient(t)
+	l := NewRedisLimiter(client, 2, time.Minute)
+	wants := []ratelimit.Decision{
+		{Limit: 2, Remaining: 1},
+		{Limit: 2, Remaining: 0},
+		{Limited: true, Limit: 2, Remaining: 0},
+	}
+	for i, want := range wants {
+		ctx := ContextWithLeaseID(context.Background(), strconv.Itoa(i))
+		d, err := l.LimitDecision(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, want, d, "step %d", i)
+	}
+}
+
+func TestRedisLimiter_LimitN(t *testing.T) {
+	mr, client := newRedisClient(t)
+	l := NewRedisLimiter(client, 5, time.Minute, WithKeyPrefix("test:"))
+	a := ContextWithLeaseID(context.Background(), "a")
+	b := ContextWithLeaseID(context.Background(), "b")
+
+	d, err := l.LimitN(a, "key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Decision{Limit: 5, Remaining: 2}, d)
+	d, err = l.LimitN(b, "key", 3)
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Decision{Limited: true, Limit: 5, Remaining: 2}, d)
+	// 超过上限时总是限流
+	d, err = l.LimitN(b, "other", 6)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+
+	members, err := mr.ZMembers("test:key")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "a:2", "a:3"}, members)
+
+	require.NoError(t, l.DecrN(a, "key", 3))
+	active, err := l.Active(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), active)
+}
+
+func TestRedisLimiter_CrashedHolder(t *testing.T) {
+	_, client := newRedisClient(t)
+	now := time.UnixMilli(1695571200000)
+	l := NewRedisLimiter(client, 1, 30*time.Second)
+	l.timeFunc = func() time.Time { return now }
+
+	// 持有者申请租约后崩溃, 没有调用 Decr
+	crashed := ContextWithLeaseID(context.Background(), "crashed")
+	limited, err := l.Limit(crashed, "key")
+	require.NoError(t, err)
+	require.False(t, limited)
+
+	next := ContextWithLeaseID(context.Background(), "next")
+	limited, err = l.Limit(next, "key")
+	require.NoError(t, err)
+	assert.True(t, limited)
+
+	// 租约过期后被自动清理
+	now = now.Add(31 * time.Second)
+	active, err := l.Active(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), active)
+	limited, err = l.Limit(next, "key")
+	require.NoError(t, err)
+	assert.False(t, limited)
+}
+
+func TestRedisLimiter_Error(t *testing.T) {
+	mr, client := newRedisClient(t)
+	l := NewRedisLimiter(client, 1, time.Minute)
+
+	_, err := l.Limit(context.Background(), "key")
+	assert.ErrorIs(t, err, ErrLeaseIDNotFound)
+	assert.ErrorIs(t, l.Decr(context.Background(), "key"), ErrLeaseIDNotFound)
+
+	mr.Close()
+	ctx := ContextWithLeaseID(context.Background(), "a")
+	_, err = l.Limit(ctx, "key")
+	assert.Error(t, err)
+	assert.Error(t, l.Decr(ctx, "key"))
+}
+
+func TestRedisLimiter_Builder(t *testing.T) {
+	_, client := newRedisClient(t)
+	l := NewRedisLimiter(client, 1, time.Minute)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server := gin.New()
+	server.Use(NewBuilder(l).Build())
+	server.GET("/slow", func(ctx *gin.Context) {
+		_, ok := LeaseIDFromContext(ctx.Request.Context())
+		assert.True(t, ok)
+		close(entered)
+		<-release
+		ctx.Status(http.StatusOK)
+	})
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	do := func(target string) int {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, do("/slow"))
+	}()
+	<-entered
+	assert.Equal(t, http.StatusTooManyRequests, do("/limit"))
+	close(release)
+	wg.Wait()
+
+	// 请求结束后释放租约
+	active, err := l.Active(context.Background(), "all_req_active_limiter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), active)
+	assert.Equal(t, http.StatusOK, do("/limit"))
+}
+
+func TestRedisLimiter_BuilderCanceled(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder) gin.HandlerFunc
+	}{
+		{name: "build", build: (*Builder).Build},
+		{name: "build_queued", build: func(b *Builder) gin.HandlerFunc {
+			return b.BuildQueued(QueueOpts{MaxQueue: 1})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newRedisClient(t)
+			l := NewRedisLimiter(client, 1, time.Minute)
+			server := gin.New()
+			server.Use(tt.build(NewBuilder(l)))
+			c, cancel := context.WithCancel(context.Background())
+			server.GET("/", func(ctx *gin.Context) {
+				// 客户端断开连接
+				cancel()
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(c)
+			server.ServeHTTP(httptest.NewRecorder(), req)
+
+			// 请求被取消后也释放租约
+			active, err := l.Active(context.Background(), "all_req_active_limiter")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), active)
+		})
+	}
+}
+
+func TestRedisLimiter_BuildShadow(t *testing.T) {
+	_, client := newRedisClient(t)
+	l := NewRedisLimiter(client, 10, time.Minute)
+	server := gin.New()
+	// 影子限流与现有的限流使用同一个 Redis 限流器与 key
+	server.Use(NewBuilder(l).Build(), NewBuilder(l).BuildShadow(ratelimit.ShadowOpts{Name: "new"}))
+	var active int64
+	server.GET("/", func(ctx *gin.Context) {
+		var err error
+		active, err = l.Active(context.Background(), "all_req_active_limiter")
+		require.NoError(t, err)
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	// 影子限流使用自己的租约
+	assert.Equal(t, int64(2), active)
+	active, err := l.Active(context.Background(), "all_req_active_limiter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), active)
+}
@@ -5,29 +5,89 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
 type Builder struct {
-	limiter Limiter
-	logger  *slog.Logger
+	limiter  Limiter
+	genKeyFn func(ctx *gin.Context) string
+	costFn   func(ctx *gin.Context) int
+	logger   *slog.Logger
+	failure  ratelimit.FailurePolicy
+	fallback Limiter
 }
 
 // NewBuilder 创建一个 Builder
+// genKeyFn: 默认全局限流.
 func NewBuilder(limiter Limiter) *Builder {
 	go limiter.Put()
 	return &Builder{
 		limiter: limiter,
-		logger:  slog.Default(),
+		genKeyFn: func(ctx *gin.Context) string {
+			return "all_req_bucket_limiter"
+		},
+		logger: slog.Default(),
 	}
 }
 
+func (b *Builder) SetKeyGenFunc(fn func(*gin.Context) string) *Builder {
+	b.genKeyFn = fn
+	return b
+}
+
+// SetCostFunc 设置请求的 cost, 即一个请求消耗多少枚令牌. 默认为 1, 小于 1 时按 1 计算.
+// cost 超过桶的容量的请求总是返回 429. Limiter 需要实现 CostLimiter, 否则返回 500.
+func (b *Builder) SetCostFunc(fn func(*gin.Context) int) *Builder {
+	b.costFn = fn
+	return b
+}
+
+// SetFailurePolicy 设置限流器出现错误时的处理方式, 默认返回 500.
+// 请求的 context 被取消或者超时与失败策略无关, 总是返回 504.
+func (b *Builder) SetFailurePolicy(policy ratelimit.FailurePolicy) *Builder {
+	b.failure = policy
+	return b
+}
+
+// SetFallbackLimiter 设置 ratelimit.FailFallback 使用的备用限流器.
+// Builder 不会启动备用限流器的 Put, 需要调用方启动.
+func (b *Builder) SetFallbackLimiter(limiter Limiter) *Builder {
+	b.fallback = limiter
+	return b
+}
+
 func (b *Builder) SetLogger(logger *slog.Logger) *Builder {
 	b.logger = logger
 	return b
 }
 
+// SetKeyGenFuncByIP 设置根据 IP 进行限流
+func (b *Builder) SetKeyGenFuncByIP() *Builder {
+	b.genKeyFn = func(ctx *gin.Context) string {
+		var b strings.Builder
+		key := "ip_bucket_limiter:"
+		ip := ctx.ClientIP()
+		b.Grow(len(key) + len(ip))
+		b.WriteString(key)
+		b.WriteString(ip)
+		return b.String()
+	}
+	return b
+}
+
+// SetKeyGenFuncByClientIP 设置根据 r 解析的客户端 IP 进行限流.
+// 与 SetKeyGenFuncByIP 不同, 只信任 r 中配置的代理, 并且 IPv6 地址按网段聚合.
+func (b *Builder) SetKeyGenFuncByClientIP(r *ratelimit.ClientIPResolver) *Builder {
+	b.genKeyFn = func(ctx *gin.Context) string {
+		return "ip_bucket_limiter:" + r.Key(ctx)
+	}
+	return b
+}
+
 func (b *Builder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		limited, err := b.limit(ctx)
@@ -51,7 +111,17 @@ func (b *Builder) Build() gin.HandlerFunc {
 }
 
 func (b *Builder) limit(ctx *gin.Context) (bool, error) {
-	return b.limiter.Limit(ctx.Request.Context(), "")
+	limited, _, err := b.failure.Limit(ctx, b.logger, b.limiter, b.fallback, b.genKeyFn(ctx), b.cost(ctx))
+	return limited, err
+}
+
+// BuildShadow 构建影子限流中间件, 只记录决策, 总是放行请求. 详见 ratelimit.ShadowOpts.
+// 与 BuildBlock 不同, 影子限流不会阻塞等待令牌.
+func (b *Builder) BuildShadow(opts ratelimit.ShadowOpts) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		_, _ = opts.Decide(ctx.Request.Context(), b.logger, b.limiter, b.genKeyFn(ctx), b.cost(ctx))
+		ctx.Next()
+	}
 }
 
 func (b *Builder) BuildBlock() gin.HandlerFunc {
@@ -77,5 +147,26 @@ func (b *Builder) BuildBlock() gin.HandlerFunc {
 }
 
 func (b *Builder) blockLimit(ctx *gin.Context) (bool, error) {
-	return b.limiter.BlockLimit(ctx.Request.Context(), "")
+	var fallback func() (bool, error)
+	if b.fallback != nil {
+		fallback = func() (bool, error) { return b.blockLimitWith(ctx, b.fallback) }
+	}
+	limited, _, err := b.failure.Do(ctx.Request.Context(), b.logger,
+		func() (bool, error) { return b.blockLimitWith(ctx, b.limiter) }, fallback)
+	return limited, err
+}
+
+func (b *Builder) blockLimitWith(ctx *gin.Context, limiter Limiter) (bool, error) {
+	key, cost := b.genKeyFn(ctx), b.cost(ctx)
+	if l, ok := limiter.(CostLimiter); ok {
+		return l.BlockLimitN(ctx.Request.Context(), key, cost)
+	}
+	if cost != 1 {
+		return false, ratelimit.ErrCostNotSupported
+	}
+	return limiter.BlockLimit(ctx.Request.Context(), key)
+}
+
+func (b *Builder) cost(ctx *gin.Context) int {
+	return ratelimit.Cost(ctx, b.costFn)
 }
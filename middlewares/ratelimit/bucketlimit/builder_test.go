@@ -11,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
 func TestBuilder_SetLogger(t *testing.T) {
@@ -35,6 +37,102 @@ func TestBuilder_SetLogger(t *testing.T) {
 	}
 }
 
+func TestBuilder_SetKeyGenFunc(t *testing.T) {
+	tests := []struct {
+		name       string
+		reqBuilder func(t *testing.T) *http.Request
+		fn         func(*gin.Context) string
+		want       string
+	}{
+		{
+			// 设置key成功
+			name: "set_key_success",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "", nil)
+				require.NoError(t, err)
+				req.RemoteAddr = "127.0.0.1:80"
+				return req
+			},
+			fn: func(ctx *gin.Context) string {
+				return "test"
+			},
+			want: "test",
+		},
+		{
+			// 默认key
+			name: "default_key",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "", nil)
+				require.NoError(t, err)
+				req.RemoteAddr = "127.0.0.1:80"
+				return req
+			},
+			want: "all_req_bucket_limiter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(&mockLimiter{})
+			if tt.fn != nil {
+				b.SetKeyGenFunc(tt.fn)
+			}
+
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = tt.reqBuilder(t)
+
+			assert.Equal(t, tt.want, b.genKeyFn(ctx))
+		})
+	}
+}
+
+func TestBuilder_SetKeyGenFuncByIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		reqBuilder func(t *testing.T) *http.Request
+		useIP      bool
+		want       string
+	}{
+		{
+			// 设置key成功
+			name: "set_key_success",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "", nil)
+				require.NoError(t, err)
+				req.RemoteAddr = "127.0.0.1:80"
+				return req
+			},
+			useIP: true,
+			want:  "ip_bucket_limiter:127.0.0.1",
+		},
+		{
+			// 默认key
+			name: "default_key",
+			reqBuilder: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, "", nil)
+				require.NoError(t, err)
+				req.RemoteAddr = "127.0.0.1:80"
+				return req
+			},
+			want: "all_req_bucket_limiter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(&mockLimiter{})
+			if tt.useIP {
+				b.SetKeyGenFuncByIP()
+			}
+
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = tt.reqBuilder(t)
+
+			assert.Equal(t, tt.want, b.genKeyFn(ctx))
+		})
+	}
+}
+
 func TestBuilder_Build(t *testing.T) {
 	const limitURL = "/limit"
 	mockLimiter := &mockLimiter{}
@@ -193,6 +291,69 @@ func TestBuilder_BuildBlock(t *testing.T) {
 	}
 }
 
+func TestBuilder_SetCostFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		limiter  Limiter
+		cost     int
+		block    bool
+		wantCode []int
+	}{
+		{
+			name:     "cost",
+			limiter:  newTokenBucketLimiter(t, 0.001, 10),
+			cost:     4,
+			wantCode: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			// 超过桶的容量时总是限流
+			name:     "exceed_capacity",
+			limiter:  newTokenBucketLimiter(t, 0.001, 10),
+			cost:     11,
+			wantCode: []int{http.StatusTooManyRequests},
+		},
+		{
+			name:     "block_exceed_capacity",
+			limiter:  newTokenBucketLimiter(t, 0.001, 10),
+			cost:     11,
+			block:    true,
+			wantCode: []int{http.StatusTooManyRequests},
+		},
+		{
+			name:     "not_supported",
+			limiter:  &mockLimiter{},
+			cost:     2,
+			wantCode: []int{http.StatusInternalServerError},
+		},
+		{
+			name:     "block_not_supported",
+			limiter:  &mockLimiter{},
+			cost:     2,
+			block:    true,
+			wantCode: []int{http.StatusInternalServerError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuilder(tt.limiter).SetCostFunc(func(*gin.Context) int {
+				return tt.cost
+			})
+			server := gin.New()
+			if tt.block {
+				server.Use(b.BuildBlock())
+			} else {
+				server.Use(b.Build())
+			}
+			b.RegisterRoutes(server)
+			for i, want := range tt.wantCode {
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+				assert.Equal(t, want, recorder.Code, "request %d", i)
+			}
+		})
+	}
+}
+
 func (b *Builder) RegisterRoutes(server *gin.Engine) {
 	server.GET("/limit", func(ctx *gin.Context) {
 		ctx.Status(http.StatusOK)
@@ -217,3 +378,75 @@ func (l *mockLimiter) Limit(_ context.Context, _ string) (bool, error) {
 func (l *mockLimiter) BlockLimit(_ context.Context, _ string) (bool, error) {
 	return l.blockLimited, l.blockLimitErr
 }
+
+func TestBuilder_SetFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     ratelimit.FailureMode
+		block    bool
+		wantCode []int
+	}{
+		{name: "fail_closed", mode: ratelimit.FailClosed, wantCode: []int{http.StatusInternalServerError}},
+		{name: "fail_open", mode: ratelimit.FailOpen, wantCode: []int{http.StatusOK, http.StatusOK}},
+		{
+			name:     "fallback",
+			mode:     ratelimit.FailFallback,
+			wantCode: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "block_fallback",
+			mode:     ratelimit.FailFallback,
+			block:    true,
+			wantCode: []int{http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := newTokenBucketLimiter(t, 0.001, 1)
+			limiter := &mockLimiter{limitErr: errors.New("mock error"), blockLimitErr: errors.New("mock error")}
+			b := NewBuilder(limiter).
+				SetFailurePolicy(ratelimit.FailurePolicy{Mode: tt.mode}).
+				SetFallbackLimiter(fallback)
+			server := gin.New()
+			if tt.block {
+				server.Use(b.BuildBlock())
+			} else {
+				server.Use(b.Build())
+			}
+			server.GET("/limit", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			for i, want := range tt.wantCode {
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+				assert.Equal(t, want, recorder.Code, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestBuilder_BuildShadow(t *testing.T) {
+	server := gin.New()
+	server.Use(NewBuilder(newTokenBucketLimiter(t, 0.001, 1)).BuildShadow(ratelimit.ShadowOpts{Name: "new"}))
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+		// 不拦截请求, 不设置响应头
+		assert.Equal(t, http.StatusOK, recorder.Code, "request %d", i)
+		assert.Empty(t, recorder.Header().Get(ratelimit.HeaderLimit), "request %d", i)
+	}
+}
+
+func TestBuilder_SetKeyGenFuncByClientIP(t *testing.T) {
+	r, err := ratelimit.NewClientIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	b := NewBuilder(&mockLimiter{}).SetKeyGenFuncByClientIP(r)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.RemoteAddr = "10.0.0.1:1234"
+	ctx.Request.Header.Set("X-Forwarded-For", "2001:db8:1:2:3:4:5:6")
+	assert.Equal(t, "ip_bucket_limiter:2001:db8:1:2::/64", b.genKeyFn(ctx))
+}
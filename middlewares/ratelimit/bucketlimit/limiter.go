@@ -1,6 +1,10 @@
 package activelimit
 
-import "context"
+import (
+	"context"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
 
 // Limiter 桶限流
 type Limiter interface {
@@ -11,12 +15,37 @@ type Limiter interface {
 	Close()
 
 	// Limit 有没有触发限流。
+	// key 为限流的对象, 不区分对象的实现可以忽略
 	// bool 代表是否限流, true 就是要限流, 若 Context.Err() != nil 也会返回 error
 	// error 当调用了 Close() 时返回错误
-	Limit(ctx context.Context, _ string) (bool, error)
+	Limit(ctx context.Context, key string) (bool, error)
 
 	// BlockLimit 限流时阻塞直到超时。
+	// key 为限流的对象, 不区分对象的实现可以忽略
 	// bool 代表是否限流, true 就是要限流, 同时会返回 Context.Err()
 	// error 当调用了 Close() 时返回错误
-	BlockLimit(ctx context.Context, _ string) (bool, error)
+	BlockLimit(ctx context.Context, key string) (bool, error)
+}
+
+// DecisionLimiter 可选的 Limiter 接口, 返回限流的额度信息.
+// Builder 的 Build 使用实现了该接口的 Limiter 时, 会设置 RateLimit-* 响应头, 限流时设置 Retry-After.
+type DecisionLimiter interface {
+	Limiter
+
+	// LimitDecision 与 Limit 相同, 同时返回额度信息.
+	LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error)
+}
+
+// CostLimiter 可选的 Limiter 接口, 支持一个请求消耗多枚令牌.
+// Builder 通过 SetCostFunc 设置了请求的 cost 时, Limiter 需要实现该接口.
+type CostLimiter interface {
+	DecisionLimiter
+
+	// LimitN 与 LimitDecision 相同, 不限流时原子地消耗 n 枚令牌.
+	// n 超过桶的容量时总是限流.
+	LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error)
+
+	// BlockLimitN 与 BlockLimit 相同, 等待直到得到 n 枚令牌.
+	// n 超过桶的容量时总是限流, 不会等待.
+	BlockLimitN(ctx context.Context, key string, n int) (bool, error)
 }
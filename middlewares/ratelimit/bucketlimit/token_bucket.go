@@ -0,0 +1,225 @@
+package activelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+// ErrLimiterClosed 限流器已关闭.
+var ErrLimiterClosed = errors.New("限流器已关闭")
+
+const shardCount = 32
+
+var _ CostLimiter = (*TokenBucketLimiter)(nil)
+
+// TokenBucketLimiter 基于内存的令牌桶限流器. 每个 key 使用独立的令牌桶.
+// 令牌在请求时按经过的时间补充, 不需要定时放置令牌的 goroutine, 因此 Put 直接返回.
+// 令牌桶装满后与新建的令牌桶相同, 空闲超过装满所需时间的令牌桶会被清理. 并发安全.
+type TokenBucketLimiter struct {
+	// rate 每秒补充的令牌数.
+	rate float64
+	// burst 桶的容量, 也就是允许的突发请求数.
+	burst int
+
+	// fillTime 空桶装满所需的时间.
+	fillTime time.Duration
+
+	seed   maphash.Seed
+	shards [shardCount]*bucketShard
+	// lastSweep 上一次清理的时间 (Unix 纳秒).
+	lastSweep atomic.Int64
+	closed    atomic.Bool
+
+	timeFunc func() time.Time
+}
+
+type bucketShard struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	// tokens 当前的令牌数. BlockLimit 预留令牌时可以为负数.
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter 创建一个令牌桶限流器.
+// rate: 每秒补充的令牌数; burst: 桶的容量, 新建的令牌桶是满的. rate 与 burst 需要大于 0.
+func NewTokenBucketLimiter(rate float64, burst int) (*TokenBucketLimiter, error) {
+	if !(rate > 0) {
+		return nil, fmt.Errorf("bucketlimit: 无效的令牌补充速率 %v", rate)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("bucketlimit: 无效的令牌桶容量 %d", burst)
+	}
+	l := &TokenBucketLimiter{
+		rate:     rate,
+		burst:    burst,
+		fillTime: time.Duration(float64(burst) / rate * float64(time.Second)),
+		seed:     maphash.MakeSeed(),
+		timeFunc: time.Now,
+	}
+	for i := range l.shards {
+		l.shards[i] = &bucketShard{buckets: make(map[string]*tokenBucket)}
+	}
+	return l, nil
+}
+
+// Put 令牌在请求时补充, 该方法直接返回.
+func (l *TokenBucketLimiter) Put() {}
+
+// Close 关闭限流器. 关闭后 Limit 与 BlockLimit 返回 ErrLimiterClosed.
+func (l *TokenBucketLimiter) Close() {
+	l.closed.Store(true)
+}
+
+func (l *TokenBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+// LimitDecision 判断是否限流并返回额度信息.
+// 额度为桶的容量, 窗口为空桶装满所需的时间.
+func (l *TokenBucketLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+func (l *TokenBucketLimiter) LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return ratelimit.Decision{Limited: true}, err
+	}
+	if l.closed.Load() {
+		return ratelimit.Decision{Limited: true}, ErrLimiterClosed
+	}
+	now := l.timeFunc()
+	l.sweep(now)
+	s := l.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := l.bucket(s, key, now)
+	d := ratelimit.Decision{Limit: l.burst, Window: l.fillTime}
+	switch cost := float64(n); {
+	case n > l.burst:
+		d.Limited = true
+	case b.tokens < cost:
+		d.Limited = true
+		d.RetryAfter = l.fillDuration(cost - b.tokens)
+	default:
+		b.tokens -= cost
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.fillDuration(float64(l.burst) - b.tokens)
+	return d, nil
+}
+
+func (l *TokenBucketLimiter) BlockLimit(ctx context.Context, key string) (bool, error) {
+	return l.BlockLimitN(ctx, key, 1)
+}
+
+// BlockLimitN 令牌不足时预留 n 枚令牌并等待到令牌补充.
+// 在 ctx 的截止时间之前无法得到令牌时立即返回 context.DeadlineExceeded.
+func (l *TokenBucketLimiter) BlockLimitN(ctx context.Context, key string, n int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+	if l.closed.Load() {
+		return true, ErrLimiterClosed
+	}
+	if n > l.burst {
+		return true, nil
+	}
+	cost := float64(n)
+	now := l.timeFunc()
+	l.sweep(now)
+	s := l.shard(key)
+	s.mu.Lock()
+	b := l.bucket(s, key, now)
+	if b.tokens >= cost {
+		b.tokens -= cost
+		s.mu.Unlock()
+		return false, nil
+	}
+	wait := l.fillDuration(cost - b.tokens)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(wait)) {
+		s.mu.Unlock()
+		return true, context.DeadlineExceeded
+	}
+	b.tokens -= cost
+	s.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		// 归还预留的令牌
+		s.mu.Lock()
+		b := l.bucket(s, key, l.timeFunc())
+		b.tokens = min(float64(l.burst), b.tokens+cost)
+		s.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
+
+// Len 返回当前保存的令牌桶数量.
+func (l *TokenBucketLimiter) Len() int {
+	var n int
+	for _, s := range l.shards {
+		s.mu.Lock()
+		n += len(s.buckets)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// fillDuration 返回补充 tokens 个令牌所需的时间.
+func (l *TokenBucketLimiter) fillDuration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// shard 返回 key 所在的分片.
+func (l *TokenBucketLimiter) shard(key string) *bucketShard {
+	return l.shards[maphash.String(l.seed, key)%shardCount]
+}
+
+// bucket 获取 key 的令牌桶并补充令牌. 需要持有 s 的锁.
+func (l *TokenBucketLimiter) bucket(s *bucketShard, key string, now time.Time) *tokenBucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		s.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(l.burst), b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// sweep 清理已经装满的令牌桶. 最多每秒清理一次, 每次只锁定一个分片.
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	last := l.lastSweep.Load()
+	if now.UnixNano()-last < int64(max(l.fillTime, time.Second)) ||
+		!l.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	for _, s := range l.shards {
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if b.tokens >= 0 && now.Sub(b.last) >= l.fillTime {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,362 @@
+package activelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func newTokenBucketLimiter(t *testing.T, rate float64, burst int) *TokenBucketLimiter {
+	l, err := NewTokenBucketLimiter(rate, burst)
+	require.NoError(t, err)
+	return l
+}
+
+func TestNewTokenBucketLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		wantErr bool
+	}{
+		{name: "valid", rate: 0.5, burst: 1},
+		{name: "zero_rate", rate: 0, burst: 1, wantErr: true},
+		{name: "negative_rate", rate: -1, burst: 1, wantErr: true},
+		{name: "nan_rate", rate: math.NaN(), burst: 1, wantErr: true},
+		{name: "zero_burst", rate: 1, burst: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewTokenBucketLimiter(tt.rate, tt.burst)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, l)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, l)
+		})
+	}
+}
+
+func TestTokenBucketLimiter_Limit(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{
+			name:  "burst",
+			rate:  1,
+			burst: 2,
+			steps: []step{
+				{key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+				// 其他 key 不受影响
+				{key: "b"},
+				// 补充一个令牌
+				{advance: time.Second, key: "a"},
+				{key: "a", want: true},
+				// 最多补充到 burst
+				{advance: 10 * time.Second, key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+			},
+		},
+		{
+			// 不足一个令牌时累积
+			name:  "fractional_refill",
+			rate:  10,
+			burst: 1,
+			steps: []step{
+				{key: "a"},
+				{advance: 50 * time.Millisecond, key: "a", want: true},
+				{advance: 50 * time.Millisecond, key: "a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1695571200, 0)
+			l := newTokenBucketLimiter(t, tt.rate, tt.burst)
+			l.timeFunc = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				limited, err := l.Limit(context.Background(), s.key)
+				require.NoError(t, err)
+				assert.Equal(t, s.want, limited, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestTokenBucketLimiter_BlockLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    bool
+		wantErr error
+	}{
+		{
+			// 等待补充令牌
+			name:    "wait",
+			timeout: time.Second,
+		},
+		{
+			// 截止时间之前无法得到令牌
+			name:    "deadline_too_short",
+			timeout: 10 * time.Millisecond,
+			want:    true,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTokenBucketLimiter(t, 20, 1)
+			limited, err := l.Limit(context.Background(), "a")
+			require.NoError(t, err)
+			require.False(t, limited)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			limited, err = l.BlockLimit(ctx, "a")
+			assert.Equal(t, tt.want, limited)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		l := newTokenBucketLimiter(t, 1, 1)
+		_, err := l.Limit(context.Background(), "a")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		limited, err := l.BlockLimit(ctx, "a")
+		assert.True(t, limited)
+		assert.ErrorIs(t, err, context.Canceled)
+		// 归还预留的令牌
+		s := l.shard("a")
+		s.mu.Lock()
+		assert.Greater(t, s.buckets["a"].tokens, -0.5)
+		s.mu.Unlock()
+	})
+}
+
+func TestTokenBucketLimiter_LimitN(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	// 每秒补充 10 枚令牌, 桶内最多 10 枚令牌
+	l := newTokenBucketLimiter(t, 10, 10)
+	l.timeFunc = func() time.Time { return now }
+	steps := []struct {
+		advance time.Duration
+		n       int
+		want    ratelimit.Decision
+	}{
+		{n: 6, want: ratelimit.Decision{Limit: 10, Remaining: 4, Window: time.Second, Reset: 600 * time.Millisecond}},
+		{
+			// 还需要 2 枚令牌
+			n: 6,
+			want: ratelimit.Decision{Limited: true, Limit: 10, Remaining: 4, Window: time.Second,
+				Reset: 600 * time.Millisecond, RetryAfter: 200 * time.Millisecond},
+		},
+		{
+			advance: 200 * time.Millisecond,
+			n:       6,
+			want:    ratelimit.Decision{Limit: 10, Remaining: 0, Window: time.Second, Reset: time.Second},
+		},
+		{
+			// 超过桶的容量
+			advance: time.Second,
+			n:       11,
+			want:    ratelimit.Decision{Limited: true, Limit: 10, Remaining: 10, Window: time.Second},
+		},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		d, err := l.LimitN(context.Background(), "key", s.n)
+		require.NoError(t, err)
+		assert.Equal(t, s.want, d, "step %d", i)
+	}
+}
+
+func TestTokenBucketLimiter_BlockLimitN(t *testing.T) {
+	l := newTokenBucketLimiter(t, 100, 5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// 超过桶的容量时不等待
+	limited, err := l.BlockLimitN(ctx, "a", 6)
+	require.NoError(t, err)
+	assert.True(t, limited)
+
+	limited, err = l.BlockLimitN(ctx, "a", 5)
+	require.NoError(t, err)
+	assert.False(t, limited)
+
+	// 等待补充 3 枚令牌
+	start := time.Now()
+	limited, err = l.BlockLimitN(ctx, "a", 3)
+	require.NoError(t, err)
+	assert.False(t, limited)
+	assert.GreaterOrEqual(t, time.Since(start), 25*time.Millisecond)
+}
+
+func TestTokenBucketLimiter_Close(t *testing.T) {
+	l := newTokenBucketLimiter(t, 1, 1)
+	l.Close()
+	_, err := l.Limit(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrLimiterClosed)
+	_, err = l.BlockLimit(context.Background(), "a")
+	assert.ErrorIs(t, err, ErrLimiterClosed)
+}
+
+func TestTokenBucketLimiter_Sweep(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	l := newTokenBucketLimiter(t, 10, 10)
+	l.timeFunc = func() time.Time { return now }
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		_, err := l.Limit(ctx, strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 100, l.Len())
+
+	// 装满后的令牌桶被清理
+	now = now.Add(time.Second)
+	_, err := l.Limit(ctx, "active")
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestTokenBucketLimiter_Concurrent(t *testing.T) {
+	l := newTokenBucketLimiter(t, 0.001, 100)
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				limited, err := l.Limit(context.Background(), "key")
+				assert.NoError(t, err)
+				if !limited {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), allowed.Load())
+}
+
+func TestTokenBucketLimiter_LimitDecision(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	// 每秒补充 2 个令牌, 空桶 1s 装满
+	l := newTokenBucketLimiter(t, 2, 2)
+	l.timeFunc = func() time.Time { return now }
+	steps := []struct {
+		advance time.Duration
+		want    ratelimit.Decision
+	}{
+		{want: ratelimit.Decision{Limit: 2, Remaining: 1, Window: time.Second, Reset: 500 * time.Millisecond}},
+		{want: ratelimit.Decision{Limit: 2, Remaining: 0, Window: time.Second, Reset: time.Second}},
+		{
+			advance: 250 * time.Millisecond,
+			want: ratelimit.Decision{Limited: true, Limit: 2, Remaining: 0, Window: time.Second,
+				Reset: 750 * time.Millisecond, RetryAfter: 250 * time.Millisecond},
+		},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		d, err := l.LimitDecision(context.Background(), "key")
+		require.NoError(t, err)
+		assert.Equal(t, s.want, d, "step %d", i)
+	}
+}
+
+func TestTokenBucketLimiter_Builder(t *testing.T) {
+	l := newTokenBucketLimiter(t, 0.001, 1)
+	now := time.Unix(1695571200, 0)
+	l.timeFunc = func() time.Time { return now }
+	server := gin.New()
+	server.Use(NewBuilder(l).SetKeyGenFuncByIP().Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	tests := []struct {
+		name       string
+		ip         string
+		wantCode   int
+		wantHeader http.Header
+	}{
+		{
+			name:     "first",
+			ip:       "10.0.0.1",
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Ratelimit-Limit":     {"1"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"1000"},
+				"Ratelimit-Policy":    {"1;w=1000"},
+			},
+		},
+		{
+			name:     "limited",
+			ip:       "10.0.0.1",
+			wantCode: http.StatusTooManyRequests,
+			wantHeader: http.Header{
+				"Ratelimit-Limit":     {"1"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"1000"},
+				"Ratelimit-Policy":    {"1;w=1000"},
+				"Retry-After":         {"1000"},
+			},
+		},
+		// 每个 IP 使用独立的令牌桶
+		{
+			name:     "other_ip",
+			ip:       "10.0.0.2",
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Ratelimit-Limit":     {"1"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"1000"},
+				"Ratelimit-Policy":    {"1;w=1000"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+			require.NoError(t, err)
+			req.RemoteAddr = tt.ip + ":80"
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantHeader, recorder.Header())
+		})
+	}
+}
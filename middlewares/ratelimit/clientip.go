@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 解析客户端 IP 的请求头.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ClientIPResolver 根据可信代理解析客户端 IP.
+// 与 gin.Context.ClientIP 不同, 只信任显式配置的代理网段, 不依赖 gin.Engine 的全局设置,
+// 并且可以把 IPv6 地址聚合为网段, 避免一个拥有 /64 网段的客户端得到无数个 key.
+// 并发安全.
+type ClientIPResolver struct {
+	trusted    []netip.Prefix
+	headers    []string
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+// ClientIPOption 定义 ClientIPResolver 的选项.
+type ClientIPOption func(*ClientIPResolver)
+
+// WithIPHeaders 设置解析客户端 IP 的请求头, 按顺序使用第一个存在的请求头. 默认只使用 X-Forwarded-For.
+// 只应该包含可信代理会覆盖或追加的请求头: 代理不处理的请求头由客户端任意设置,
+// 排在前面时会被优先使用, 客户端可以借此伪造地址.
+func WithIPHeaders(headers ...string) ClientIPOption {
+	return func(r *ClientIPResolver) {
+		r.headers = headers
+	}
+}
+
+// WithIPv4PrefixLen 设置 Key 聚合 IPv4 地址的前缀长度. 默认为 32, 即不聚合.
+func WithIPv4PrefixLen(bits int) ClientIPOption {
+	return func(r *ClientIPResolver) {
+		r.ipv4Prefix = bits
+	}
+}
+
+// WithIPv6PrefixLen 设置 Key 聚合 IPv6 地址的前缀长度. 默认为 64.
+func WithIPv6PrefixLen(bits int) ClientIPOption {
+	return func(r *ClientIPResolver) {
+		r.ipv6Prefix = bits
+	}
+}
+
+// NewClientIPResolver 创建一个 ClientIPResolver.
+// trustedProxies: 可信代理的 CIDR 或者 IP, 例如 "10.0.0.0/8"、"::1". 为空时不信任任何请求头.
+func NewClientIPResolver(trustedProxies []string, opts ...ClientIPOption) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{
+		trusted:    make([]netip.Prefix, 0, len(trustedProxies)),
+		headers:    []string{HeaderXForwardedFor},
+		ipv4Prefix: 32,
+		ipv6Prefix: 64,
+	}
+	for _, s := range trustedProxies {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, p)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.ipv4Prefix < 0 || r.ipv4Prefix > 32 {
+		return nil, fmt.Errorf("ratelimit: 无效的 IPv4 前缀长度 %d", r.ipv4Prefix)
+	}
+	if r.ipv6Prefix < 0 || r.ipv6Prefix > 128 {
+		return nil, fmt.Errorf("ratelimit: 无效的 IPv6 前缀长度 %d", r.ipv6Prefix)
+	}
+	return r, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("ratelimit: 无效的可信代理 %q: %w", s, err)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("ratelimit: 无效的可信代理 %q: %w", s, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ClientIP 返回客户端 IP.
+// 直接连接的地址不是可信代理时返回该地址; 否则从右到左遍历请求头中的地址,
+// 返回第一个不是可信代理的地址. 遇到无法解析的地址时返回它右边的地址.
+// RemoteAddr 无法解析时返回无效的 netip.Addr.
+func (r *ClientIPResolver) ClientIP(ctx *gin.Context) netip.Addr {
+	remote, ok := parseAddr(ctx.Request.RemoteAddr)
+	if !ok || !r.isTrusted(remote) {
+		return remote
+	}
+	for _, h := range r.headers {
+		chain, ok := r.chain(ctx, h)
+		if !ok {
+			continue
+		}
+		client := remote
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, ok := parseAddr(chain[i])
+			if !ok {
+				return client
+			}
+			client = addr
+			if !r.isTrusted(addr) {
+				break
+			}
+		}
+		return client
+	}
+	return remote
+}
+
+// Key 返回聚合后的客户端地址, IPv6 地址按前缀长度聚合为网段, 例如 "2001:db8:1:2::/64".
+// 前缀长度等于地址长度时返回地址本身. 无法解析时返回空字符串.
+func (r *ClientIPResolver) Key(ctx *gin.Context) string {
+	addr := r.ClientIP(ctx)
+	if !addr.IsValid() {
+		return ""
+	}
+	bits := r.ipv6Prefix
+	if addr.Is4() {
+		bits = r.ipv4Prefix
+	}
+	if bits == addr.BitLen() {
+		return addr.String()
+	}
+	p, _ := addr.Prefix(bits)
+	return p.String()
+}
+
+func (r *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// chain 返回请求头中从客户端到最近的代理依次经过的地址. 请求头不存在时返回 false.
+func (r *ClientIPResolver) chain(ctx *gin.Context, header string) ([]string, bool) {
+	values := ctx.Request.Header.Values(header)
+	if len(values) == 0 {
+		return nil, false
+	}
+	var chain []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			if strings.EqualFold(header, HeaderForwarded) {
+				chain = append(chain, forwardedFor(elem))
+				continue
+			}
+			chain = append(chain, strings.TrimSpace(elem))
+		}
+	}
+	return chain, true
+}
+
+// forwardedFor 返回 Forwarded 请求头中一个元素的 for 参数, 没有时返回空字符串.
+// 详见 https://datatracker.ietf.org/doc/html/rfc7239#section-4
+func forwardedFor(elem string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(k, "for") {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+// parseAddr 解析可能带有端口或者方括号的地址. IPv4-mapped IPv6 地址转换为 IPv4 地址.
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	// 去掉 IPv6 的 zone, 避免同一个地址得到不同的 key
+	return addr.Unmap().WithZone(""), true
+}
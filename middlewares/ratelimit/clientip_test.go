@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClientIPResolver(t *testing.T) {
+	_, err := NewClientIPResolver([]string{"10.0.0.0/8", "::1", "192.168.1.1"})
+	assert.NoError(t, err)
+	_, err = NewClientIPResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = NewClientIPResolver([]string{"localhost"})
+	assert.Error(t, err)
+	_, err = NewClientIPResolver(nil, WithIPv6PrefixLen(129))
+	assert.Error(t, err)
+	_, err = NewClientIPResolver(nil, WithIPv4PrefixLen(-1))
+	assert.Error(t, err)
+}
+
+func TestClientIPResolver_ClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []ClientIPOption
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{
+			// 直接连接的地址不是可信代理时不信任请求头
+			name:       "untrusted_remote",
+			remoteAddr: "203.0.113.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"192.0.2.1"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "no_header",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x_forwarded_for",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"192.0.2.1, 10.0.0.2"}},
+			want:       "192.0.2.1",
+		},
+		{
+			// 伪造的地址在客户端的左边, 不会被使用
+			name:       "x_forwarded_for_spoofed",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"1.1.1.1, 192.0.2.1", "10.0.0.2"}},
+			want:       "192.0.2.1",
+		},
+		{
+			// 全部都是可信代理时使用最左边的地址
+			name:       "all_trusted",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			// 无法解析时使用它右边的地址
+			name:       "invalid",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"unknown, 10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			// 默认不使用 Forwarded 与 X-Real-IP, 代理没有处理时由客户端任意设置
+			name:       "default_headers",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {"for=1.1.1.1"},
+				"X-Real-Ip":       {"1.1.1.2"},
+				"X-Forwarded-For": {"192.0.2.1"},
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name:       "forwarded",
+			opts:       []ClientIPOption{WithIPHeaders(HeaderForwarded)},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{"Forwarded": {
+				`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711"`,
+			}},
+			want: "2001:db8:cafe::17",
+		},
+		{
+			// 按顺序使用第一个存在的请求头
+			name:       "forwarded_first",
+			opts:       []ClientIPOption{WithIPHeaders(HeaderForwarded, HeaderXForwardedFor)},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {"for=192.0.2.60"},
+				"X-Forwarded-For": {"192.0.2.1"},
+			},
+			want: "192.0.2.60",
+		},
+		{
+			name:       "x_real_ip",
+			opts:       []ClientIPOption{WithIPHeaders(HeaderXRealIP)},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Real-Ip": {"192.0.2.1"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "custom_headers",
+			opts:       []ClientIPOption{WithIPHeaders("CF-Connecting-IP")},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Cf-Connecting-Ip": {"192.0.2.1"},
+				"X-Forwarded-For":  {"192.0.2.2"},
+			},
+			want: "192.0.2.1",
+		},
+		{
+			name:       "ipv4_mapped",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			header:     http.Header{"X-Forwarded-For": {"::ffff:192.0.2.1"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "invalid_remote",
+			remoteAddr: "pipe",
+			want:       "invalid IP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewClientIPResolver([]string{"10.0.0.0/8"}, tt.opts...)
+			require.NoError(t, err)
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx.Request.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				ctx.Request.Header[k] = v
+			}
+			assert.Equal(t, tt.want, r.ClientIP(ctx).String())
+		})
+	}
+}
+
+func TestClientIPResolver_Key(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []ClientIPOption
+		remoteAddr string
+		want       string
+	}{
+		{name: "ipv4", remoteAddr: "192.0.2.1:80", want: "192.0.2.1"},
+		{name: "ipv6", remoteAddr: "[2001:db8:1:2:3:4:5:6]:80", want: "2001:db8:1:2::/64"},
+		{
+			name:       "ipv6_48",
+			opts:       []ClientIPOption{WithIPv6PrefixLen(48)},
+			remoteAddr: "[2001:db8:1:2:3:4:5:6]:80",
+			want:       "2001:db8:1::/48",
+		},
+		{
+			name:       "ipv6_128",
+			opts:       []ClientIPOption{WithIPv6PrefixLen(128)},
+			remoteAddr: "[2001:db8:1:2:3:4:5:6]:80",
+			want:       "2001:db8:1:2:3:4:5:6",
+		},
+		{
+			name:       "ipv4_24",
+			opts:       []ClientIPOption{WithIPv4PrefixLen(24)},
+			remoteAddr: "192.0.2.1:80",
+			want:       "192.0.2.0/24",
+		},
+		{name: "invalid", remoteAddr: "pipe", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewClientIPResolver(nil, tt.opts...)
+			require.NoError(t, err)
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx.Request.RemoteAddr = tt.remoteAddr
+			assert.Equal(t, tt.want, r.Key(ctx))
+		})
+	}
+}
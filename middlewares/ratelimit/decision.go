@@ -0,0 +1,93 @@
+// Package ratelimit 定义各个限流中间件共用的限流决策、响应头、失败策略、影子限流与限流 key.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流相关的响应头.
+// 详见 https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+)
+
+// ErrCostNotSupported 设置了请求的 cost, 但是 Limiter 不支持.
+var ErrCostNotSupported = errors.New("限流器不支持 cost")
+
+// Decision 定义一次限流的决策以及额度信息.
+type Decision struct {
+	// Limited 是否限流.
+	Limited bool
+
+	// Limit 额度, 例如窗口内允许的请求数、桶的容量或最大活跃请求数.
+	// 为 0 时表示不限制.
+	Limit int
+
+	// Remaining 剩余的额度.
+	Remaining int
+
+	// Window 额度的时间窗口. 为 0 时表示额度与时间无关, 例如活跃请求数.
+	Window time.Duration
+
+	// Reset 距离额度完全恢复的时间. Window 为 0 时忽略.
+	Reset time.Duration
+
+	// RetryAfter 限流时距离可以重试的时间.
+	RetryAfter time.Duration
+
+	// Name 组合了多个限制时, 触发限流或者剩余额度最少的限制的名称.
+	Name string
+}
+
+// DecisionKey 限流决策保存在 gin.Context 中的 key.
+const DecisionKey = "ginx/ratelimit_decision"
+
+// GetDecision 获取 SetHeaders 保存在 gin.Context 中的限流决策.
+// 例如在限流中间件之前的日志中间件中记录触发限流的限制.
+func GetDecision(c *gin.Context) (Decision, bool) {
+	v, ok := c.Get(DecisionKey)
+	if !ok {
+		return Decision{}, false
+	}
+	d, ok := v.(Decision)
+	return d, ok
+}
+
+// SetHeaders 设置 RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset 与 RateLimit-Policy 响应头.
+// 限流时同时设置 Retry-After, 最少为 1 秒. Limit 为 0 时不设置.
+// 同时把 d 保存到 gin.Context 中, 可以通过 GetDecision 获取.
+func SetHeaders(c *gin.Context, d Decision) {
+	c.Set(DecisionKey, d)
+	if d.Limit <= 0 {
+		return
+	}
+	limit := strconv.Itoa(d.Limit)
+	c.Header(HeaderLimit, limit)
+	c.Header(HeaderRemaining, strconv.Itoa(max(d.Remaining, 0)))
+	if d.Window > 0 {
+		c.Header(HeaderReset, strconv.FormatInt(seconds(d.Reset), 10))
+		c.Header(HeaderPolicy, limit+";w="+strconv.FormatInt(seconds(d.Window), 10))
+	} else {
+		c.Header(HeaderPolicy, limit)
+	}
+	if d.Limited {
+		c.Header(HeaderRetryAfter, strconv.FormatInt(max(seconds(d.RetryAfter), 1), 10))
+	}
+}
+
+// seconds 把时间向上取整为秒.
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision Decision
+		want     http.Header
+	}{
+		{
+			name: "allowed",
+			decision: Decision{
+				Limit:     100,
+				Remaining: 99,
+				Window:    time.Minute,
+				Reset:     1500 * time.Millisecond,
+			},
+			want: http.Header{
+				"Ratelimit-Limit":     {"100"},
+				"Ratelimit-Remaining": {"99"},
+				"Ratelimit-Reset":     {"2"},
+				"Ratelimit-Policy":    {"100;w=60"},
+			},
+		},
+		{
+			name: "limited",
+			decision: Decision{
+				Limited:    true,
+				Limit:      100,
+				Window:     time.Minute,
+				Reset:      time.Minute,
+				RetryAfter: 2100 * time.Millisecond,
+			},
+			want: http.Header{
+				"Ratelimit-Limit":     {"100"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"60"},
+				"Ratelimit-Policy":    {"100;w=60"},
+				"Retry-After":         {"3"},
+			},
+		},
+		{
+			// 与时间无关的额度, 例如活跃请求数
+			name: "limited_without_window",
+			decision: Decision{
+				Limited:   true,
+				Limit:     10,
+				Remaining: -1,
+			},
+			want: http.Header{
+				"Ratelimit-Limit":     {"10"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Policy":    {"10"},
+				"Retry-After":         {"1"},
+			},
+		},
+		{
+			name:     "unlimited",
+			decision: Decision{Remaining: -1},
+			want:     http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			SetHeaders(c, tt.decision)
+			assert.Equal(t, tt.want, recorder.Header())
+			d, ok := GetDecision(c)
+			assert.True(t, ok)
+			assert.Equal(t, tt.decision, d)
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FailureMode 定义限流器出现错误 (例如 Redis 不可用) 时的处理方式.
+type FailureMode int
+
+const (
+	// FailClosed 返回错误, 由中间件响应 500. 默认的处理方式.
+	FailClosed FailureMode = iota
+	// FailOpen 放行请求.
+	FailOpen
+	// FailFallback 使用备用的限流器, 一般为按实例数缩小阈值的本地限流器.
+	// 没有设置备用的限流器时与 FailClosed 相同.
+	FailFallback
+)
+
+func (m FailureMode) String() string {
+	switch m {
+	case FailOpen:
+		return "fail_open"
+	case FailFallback:
+		return "fallback"
+	default:
+		return "fail_closed"
+	}
+}
+
+// 限流器失败的原因.
+const (
+	FailureReasonError         = "error"
+	FailureReasonCircuitOpen   = "circuit_open"
+	FailureReasonFallbackError = "fallback_error"
+)
+
+// ErrCircuitOpen 熔断器打开, 没有调用限流器.
+var ErrCircuitOpen = errors.New("ratelimit: 熔断器打开")
+
+// FailurePolicy 定义限流器出现错误时的处理方式.
+// 零值为 FailClosed, 不熔断, 不记录指标.
+type FailurePolicy struct {
+	Mode FailureMode
+
+	// Breaker 限流器后端存储的熔断器. 打开时不调用限流器, 直接按 Mode 处理,
+	// 避免持续请求不可用的 Redis. 为 nil 时不熔断.
+	Breaker *CircuitBreaker
+
+	// Metrics 失败指标. 为 nil 时不记录.
+	Metrics *FailureMetrics
+}
+
+// Do 通过熔断器调用 primary, primary 出现错误或者熔断器打开时按 Mode 处理.
+// fallback 为备用的限流器, 可以为 nil. failed 为 true 时表示没有使用 primary 的结果,
+// 此时 Mode 为 FailOpen 或者 FailFallback.
+// 请求的 context 被取消或者超时导致的错误, 以及 ErrCostNotSupported, 不视为限流器失败, 原样返回.
+func (p FailurePolicy) Do(ctx context.Context, logger *slog.Logger,
+	primary, fallback func() (bool, error)) (limited, failed bool, err error) {
+	reason := FailureReasonCircuitOpen
+	if p.Breaker.Allow() {
+		limited, err = primary()
+		switch {
+		case err == nil:
+			p.Breaker.Success()
+			p.Metrics.setState(p.Breaker)
+			return limited, false, nil
+		case ctx.Err() != nil, errors.Is(err, ErrCostNotSupported):
+			return limited, false, err
+		}
+		p.Breaker.Failure()
+		p.Metrics.setState(p.Breaker)
+		reason = FailureReasonError
+		if p.Mode != FailClosed {
+			logger.LogAttrs(ctx, slog.LevelWarn, "限流器出现错误, 按失败策略处理",
+				slog.String("mode", p.Mode.String()), slog.Any("err", err))
+		}
+	} else {
+		err = ErrCircuitOpen
+	}
+	p.Metrics.incFailure(p.Mode, reason)
+
+	switch {
+	case p.Mode == FailOpen:
+		return false, true, nil
+	case p.Mode == FailFallback && fallback != nil:
+		limited, fbErr := fallback()
+		if fbErr != nil {
+			p.Metrics.incFailure(p.Mode, FailureReasonFallbackError)
+			return limited, false, errors.Join(err, fbErr)
+		}
+		return limited, true, nil
+	default:
+		return false, false, err
+	}
+}
+
+// Limit 通过 Do 调用 limiter 判断请求是否限流, fallback 为备用的限流器, 可以为 nil.
+// 使用的限流器实现了 DecisionLimiter 时设置响应头.
+func (p FailurePolicy) Limit(ctx *gin.Context, logger *slog.Logger,
+	limiter, fallback Limiter, key string, cost int) (limited, failed bool, err error) {
+	limitWith := func(limiter Limiter) func() (bool, error) {
+		return func() (bool, error) {
+			d, err := Decide(ctx.Request.Context(), limiter, key, cost)
+			if err != nil {
+				return false, err
+			}
+			if _, ok := limiter.(DecisionLimiter); ok {
+				SetHeaders(ctx, d)
+			}
+			return d.Limited, nil
+		}
+	}
+	var fb func() (bool, error)
+	if fallback != nil {
+		fb = limitWith(fallback)
+	}
+	return p.Do(ctx.Request.Context(), logger, limitWith(limiter), fb)
+}
+
+// CircuitState 熔断器的状态.
+type CircuitState int
+
+const (
+	// CircuitClosed 正常调用.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 不调用.
+	CircuitOpen
+	// CircuitHalfOpen 允许一个探测请求, 成功时关闭, 失败时重新打开.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker 熔断器. 连续失败达到阈值时打开, 经过 openTimeout 后进入半开状态,
+// 允许一个探测请求. 为 nil 时总是允许调用. 并发安全.
+type CircuitBreaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	// openedAt 打开的时间; probeAt 半开状态下探测请求开始的时间, 为零值时没有探测请求.
+	openedAt time.Time
+	probeAt  time.Time
+	timeFunc func() time.Time
+}
+
+// CircuitBreakerOption 定义 CircuitBreaker 的选项.
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold 设置打开熔断器的连续失败次数. 默认为 5.
+func WithFailureThreshold(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.failureThreshold = n
+	}
+}
+
+// WithOpenTimeout 设置熔断器打开的时间, 之后允许探测请求. 默认为 10 秒.
+// 探测请求超过该时间没有结果时允许新的探测请求.
+func WithOpenTimeout(d time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.openTimeout = d
+	}
+}
+
+// NewCircuitBreaker 创建一个熔断器.
+func NewCircuitBreaker(opts ...CircuitBreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		failureThreshold: 5,
+		openTimeout:      10 * time.Second,
+		timeFunc:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Allow 判断是否可以调用.
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.timeFunc()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		if !b.probeAt.IsZero() && now.Sub(b.probeAt) < b.openTimeout {
+			return false
+		}
+	default:
+		return true
+	}
+	b.probeAt = now
+	return true
+}
+
+// Success 报告调用成功.
+func (b *CircuitBreaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = CircuitClosed
+	b.failures = 0
+	b.probeAt = time.Time{}
+}
+
+// Failure 报告调用失败.
+func (b *CircuitBreaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.state = CircuitOpen
+		b.openedAt = b.timeFunc()
+		b.probeAt = time.Time{}
+	}
+}
+
+// State 返回熔断器的状态. 打开的时间超过 openTimeout 时仍然返回 CircuitOpen, 直到下一次 Allow.
+func (b *CircuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// FailureMetricsOpts 定义失败指标的配置.
+type FailureMetricsOpts struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels prometheus.Labels
+}
+
+// FailureMetrics 定义限流器失败的 prometheus 指标.
+// 为 nil 时不记录任何指标.
+type FailureMetrics struct {
+	failures     *prometheus.CounterVec
+	circuitState prometheus.Gauge
+}
+
+// NewFailureMetrics 创建失败指标并注册到 reg 中.
+// reg 为 nil 时使用 prometheus.DefaultRegisterer. 注册失败时 panic.
+// 多个 Builder 使用时需要通过 ConstLabels 或者 Subsystem 区分.
+func NewFailureMetrics(reg prometheus.Registerer, opts FailureMetricsOpts) *FailureMetrics {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &FailureMetrics{
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "rate_limit_failures_total",
+			Help:        "限流器失败的次数, 按失败策略与原因区分",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"mode", "reason"}),
+		circuitState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "rate_limit_circuit_state",
+			Help:        "限流器熔断器的状态, 0 为关闭, 1 为打开, 2 为半开",
+			ConstLabels: opts.ConstLabels,
+		}),
+	}
+	reg.MustRegister(m.failures, m.circuitState)
+	return m
+}
+
+func (m *FailureMetrics) incFailure(mode FailureMode, reason string) {
+	if m == nil {
+		return
+	}
+	m.failures.WithLabelValues(mode.String(), reason).Inc()
+}
+
+func (m *FailureMetrics) setState(b *CircuitBreaker) {
+	if m == nil || b == nil {
+		return
+	}
+	m.circuitState.Set(float64(b.State()))
+}
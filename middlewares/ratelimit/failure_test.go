@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	b := NewCircuitBreaker(WithFailureThreshold(2), WithOpenTimeout(time.Second))
+	b.timeFunc = func() time.Time { return now }
+
+	// 连续失败达到阈值时打开
+	assert.True(t, b.Allow())
+	b.Failure()
+	b.Success()
+	b.Failure()
+	assert.Equal(t, CircuitClosed, b.State())
+	b.Failure()
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// 半开状态只允许一个探测请求, 失败时重新打开
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// 探测请求没有结果时, 超时后允许新的探测请求
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+
+	// 探测成功时关闭
+	b.Success()
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.True(t, b.Allow())
+
+	// nil 总是允许调用
+	var nb *CircuitBreaker
+	assert.True(t, nb.Allow())
+	nb.Failure()
+	assert.Equal(t, CircuitClosed, nb.State())
+}
+
+func TestFailurePolicy_Do(t *testing.T) {
+	errRedis := errors.New("redis down")
+	ok := func(limited bool) func() (bool, error) {
+		return func() (bool, error) { return limited, nil }
+	}
+	fail := func(err error) func() (bool, error) {
+		return func() (bool, error) { return false, err }
+	}
+	tests := []struct {
+		name     string
+		mode     FailureMode
+		primary  func() (bool, error)
+		fallback func() (bool, error)
+
+		wantLimited bool
+		wantFailed  bool
+		wantErr     error
+		// 预期的失败指标
+		wantReason string
+	}{
+		{
+			name:        "success",
+			mode:        FailOpen,
+			primary:     ok(true),
+			wantLimited: true,
+		},
+		{
+			name:       "fail_closed",
+			mode:       FailClosed,
+			primary:    fail(errRedis),
+			wantErr:    errRedis,
+			wantReason: FailureReasonError,
+		},
+		{
+			name:       "fail_open",
+			mode:       FailOpen,
+			primary:    fail(errRedis),
+			wantFailed: true,
+			wantReason: FailureReasonError,
+		},
+		{
+			name:        "fallback",
+			mode:        FailFallback,
+			primary:     fail(errRedis),
+			fallback:    ok(true),
+			wantLimited: true,
+			wantFailed:  true,
+			wantReason:  FailureReasonError,
+		},
+		{
+			// 没有备用的限流器时与 FailClosed 相同
+			name:       "fallback_nil",
+			mode:       FailFallback,
+			primary:    fail(errRedis),
+			wantErr:    errRedis,
+			wantReason: FailureReasonError,
+		},
+		{
+			name:       "fallback_error",
+			mode:       FailFallback,
+			primary:    fail(errRedis),
+			fallback:   fail(errors.New("fallback error")),
+			wantErr:    errRedis,
+			wantReason: FailureReasonFallbackError,
+		},
+		{
+			// 配置错误不按失败策略处理
+			name:    "cost_not_supported",
+			mode:    FailOpen,
+			primary: fail(ErrCostNotSupported),
+			wantErr: ErrCostNotSupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewFailureMetrics(prometheus.NewRegistry(), FailureMetricsOpts{})
+			p := FailurePolicy{Mode: tt.mode, Metrics: m}
+			limited, failed, err := p.Do(context.Background(), slog.Default(), tt.primary, tt.fallback)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantLimited, limited)
+			assert.Equal(t, tt.wantFailed, failed)
+			if tt.wantReason != "" {
+				assert.Equal(t, float64(1),
+					testutil.ToFloat64(m.failures.WithLabelValues(tt.mode.String(), tt.wantReason)))
+			} else {
+				assert.Equal(t, 0, testutil.CollectAndCount(m.failures))
+			}
+		})
+	}
+}
+
+func TestFailurePolicy_Do_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := NewCircuitBreaker(WithFailureThreshold(1))
+	p := FailurePolicy{Mode: FailOpen, Breaker: b}
+	// 请求被取消导致的错误原样返回, 不计入熔断器
+	_, failed, err := p.Do(ctx, slog.Default(), func() (bool, error) {
+		return true, ctx.Err()
+	}, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, failed)
+	assert.Equal(t, CircuitClosed, b.State())
+}
+
+func TestFailurePolicy_Do_Breaker(t *testing.T) {
+	m := NewFailureMetrics(prometheus.NewRegistry(), FailureMetricsOpts{})
+	p := FailurePolicy{
+		Mode:    FailFallback,
+		Breaker: NewCircuitBreaker(WithFailureThreshold(2), WithOpenTimeout(time.Hour)),
+		Metrics: m,
+	}
+	var calls int
+	primary := func() (bool, error) {
+		calls++
+		return false, errors.New("redis down")
+	}
+	fallback := func() (bool, error) { return false, nil }
+	for i := 0; i < 5; i++ {
+		_, failed, err := p.Do(context.Background(), slog.Default(), primary, fallback)
+		require.NoError(t, err)
+		assert.True(t, failed)
+	}
+	// 熔断器打开后不再调用限流器
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.failures.WithLabelValues("fallback", FailureReasonError)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.failures.WithLabelValues("fallback", FailureReasonCircuitOpen)))
+	assert.Equal(t, float64(CircuitOpen), testutil.ToFloat64(m.circuitState))
+}
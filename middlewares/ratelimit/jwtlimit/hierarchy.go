@@ -0,0 +1,21 @@
+package jwtlimit
+
+import (
+	"github.com/gin-gonic/gin"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/udugong/ginx/auth/jwt"
+	"github.com/udugong/ginx/middlewares/ratelimit/slidewindowlimit"
+)
+
+// SubjectFromClaims 通过 jwt.ClaimsFromContext 获取 claims, 使用 fn 转换为 slidewindowlimit.Subject,
+// 作为 slidewindowlimit.NewHierarchyResolver 的参数. 需要在 jwt 认证中间件之后使用.
+func SubjectFromClaims[T jwtv5.Claims](fn func(T) slidewindowlimit.Subject) func(*gin.Context) (slidewindowlimit.Subject, bool) {
+	return func(ctx *gin.Context) (slidewindowlimit.Subject, bool) {
+		clm, ok := jwt.ClaimsFromContext[T](ctx.Request.Context())
+		if !ok {
+			return slidewindowlimit.Subject{}, false
+		}
+		return fn(clm), true
+	}
+}
@@ -0,0 +1,37 @@
+package jwtlimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/udugong/ginx/auth/jwt"
+	"github.com/udugong/ginx/middlewares/ratelimit/slidewindowlimit"
+)
+
+type planClaims struct {
+	Tenant string `json:"tenant"`
+	Plan   string `json:"plan"`
+	jwtv5.RegisteredClaims
+}
+
+func TestSubjectFromClaims(t *testing.T) {
+	fn := SubjectFromClaims(func(clm planClaims) slidewindowlimit.Subject {
+		return slidewindowlimit.Subject{Tenant: clm.Tenant, User: clm.Subject, Plan: clm.Plan}
+	})
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	// 匿名请求
+	_, ok := fn(ctx)
+	assert.False(t, ok)
+
+	clm := planClaims{Tenant: "acme", Plan: "pro", RegisteredClaims: jwtv5.RegisteredClaims{Subject: "alice"}}
+	ctx.Request = ctx.Request.WithContext(jwt.ContextWithClaims(ctx.Request.Context(), clm))
+	sub, ok := fn(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, slidewindowlimit.Subject{Tenant: "acme", User: "alice", Plan: "pro"}, sub)
+}
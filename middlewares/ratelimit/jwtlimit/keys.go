@@ -0,0 +1,23 @@
+// Package jwtlimit 提供基于 jwt 认证信息的限流 key 与分层限流的主体.
+// 与 ratelimit 包分开, 使不使用 jwt 认证的限流中间件不依赖 auth/jwt.
+package jwtlimit
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/udugong/ginx/auth/jwt"
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+// SubjectKey 按 jwt 认证的主体生成 key, 例如 "sub:alice".
+// 通过 jwt.GetPrincipal 获取主体, 因此需要在设置了 SetPrincipalFunc 的 jwt 认证中间件之后使用,
+// 否则所有请求都没有主体, 总是返回 false.
+func SubjectKey() ratelimit.KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		p, ok := jwt.GetPrincipal(ctx)
+		if !ok || p.Subject == "" {
+			return "", false
+		}
+		return "sub:" + p.Subject, true
+	}
+}
@@ -0,0 +1,47 @@
+package jwtlimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/udugong/ginx/auth/jwt"
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func TestSubjectKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *jwt.Principal
+		wantKey   string
+		wantFound bool
+	}{
+		{name: "subject", principal: &jwt.Principal{Subject: "alice"}, wantKey: "sub:alice", wantFound: true},
+		// 没有设置 SetPrincipalFunc 时没有主体
+		{name: "no_principal"},
+		{name: "empty_subject", principal: &jwt.Principal{Tenant: "acme"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.principal != nil {
+				ctx.Set(jwt.PrincipalKey, tt.principal)
+			}
+			key, ok := SubjectKey()(ctx)
+			assert.Equal(t, tt.wantKey, key)
+			assert.Equal(t, tt.wantFound, ok)
+		})
+	}
+}
+
+func TestSubjectKey_NewKeyGenFunc(t *testing.T) {
+	fn := ratelimit.NewKeyGenFunc("user_rate_limiter:", SubjectKey())
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, "user_rate_limiter:unknown", fn(ctx))
+	ctx.Set(jwt.PrincipalKey, &jwt.Principal{Subject: "alice"})
+	assert.Equal(t, "user_rate_limiter:sub:alice", fn(ctx))
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc 从请求中生成限流 key 的一部分. 请求中没有对应的信息时返回 false.
+// 通过 NewKeyGenFunc 转换为各个 Builder 的 SetKeyGenFunc 使用的函数.
+// 基于 jwt 认证主体的 KeyFunc 见 jwtlimit 包.
+type KeyFunc func(ctx *gin.Context) (string, bool)
+
+// IPKey 按客户端 IP 生成 key, 例如 "ip:192.0.2.1"、"ip:2001:db8:1:2::/64".
+func IPKey(r *ClientIPResolver) KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		key := r.Key(ctx)
+		return "ip:" + key, key != ""
+	}
+}
+
+// APIKey 按请求头中的 API key 生成 key, 例如 "apikey:9f86d081884c7d65".
+// 使用 API key 的 SHA-256 摘要的前 16 个十六进制字符, 避免在 Redis 与日志中保存 API key.
+func APIKey(header string) KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		v := ctx.GetHeader(header)
+		if v == "" {
+			return "", false
+		}
+		return "apikey:" + digest(v), true
+	}
+}
+
+// HeaderKey 按请求头的值生成 key, 例如 "x-tenant-id:822b33ad87c148a0".
+// 请求头由客户端控制, 因此使用值的 SHA-256 摘要的前 16 个十六进制字符, 限制 key 的长度.
+func HeaderKey(header string) KeyFunc {
+	name := strings.ToLower(header)
+	return func(ctx *gin.Context) (string, bool) {
+		v := ctx.GetHeader(header)
+		if v == "" {
+			return "", false
+		}
+		return name + ":" + digest(v), true
+	}
+}
+
+// digest 返回 v 的 SHA-256 摘要的前 16 个十六进制字符.
+func digest(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RouteKey 按请求方法与路由生成 key, 例如 "route:GET /user/:id".
+// 同一个路由的不同参数使用相同的 key. 没有匹配的路由时返回 false.
+func RouteKey() KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		path := ctx.FullPath()
+		if path == "" {
+			return "", false
+		}
+		return "route:" + ctx.Request.Method + " " + path, true
+	}
+}
+
+// JoinKeys 组合多个 KeyFunc, 以 "|" 连接, 例如每个用户在每个路由上的限流.
+// 任意一个返回 false 时返回 false.
+func JoinKeys(fns ...KeyFunc) KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		parts := make([]string, 0, len(fns))
+		for _, fn := range fns {
+			part, ok := fn(ctx)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, "|"), true
+	}
+}
+
+// FirstKey 返回第一个有值的 KeyFunc 的结果, 例如已认证的请求按用户, 匿名请求按 IP.
+func FirstKey(fns ...KeyFunc) KeyFunc {
+	return func(ctx *gin.Context) (string, bool) {
+		for _, fn := range fns {
+			if key, ok := fn(ctx); ok {
+				return key, true
+			}
+		}
+		return "", false
+	}
+}
+
+// NewKeyGenFunc 把 KeyFunc 转换为 Builder.SetKeyGenFunc 使用的函数, key 为 prefix 加上 fn 的结果.
+// fn 返回 false 时使用 prefix + "unknown", 这些请求共用一个 key.
+func NewKeyGenFunc(prefix string, fn KeyFunc) func(*gin.Context) string {
+	return func(ctx *gin.Context) string {
+		key, ok := fn(ctx)
+		if !ok {
+			key = "unknown"
+		}
+		return prefix + key
+	}
+}
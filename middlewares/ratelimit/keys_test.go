@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userKey 按 X-User 请求头生成 key, 用于测试组合 KeyFunc.
+func userKey(ctx *gin.Context) (string, bool) {
+	v := ctx.GetHeader("X-User")
+	return "user:" + v, v != ""
+}
+
+func TestKeyFunc(t *testing.T) {
+	r, err := NewClientIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	tests := []struct {
+		name      string
+		fn        KeyFunc
+		header    http.Header
+		target    string
+		wantKey   string
+		wantFound bool
+	}{
+		{name: "ip", fn: IPKey(r), wantKey: "ip:192.0.2.1", wantFound: true},
+		{
+			// 不保存 API key 的原文
+			name:      "api_key",
+			fn:        APIKey("X-API-Key"),
+			header:    http.Header{"X-Api-Key": {"test"}},
+			wantKey:   "apikey:9f86d081884c7d65",
+			wantFound: true,
+		},
+		{name: "api_key_missing", fn: APIKey("X-API-Key")},
+		{
+			name:      "header",
+			fn:        HeaderKey("X-Tenant-ID"),
+			header:    http.Header{"X-Tenant-Id": {"acme"}},
+			wantKey:   "x-tenant-id:822b33ad87c148a0",
+			wantFound: true,
+		},
+		{name: "header_missing", fn: HeaderKey("X-Tenant-ID")},
+		{name: "route", fn: RouteKey(), target: "/user/1", wantKey: "route:GET /user/:id", wantFound: true},
+		{name: "route_unmatched", fn: RouteKey(), target: "/unknown"},
+		{
+			name:      "join",
+			fn:        JoinKeys(userKey, RouteKey()),
+			header:    http.Header{"X-User": {"alice"}},
+			target:    "/user/1",
+			wantKey:   "user:alice|route:GET /user/:id",
+			wantFound: true,
+		},
+		{name: "join_missing", fn: JoinKeys(userKey, RouteKey()), target: "/user/1"},
+		{
+			name:      "first",
+			fn:        FirstKey(userKey, IPKey(r)),
+			header:    http.Header{"X-User": {"alice"}},
+			wantKey:   "user:alice",
+			wantFound: true,
+		},
+		{
+			// 匿名请求按 IP
+			name:      "first_anonymous",
+			fn:        FirstKey(userKey, IPKey(r)),
+			wantKey:   "ip:192.0.2.1",
+			wantFound: true,
+		},
+		{name: "first_missing", fn: FirstKey(userKey, HeaderKey("X-Tenant-ID"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotKey   string
+				gotFound bool
+			)
+			server := gin.New()
+			server.Use(func(ctx *gin.Context) {
+				gotKey, gotFound = tt.fn(ctx)
+			})
+			server.GET("/user/:id", func(ctx *gin.Context) {})
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.wantKey, gotKey)
+			assert.Equal(t, tt.wantFound, gotFound)
+		})
+	}
+}
+
+func TestNewKeyGenFunc(t *testing.T) {
+	fn := NewKeyGenFunc("user_rate_limiter:", userKey)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "user_rate_limiter:unknown", fn(ctx))
+	ctx.Request.Header.Set("X-User", "alice")
+	assert.Equal(t, "user_rate_limiter:user:alice", fn(ctx))
+}
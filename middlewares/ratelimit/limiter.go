@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter 定义各个限流中间件的 Limiter 共有的方法.
+// slidewindowlimit、activelimit 与 bucketlimit 的 Limiter 都实现了该接口.
+type Limiter interface {
+	// Limit 判断 key 是否限流.
+	Limit(ctx context.Context, key string) (bool, error)
+}
+
+// DecisionLimiter 定义返回额度信息的 Limiter.
+type DecisionLimiter interface {
+	// LimitDecision 与 Limit 相同, 同时返回额度信息.
+	LimitDecision(ctx context.Context, key string) (Decision, error)
+}
+
+// CostLimiter 定义支持一个请求计为多个请求的 Limiter.
+type CostLimiter interface {
+	// LimitN 与 LimitDecision 相同, 一个请求计为 n 个请求.
+	LimitN(ctx context.Context, key string, n int) (Decision, error)
+}
+
+// Decide 调用 limiter 判断 key 是否限流, 一个请求计为 cost 个请求. 不设置响应头.
+// limiter 实现了 CostLimiter 时调用 LimitN; 否则 cost 不为 1 时返回 ErrCostNotSupported.
+// 没有实现 DecisionLimiter 的 limiter 只返回 Limited.
+func Decide(ctx context.Context, limiter Limiter, key string, cost int) (Decision, error) {
+	if l, ok := limiter.(CostLimiter); ok {
+		return l.LimitN(ctx, key, cost)
+	}
+	if cost != 1 {
+		return Decision{}, ErrCostNotSupported
+	}
+	if l, ok := limiter.(DecisionLimiter); ok {
+		return l.LimitDecision(ctx, key)
+	}
+	limited, err := limiter.Limit(ctx, key)
+	return Decision{Limited: limited}, err
+}
+
+// Cost 返回 fn 计算的请求的 cost. fn 为 nil 时为 1, 小于 1 时按 1 计算.
+func Cost(ctx *gin.Context, fn func(*gin.Context) int) int {
+	if fn == nil {
+		return 1
+	}
+	return max(fn(ctx), 1)
+}
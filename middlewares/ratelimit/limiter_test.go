@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitFunc 只实现了 Limiter 的限流器.
+type limitFunc func(ctx context.Context, key string) (bool, error)
+
+func (f limitFunc) Limit(ctx context.Context, key string) (bool, error) {
+	return f(ctx, key)
+}
+
+// decisionLimiter 实现了 DecisionLimiter 与 CostLimiter 的限流器, 记录调用的 key 与 n.
+type decisionLimiter struct {
+	d    Decision
+	err  error
+	keys []string
+	ns   []int
+}
+
+func (l *decisionLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+func (l *decisionLimiter) LimitDecision(ctx context.Context, key string) (Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+func (l *decisionLimiter) LimitN(_ context.Context, key string, n int) (Decision, error) {
+	l.keys = append(l.keys, key)
+	l.ns = append(l.ns, n)
+	return l.d, l.err
+}
+
+func TestDecide(t *testing.T) {
+	limited := limitFunc(func(context.Context, string) (bool, error) { return true, nil })
+	tests := []struct {
+		name    string
+		limiter Limiter
+		cost    int
+		want    Decision
+		wantErr error
+	}{
+		{name: "limiter", limiter: limited, cost: 1, want: Decision{Limited: true}},
+		{name: "limiter_cost", limiter: limited, cost: 2, wantErr: ErrCostNotSupported},
+		{
+			name:    "cost_limiter",
+			limiter: &decisionLimiter{d: Decision{Limit: 10, Remaining: 8}},
+			cost:    2,
+			want:    Decision{Limit: 10, Remaining: 8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Decide(context.Background(), tt.limiter, "key", tt.cost)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, d)
+		})
+	}
+}
+
+func TestFailurePolicy_Limit(t *testing.T) {
+	errRedis := errors.New("redis down")
+	tests := []struct {
+		name     string
+		policy   FailurePolicy
+		limiter  Limiter
+		fallback Limiter
+
+		wantLimited bool
+		wantFailed  bool
+		wantErr     error
+		wantLimit   string
+	}{
+		{
+			name:        "decision",
+			limiter:     &decisionLimiter{d: Decision{Limited: true, Limit: 10, Window: time.Second}},
+			wantLimited: true,
+			wantLimit:   "10",
+		},
+		{
+			// 没有额度信息时不设置响应头
+			name:        "limiter",
+			limiter:     limitFunc(func(context.Context, string) (bool, error) { return true, nil }),
+			wantLimited: true,
+		},
+		{
+			name:    "error",
+			limiter: &decisionLimiter{err: errRedis},
+			wantErr: errRedis,
+		},
+		{
+			// 没有设置备用限流器时与 FailClosed 相同
+			name:    "no_fallback",
+			policy:  FailurePolicy{Mode: FailFallback},
+			limiter: &decisionLimiter{err: errRedis},
+			wantErr: errRedis,
+		},
+		{
+			name:       "fallback",
+			policy:     FailurePolicy{Mode: FailFallback},
+			limiter:    &decisionLimiter{err: errRedis},
+			fallback:   &decisionLimiter{d: Decision{Limit: 5, Remaining: 4}},
+			wantFailed: true,
+			wantLimit:  "5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			limited, failed, err := tt.policy.Limit(ctx, slog.Default(), tt.limiter, tt.fallback, "key", 1)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantLimited, limited)
+			assert.Equal(t, tt.wantFailed, failed)
+			assert.Equal(t, tt.wantLimit, recorder.Header().Get(HeaderLimit))
+		})
+	}
+}
+
+func TestShadowOpts_Decide(t *testing.T) {
+	l := &decisionLimiter{d: Decision{Limited: true, Limit: 10}}
+	d, err := ShadowOpts{Name: "new"}.Decide(context.Background(), slog.Default(), l, "key", 3)
+	require.NoError(t, err)
+	assert.True(t, d.Limited)
+	assert.Equal(t, []string{"key"}, l.keys)
+	assert.Equal(t, []int{3}, l.ns)
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 影子限流的决策结果.
+const (
+	ShadowOutcomeAllowed = "allowed"
+	ShadowOutcomeLimited = "limited"
+	ShadowOutcomeError   = "error"
+)
+
+// ShadowOpts 定义影子限流的配置.
+// 影子限流调用限流器但总是放行请求, 不设置响应头, 用于上线新的限制前观察哪些请求会被限流.
+type ShadowOpts struct {
+	// Name 影子限流的名称, 用于在日志与指标中区分多个影子限流.
+	Name string
+
+	// Metrics 影子限流指标. 为 nil 时不记录.
+	Metrics *ShadowMetrics
+}
+
+// Record 记录一次影子限流的决策.
+// 会被限流的请求使用 Info 级别的日志, 放行的请求使用 Debug 级别, 限流器的错误使用 Error 级别.
+// key 为空时不记录 key.
+func (o ShadowOpts) Record(ctx context.Context, logger *slog.Logger, key string, d Decision, err error) {
+	attrs := make([]slog.Attr, 0, 6)
+	attrs = append(attrs, slog.String("shadow", o.Name))
+	if key != "" {
+		attrs = append(attrs, slog.String("key", key))
+	}
+	if err != nil {
+		o.Metrics.inc(o.Name, ShadowOutcomeError)
+		logger.LogAttrs(ctx, slog.LevelError, "影子限流器出现错误", append(attrs, slog.Any("err", err))...)
+		return
+	}
+	if d.Name != "" {
+		attrs = append(attrs, slog.String("rule", d.Name))
+	}
+	if d.Limit > 0 {
+		attrs = append(attrs, slog.Int("limit", d.Limit), slog.Int("remaining", max(d.Remaining, 0)))
+	}
+	if !d.Limited {
+		o.Metrics.inc(o.Name, ShadowOutcomeAllowed)
+		logger.LogAttrs(ctx, slog.LevelDebug, "影子限流: 请求通过", attrs...)
+		return
+	}
+	o.Metrics.inc(o.Name, ShadowOutcomeLimited)
+	logger.LogAttrs(ctx, slog.LevelInfo, "影子限流: 请求将被限流", attrs...)
+}
+
+// Decide 调用 limiter 判断是否限流, 并通过 Record 记录决策. 不设置响应头, 不使用失败策略.
+// 返回错误时 limiter 没有计入请求.
+func (o ShadowOpts) Decide(ctx context.Context, logger *slog.Logger,
+	limiter Limiter, key string, cost int) (Decision, error) {
+	d, err := Decide(ctx, limiter, key, cost)
+	o.Record(ctx, logger, key, d, err)
+	return d, err
+}
+
+// ShadowMetricsOpts 定义影子限流指标的配置.
+type ShadowMetricsOpts struct {
+	Namespace   string
+	Subsystem   string
+	ConstLabels prometheus.Labels
+}
+
+// ShadowMetrics 定义影子限流的 prometheus 指标.
+// 为 nil 时不记录任何指标. 多个影子限流可以共用, 通过 ShadowOpts.Name 区分.
+type ShadowMetrics struct {
+	decisions *prometheus.CounterVec
+}
+
+// NewShadowMetrics 创建影子限流指标并注册到 reg 中.
+// reg 为 nil 时使用 prometheus.DefaultRegisterer. 注册失败时 panic.
+func NewShadowMetrics(reg prometheus.Registerer, opts ShadowMetricsOpts) *ShadowMetrics {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &ShadowMetrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "rate_limit_shadow_decisions_total",
+			Help:        "影子限流的决策数, 按影子限流的名称与结果区分",
+			ConstLabels: opts.ConstLabels,
+		}, []string{"name", "outcome"}),
+	}
+	reg.MustRegister(m.decisions)
+	return m
+}
+
+func (m *ShadowMetrics) inc(name, outcome string) {
+	if m == nil {
+		return
+	}
+	m.decisions.WithLabelValues(name, outcome).Inc()
+}
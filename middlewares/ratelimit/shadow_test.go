@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowOpts_Record(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision Decision
+		err      error
+
+		wantOutcome string
+		wantLog     string
+	}{
+		{
+			name:        "allowed",
+			decision:    Decision{Limit: 10, Remaining: 9},
+			wantOutcome: ShadowOutcomeAllowed,
+			wantLog:     `level=DEBUG msg="影子限流: 请求通过" shadow=new key=user:1 limit=10 remaining=9` + "\n",
+		},
+		{
+			name:        "limited",
+			decision:    Decision{Limited: true, Limit: 10, Remaining: -1, Name: "per_day"},
+			wantOutcome: ShadowOutcomeLimited,
+			wantLog:     `level=INFO msg="影子限流: 请求将被限流" shadow=new key=user:1 rule=per_day limit=10 remaining=0` + "\n",
+		},
+		{
+			// 没有额度信息的 Limiter
+			name:        "limited_without_decision",
+			decision:    Decision{Limited: true},
+			wantOutcome: ShadowOutcomeLimited,
+			wantLog:     `level=INFO msg="影子限流: 请求将被限流" shadow=new key=user:1` + "\n",
+		},
+		{
+			name:        "error",
+			err:         errors.New("mock error"),
+			wantOutcome: ShadowOutcomeError,
+			wantLog:     `level=ERROR msg=影子限流器出现错误 shadow=new key=user:1 err="mock error"` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+			m := NewShadowMetrics(prometheus.NewRegistry(), ShadowMetricsOpts{})
+			ShadowOpts{Name: "new", Metrics: m}.Record(context.Background(), logger, "user:1", tt.decision, tt.err)
+			assert.Equal(t, tt.wantLog, buf.String())
+			assert.Equal(t, float64(1), testutil.ToFloat64(m.decisions.WithLabelValues("new", tt.wantOutcome)))
+			assert.Equal(t, 1, testutil.CollectAndCount(m.decisions))
+		})
+	}
+
+	// 没有指标时只记录日志
+	ShadowOpts{}.Record(context.Background(), slog.Default(), "", Decision{}, nil)
+}
@@ -6,12 +6,17 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
 type Builder struct {
 	limiter  Limiter
 	genKeyFn func(ctx *gin.Context) string
+	costFn   func(ctx *gin.Context) int
 	logger   *slog.Logger
+	failure  ratelimit.FailurePolicy
+	fallback Limiter
 }
 
 // NewBuilder
@@ -31,6 +36,25 @@ func (b *Builder) SetKeyGenFunc(fn func(*gin.Context) string) *Builder {
 	return b
 }
 
+// SetCostFunc 设置请求的 cost, 即一个请求计为多少个请求. 默认为 1, 小于 1 时按 1 计算.
+// cost 超过阈值的请求总是返回 429. Limiter 需要实现 CostLimiter, 否则返回 500.
+func (b *Builder) SetCostFunc(fn func(*gin.Context) int) *Builder {
+	b.costFn = fn
+	return b
+}
+
+// SetFailurePolicy 设置限流器出现错误时的处理方式, 默认返回 500.
+func (b *Builder) SetFailurePolicy(policy ratelimit.FailurePolicy) *Builder {
+	b.failure = policy
+	return b
+}
+
+// SetFallbackLimiter 设置 ratelimit.FailFallback 使用的备用限流器, 一般为阈值按实例数缩小的 MemoryLimiter.
+func (b *Builder) SetFallbackLimiter(limiter Limiter) *Builder {
+	b.fallback = limiter
+	return b
+}
+
 func (b *Builder) SetLogger(logger *slog.Logger) *Builder {
 	b.logger = logger
 	return b
@@ -50,6 +74,15 @@ func (b *Builder) SetKeyGenFuncByIP() *Builder {
 	return b
 }
 
+// SetKeyGenFuncByClientIP 设置根据 r 解析的客户端 IP 进行限流.
+// 与 SetKeyGenFuncByIP 不同, 只信任 r 中配置的代理, 并且 IPv6 地址按网段聚合.
+func (b *Builder) SetKeyGenFuncByClientIP(r *ratelimit.ClientIPResolver) *Builder {
+	b.genKeyFn = func(ctx *gin.Context) string {
+		return "ip_rate_limiter:" + r.Key(ctx)
+	}
+	return b
+}
+
 func (b *Builder) Build() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		limited, err := b.limit(ctx)
@@ -67,6 +100,19 @@ func (b *Builder) Build() gin.HandlerFunc {
 	}
 }
 
+// BuildShadow 构建影子限流中间件, 只记录决策, 总是放行请求. 详见 ratelimit.ShadowOpts.
+func (b *Builder) BuildShadow(opts ratelimit.ShadowOpts) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		_, _ = opts.Decide(ctx.Request.Context(), b.logger, b.limiter, b.genKeyFn(ctx), b.cost(ctx))
+		ctx.Next()
+	}
+}
+
 func (b *Builder) limit(ctx *gin.Context) (bool, error) {
-	return b.limiter.Limit(ctx.Request.Context(), b.genKeyFn(ctx))
+	limited, _, err := b.failure.Limit(ctx, b.logger, b.limiter, b.fallback, b.genKeyFn(ctx), b.cost(ctx))
+	return limited, err
+}
+
+func (b *Builder) cost(ctx *gin.Context) int {
+	return ratelimit.Cost(ctx, b.costFn)
 }
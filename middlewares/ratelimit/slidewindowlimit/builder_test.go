@@ -6,10 +6,17 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
 )
 
 func TestBuilder_SetKeyGenFunc(t *testing.T) {
@@ -213,12 +220,133 @@ func TestBuilder_Build(t *testing.T) {
 	}
 }
 
+func TestBuilder_Headers(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	l := NewMemoryLimiter(time.Minute, 2)
+	l.timeFunc = func() time.Time { return now }
+	server := gin.New()
+	server.Use(NewBuilder(l).Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	tests := []struct {
+		name     string
+		advance  time.Duration
+		wantCode int
+		want     http.Header
+	}{
+		{
+			name:     "first",
+			wantCode: http.StatusOK,
+			want: http.Header{
+				"Ratelimit-Limit":     {"2"},
+				"Ratelimit-Remaining": {"1"},
+				"Ratelimit-Reset":     {"60"},
+				"Ratelimit-Policy":    {"2;w=60"},
+			},
+		},
+		{
+			name:     "second",
+			advance:  10 * time.Second,
+			wantCode: http.StatusOK,
+			want: http.Header{
+				"Ratelimit-Limit":     {"2"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"60"},
+				"Ratelimit-Policy":    {"2;w=60"},
+			},
+		},
+		{
+			name:     "limited",
+			advance:  20 * time.Second,
+			wantCode: http.StatusTooManyRequests,
+			want: http.Header{
+				"Ratelimit-Limit":     {"2"},
+				"Ratelimit-Remaining": {"0"},
+				"Ratelimit-Reset":     {"40"},
+				"Ratelimit-Policy":    {"2;w=60"},
+				"Retry-After":         {"30"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+			require.NoError(t, err)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.want, recorder.Header())
+		})
+	}
+}
+
 func (b *Builder) RegisterRoutes(server *gin.Engine) {
 	server.GET("/limit", func(ctx *gin.Context) {
 		ctx.Status(http.StatusOK)
 	})
 }
 
+func TestBuilder_SetCostFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		limiter  Limiter
+		cost     int
+		wantCode []int
+	}{
+		{
+			name:     "cost",
+			limiter:  NewMemoryLimiter(time.Minute, 10),
+			cost:     4,
+			wantCode: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			// 小于 1 时按 1 计算
+			name:     "at_least_one",
+			limiter:  NewMemoryLimiter(time.Minute, 2),
+			cost:     0,
+			wantCode: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			// 超过阈值的请求总是限流
+			name:     "exceed_capacity",
+			limiter:  NewMemoryLimiter(time.Minute, 10),
+			cost:     11,
+			wantCode: []int{http.StatusTooManyRequests, http.StatusTooManyRequests},
+		},
+		{
+			name:     "not_supported",
+			limiter:  &testLimiter{},
+			cost:     2,
+			wantCode: []int{http.StatusInternalServerError},
+		},
+		{
+			// cost 为 1 时不需要支持 cost
+			name:     "not_supported_one",
+			limiter:  &testLimiter{},
+			cost:     1,
+			wantCode: []int{http.StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gin.New()
+			server.Use(NewBuilder(tt.limiter).SetCostFunc(func(*gin.Context) int {
+				return tt.cost
+			}).Build())
+			server.GET("/limit", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			for i, want := range tt.wantCode {
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+				assert.Equal(t, want, recorder.Code, "request %d", i)
+			}
+		})
+	}
+}
+
 type testLimiter struct {
 	limited bool
 	err     error
@@ -227,3 +355,111 @@ type testLimiter struct {
 func (t *testLimiter) Limit(_ context.Context, _ string) (bool, error) {
 	return t.limited, t.err
 }
+
+func TestBuilder_SetFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     ratelimit.FailureMode
+		fallback Limiter
+		wantCode []int
+	}{
+		{
+			name:     "fail_closed",
+			mode:     ratelimit.FailClosed,
+			fallback: NewMemoryLimiter(time.Minute, 1),
+			wantCode: []int{http.StatusInternalServerError},
+		},
+		{
+			name:     "fail_open",
+			mode:     ratelimit.FailOpen,
+			wantCode: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "fallback",
+			mode:     ratelimit.FailFallback,
+			fallback: NewMemoryLimiter(time.Minute, 1),
+			wantCode: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			// 没有备用的限流器时与 fail-closed 相同
+			name:     "fallback_nil",
+			mode:     ratelimit.FailFallback,
+			wantCode: []int{http.StatusInternalServerError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := gin.New()
+			server.Use(NewBuilder(&testLimiter{err: errors.New("mock error")}).
+				SetFailurePolicy(ratelimit.FailurePolicy{Mode: tt.mode}).
+				SetFallbackLimiter(tt.fallback).Build())
+			server.GET("/limit", func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			for i, want := range tt.wantCode {
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+				assert.Equal(t, want, recorder.Code, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestBuilder_BuildShadow(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := ratelimit.NewShadowMetrics(reg, ratelimit.ShadowMetricsOpts{})
+	server := gin.New()
+	var keyCalls int
+	// 影子限流与现有的限流一起使用, 只记录不拦截, 不影响响应头
+	server.Use(NewBuilder(NewMemoryLimiter(time.Minute, 1)).
+		SetKeyGenFunc(func(*gin.Context) string {
+			keyCalls++
+			return "shadow"
+		}).
+		BuildShadow(ratelimit.ShadowOpts{Name: "new", Metrics: m}))
+	server.Use(NewBuilder(NewMemoryLimiter(time.Minute, 2)).Build())
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	wantCode := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	wantRemaining := []string{"1", "0", "0"}
+	for i := range wantCode {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+		assert.Equal(t, wantCode[i], recorder.Code, "request %d", i)
+		assert.Equal(t, wantRemaining[i], recorder.Header().Get(ratelimit.HeaderRemaining), "request %d", i)
+	}
+	// 每个请求只生成一次 key
+	assert.Equal(t, len(wantCode), keyCalls)
+
+	// 限流器出现错误时仍然放行
+	server = gin.New()
+	server.Use(NewBuilder(&testLimiter{err: errors.New("mock error")}).
+		BuildShadow(ratelimit.ShadowOpts{Name: "error", Metrics: m}))
+	server.GET("/limit", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/limit", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP rate_limit_shadow_decisions_total 影子限流的决策数, 按影子限流的名称与结果区分
+# TYPE rate_limit_shadow_decisions_total counter
+rate_limit_shadow_decisions_total{name="error",outcome="error"} 1
+rate_limit_shadow_decisions_total{name="new",outcome="allowed"} 1
+rate_limit_shadow_decisions_total{name="new",outcome="limited"} 2
+`))
+	assert.NoError(t, err)
+}
+
+func TestBuilder_SetKeyGenFuncByClientIP(t *testing.T) {
+	r, err := ratelimit.NewClientIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	b := NewBuilder(nil).SetKeyGenFuncByClientIP(r)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.RemoteAddr = "10.0.0.1:1234"
+	ctx.Request.Header.Set("X-Forwarded-For", "2001:db8:1:2:3:4:5:6")
+	assert.Equal(t, "ip_rate_limiter:2001:db8:1:2::/64", b.genKeyFn(ctx))
+}
@@ -0,0 +1,272 @@
+package slidewindowlimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"hash/maphash"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+//go:embed lua/composite_slide_window.lua
+var compositeSlideWindowLua string
+
+var compositeSlideWindowScript = redis.NewScript(compositeSlideWindowLua)
+
+var (
+	_ CostLimiter = (*CompositeMemoryLimiter)(nil)
+	_ CostLimiter = (*CompositeRedisLimiter)(nil)
+)
+
+// Rule 定义组合限流中的一个限制: 在任意 Window 时间内最多允许 Threshold 个请求.
+type Rule struct {
+	// Name 限制的名称, 用于报告触发限流的限制. 为空时使用 "threshold;w=seconds", 例如 "1000;w=86400",
+	// 窗口不是整数秒时使用毫秒, 例如 "10;w=500ms". 同一个组合限流器中的名称不能相同.
+	Name      string
+	Window    time.Duration
+	Threshold int
+}
+
+func (r Rule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Window%time.Second == 0 {
+		return strconv.Itoa(r.Threshold) + ";w=" + strconv.FormatInt(int64(r.Window/time.Second), 10)
+	}
+	return strconv.Itoa(r.Threshold) + ";w=" + strconv.FormatInt(r.Window.Milliseconds(), 10) + "ms"
+}
+
+// check 检查限制的窗口与阈值.
+// Redis 中窗口的精度为毫秒, 不足 1 毫秒的窗口会使 key 立即过期, 因此窗口需要至少 1 毫秒.
+func (r Rule) check() error {
+	if r.Window < time.Millisecond || r.Threshold <= 0 {
+		return fmt.Errorf("slidewindowlimit: 限制 %q 无效, 窗口需要至少 1 毫秒, 阈值需要大于 0", r.name())
+	}
+	return nil
+}
+
+// checkRules 检查组合限流器的限制: 不能为空, 窗口需要至少 1 毫秒, 阈值需要大于 0, 名称不能相同.
+// 名称相同时无法区分触发限流的限制, 在 Redis 中还会共用同一个 key.
+func checkRules(rules []Rule) {
+	if len(rules) == 0 {
+		panic("slidewindowlimit: 组合限流器至少需要一个限制")
+	}
+	seen := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		name := r.name()
+		if err := r.check(); err != nil {
+			panic(err.Error())
+		}
+		if _, ok := seen[name]; ok {
+			panic(fmt.Sprintf("slidewindowlimit: 组合限流器的限制 %q 重复", name))
+		}
+		seen[name] = struct{}{}
+	}
+}
+
+// combine 合并各个限制的决策.
+// 有限制触发限流时返回第一个触发的限制, RetryAfter 为触发的限制中最长的, 全部通过后才能重试;
+// 否则返回剩余额度最少的限制.
+func combine(rules []Rule, ds []ratelimit.Decision) ratelimit.Decision {
+	res, idx := ds[0], 0
+	var retryAfter time.Duration
+	for i, d := range ds {
+		if d.Limited {
+			if !res.Limited {
+				res, idx = d, i
+			}
+			retryAfter = max(retryAfter, d.RetryAfter)
+			continue
+		}
+		if !res.Limited && d.Remaining < res.Remaining {
+			res, idx = d, i
+		}
+	}
+	if res.Limited {
+		res.RetryAfter = retryAfter
+	}
+	res.Name = rules[idx].name()
+	return res
+}
+
+// CompositeMemoryLimiter 基于内存的组合滑动窗口限流器.
+// 对同一个 key 同时执行多个限制, 例如每秒 10 个并且每天 1000 个请求.
+// 全部限制都通过时才计入请求, 任意一个限制触发限流时不消耗其他限制的额度.
+// 超过 2 个最大窗口没有请求的 key 会被清理. 并发安全.
+type CompositeMemoryLimiter struct {
+	rules []Rule
+	// limiters 与 rules 一一对应, 只用于计算每个限制的决策.
+	limiters  []*MemoryLimiter
+	maxWindow time.Duration
+	seed      maphash.Seed
+	shards    [shardCount]*compositeShard
+	// lastSweep 上一次清理的时间 (Unix 纳秒).
+	lastSweep atomic.Int64
+	timeFunc  func() time.Time
+}
+
+type compositeShard struct {
+	mu   sync.Mutex
+	keys map[string]*compositeState
+}
+
+// compositeState 定义一个 key 在各个限制中的窗口状态.
+type compositeState struct {
+	lastSeen time.Time
+	states   []*windowState
+}
+
+// NewCompositeMemoryLimiter 创建一个基于内存的组合滑动窗口限流器.
+// rules 不能为空, 名称不能相同, 否则 panic. opts 作用于每个限制, 例如 WithAlgorithm(AlgorithmApproximate)
+// 可以避免按天的限制保存大量的时间戳.
+func NewCompositeMemoryLimiter(rules []Rule, opts ...MemoryOption) *CompositeMemoryLimiter {
+	checkRules(rules)
+	l := &CompositeMemoryLimiter{
+		rules:    rules,
+		limiters: make([]*MemoryLimiter, len(rules)),
+		seed:     maphash.MakeSeed(),
+		timeFunc: time.Now,
+	}
+	for i, r := range rules {
+		l.limiters[i] = NewMemoryLimiter(r.Window, r.Threshold, opts...)
+		l.maxWindow = max(l.maxWindow, r.Window)
+	}
+	for i := range l.shards {
+		l.shards[i] = &compositeShard{keys: make(map[string]*compositeState)}
+	}
+	return l
+}
+
+func (l *CompositeMemoryLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitN(ctx, key, 1)
+	return d.Limited, err
+}
+
+func (l *CompositeMemoryLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+// LimitN 判断 n 个请求是否限流, 全部限制都通过时原子地在每个限制中计入 n 个请求.
+// 返回的 Decision.Name 为触发限流或者剩余额度最少的限制.
+func (l *CompositeMemoryLimiter) LimitN(_ context.Context, key string, n int) (ratelimit.Decision, error) {
+	now := l.timeFunc()
+	l.sweep(now)
+	s := l.shards[maphash.String(l.seed, key)%shardCount]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.keys[key]
+	if !ok {
+		state = &compositeState{states: make([]*windowState, len(l.rules))}
+		for i := range state.states {
+			state.states[i] = &windowState{start: now}
+		}
+		s.keys[key] = state
+	}
+	state.lastSeen = now
+	ds := make([]ratelimit.Decision, len(l.rules))
+	limited := false
+	for i, ml := range l.limiters {
+		ds[i] = ml.decide(state.states[i], now, n)
+		limited = limited || ds[i].Limited
+	}
+	if !limited {
+		for i, ml := range l.limiters {
+			ml.consume(state.states[i], now, n)
+			ds[i] = ml.decide(state.states[i], now, 0)
+		}
+	}
+	return combine(l.rules, ds), nil
+}
+
+// Len 返回当前保存状态的 key 数量.
+func (l *CompositeMemoryLimiter) Len() int {
+	var n int
+	for _, s := range l.shards {
+		s.mu.Lock()
+		n += len(s.keys)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// sweep 每隔一个最大窗口清理超过 2 个最大窗口没有请求的 key.
+func (l *CompositeMemoryLimiter) sweep(now time.Time) {
+	last := l.lastSweep.Load()
+	if now.UnixNano()-last < int64(l.maxWindow) || !l.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	for _, s := range l.shards {
+		s.mu.Lock()
+		for key, state := range s.keys {
+			if now.Sub(state.lastSeen) >= 2*l.maxWindow {
+				delete(s.keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// CompositeRedisLimiter 基于 Redis 的组合滑动窗口限流器, 可以在多个实例之间共享.
+// 每个限制使用一个有序集合, 通过 Lua 脚本在一次往返中检查全部限制,
+// 全部限制都通过时才计入请求, 任意一个限制触发限流时不消耗其他限制的额度.
+// 同一个 key 的各个限制使用相同的 hash tag, 可以在 Redis Cluster 中使用.
+type CompositeRedisLimiter struct {
+	rules []Rule
+	// base 保存 Redis 客户端、key 前缀与成员的生成方式.
+	base *RedisLimiter
+}
+
+// NewCompositeRedisLimiter 创建一个基于 Redis 的组合滑动窗口限流器.
+// rules 不能为空, 名称不能相同, 否则 panic. 窗口的精度为毫秒.
+// 第 i 个限制的 key 为 prefix + "{" + key + "}:" + 限制的名称.
+func NewCompositeRedisLimiter(client redis.Cmdable, rules []Rule, opts ...RedisOption) *CompositeRedisLimiter {
+	checkRules(rules)
+	return &CompositeRedisLimiter{
+		rules: rules,
+		base:  newRedisLimiter(client, 0, 0, opts...),
+	}
+}
+
+func (l *CompositeRedisLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitN(ctx, key, 1)
+	return d.Limited, err
+}
+
+func (l *CompositeRedisLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+// LimitN 判断 n 个请求是否限流, 全部限制都通过时原子地在每个限制中计入 n 个请求.
+// 返回的 Decision.Name 为触发限流或者剩余额度最少的限制.
+// Redis 出现错误时返回错误, 由调用方决定如何处理, 不会 panic.
+func (l *CompositeRedisLimiter) LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error) {
+	now := l.base.timeFunc().UnixMilli()
+	keys := make([]string, len(l.rules))
+	args := make([]any, 0, 3+2*len(l.rules))
+	args = append(args, now, l.base.member(now), n)
+	for i, r := range l.rules {
+		keys[i] = l.base.prefix + "{" + key + "}:" + r.name()
+		args = append(args, r.Window.Milliseconds(), r.Threshold)
+	}
+	res, err := compositeSlideWindowScript.Run(ctx, l.base.client, keys, args...).Int64Slice()
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("redis 组合滑动窗口限流失败: %w", err)
+	}
+	if len(res) != 4*len(l.rules) {
+		return ratelimit.Decision{}, fmt.Errorf("redis 组合滑动窗口限流失败: 无效的返回值 %v", res)
+	}
+	ds := make([]ratelimit.Decision, len(l.rules))
+	for i, r := range l.rules {
+		ds[i] = redisDecision(res[4*i:4*i+4], r.Window, r.Threshold, now)
+	}
+	return combine(l.rules, ds), nil
+}
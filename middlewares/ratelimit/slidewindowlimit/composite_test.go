@@ -0,0 +1,156 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func TestRule_Name(t *testing.T) {
+	assert.Equal(t, "per_day", Rule{Name: "per_day", Window: 24 * time.Hour, Threshold: 1000}.name())
+	assert.Equal(t, "1000;w=86400", Rule{Window: 24 * time.Hour, Threshold: 1000}.name())
+	// 不是整数秒的窗口使用毫秒, 不会与其他窗口的名称相同
+	assert.Equal(t, "10;w=500ms", Rule{Window: 500 * time.Millisecond, Threshold: 10}.name())
+	assert.Equal(t, "10;w=1500ms", Rule{Window: 1500 * time.Millisecond, Threshold: 10}.name())
+}
+
+func TestNewCompositeLimiter_InvalidRules(t *testing.T) {
+	_, client := newRedisClient(t)
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{name: "empty"},
+		{name: "same_name", rules: []Rule{
+			{Name: "a", Window: time.Second, Threshold: 1},
+			{Name: "a", Window: time.Minute, Threshold: 10},
+		}},
+		{name: "same_default_name", rules: []Rule{
+			{Window: time.Second, Threshold: 1},
+			{Window: time.Second, Threshold: 1},
+		}},
+		{name: "zero_window", rules: []Rule{{Name: "a", Threshold: 1}}},
+		// Redis 中窗口的精度为毫秒
+		{name: "sub_millisecond_window", rules: []Rule{{Name: "a", Window: time.Microsecond, Threshold: 1}}},
+		{name: "zero_threshold", rules: []Rule{{Name: "a", Window: time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Panics(t, func() { NewCompositeMemoryLimiter(tt.rules) })
+			assert.Panics(t, func() { NewCompositeRedisLimiter(client, tt.rules) })
+		})
+	}
+	assert.NotPanics(t, func() {
+		NewCompositeMemoryLimiter([]Rule{
+			{Window: 500 * time.Millisecond, Threshold: 1},
+			{Window: 200 * time.Millisecond, Threshold: 1},
+		})
+	})
+}
+
+func TestCompositeLimiter(t *testing.T) {
+	rules := []Rule{
+		{Name: "second", Window: time.Second, Threshold: 2},
+		{Name: "minute", Window: time.Minute, Threshold: 3},
+	}
+	type step struct {
+		advance time.Duration
+		n       int
+		// 只比较 Limited, Remaining, RetryAfter 与 Name
+		want ratelimit.Decision
+	}
+	steps := []step{
+		{n: 1, want: ratelimit.Decision{Remaining: 1, Name: "second"}},
+		{n: 1, want: ratelimit.Decision{Remaining: 0, Name: "second"}},
+		{
+			// 触发每秒的限制, 不消耗每分钟的额度
+			n:    1,
+			want: ratelimit.Decision{Limited: true, Remaining: 0, RetryAfter: time.Second, Name: "second"},
+		},
+		{advance: time.Second, n: 1, want: ratelimit.Decision{Remaining: 0, Name: "minute"}},
+		{
+			advance: time.Second,
+			n:       1,
+			want:    ratelimit.Decision{Limited: true, Remaining: 0, RetryAfter: 58 * time.Second, Name: "minute"},
+		},
+		{
+			// 两个限制都触发时, 报告第一个触发的限制, 需要等到全部通过
+			n:    3,
+			want: ratelimit.Decision{Limited: true, Remaining: 2, RetryAfter: 59 * time.Second, Name: "second"},
+		},
+		{advance: 58 * time.Second, n: 2, want: ratelimit.Decision{Remaining: 0, Name: "second"}},
+	}
+	newLimiters := map[string]func(t *testing.T, now *time.Time) CostLimiter{
+		"memory_exact": func(t *testing.T, now *time.Time) CostLimiter {
+			l := NewCompositeMemoryLimiter(rules)
+			l.timeFunc = func() time.Time { return *now }
+			return l
+		},
+		"redis": func(t *testing.T, now *time.Time) CostLimiter {
+			_, client := newRedisClient(t)
+			l := NewCompositeRedisLimiter(client, rules, WithKeyPrefix("test:"))
+			l.base.timeFunc = func() time.Time { return *now }
+			return l
+		},
+	}
+	for name, newLimiter := range newLimiters {
+		t.Run(name, func(t *testing.T) {
+			now := time.UnixMilli(1695571200000)
+			l := newLimiter(t, &now)
+			for i, s := range steps {
+				now = now.Add(s.advance)
+				d, err := l.LimitN(context.Background(), "key", s.n)
+				require.NoError(t, err)
+				got := ratelimit.Decision{Limited: d.Limited, Remaining: d.Remaining,
+					RetryAfter: d.RetryAfter, Name: d.Name}
+				assert.Equal(t, s.want, got, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestCompositeMemoryLimiter_Sweep(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	l := NewCompositeMemoryLimiter([]Rule{
+		{Window: time.Second, Threshold: 10},
+		{Window: time.Minute, Threshold: 100},
+	}, WithAlgorithm(AlgorithmApproximate))
+	l.timeFunc = func() time.Time { return now }
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := l.Limit(context.Background(), key)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, l.Len())
+
+	// 按最大的窗口清理
+	now = now.Add(time.Minute)
+	_, err := l.Limit(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Len())
+	now = now.Add(time.Minute)
+	_, err = l.Limit(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestCompositeRedisLimiter_Keys(t *testing.T) {
+	mr, client := newRedisClient(t)
+	l := NewCompositeRedisLimiter(client, []Rule{
+		{Name: "second", Window: time.Second, Threshold: 10},
+		{Window: time.Minute, Threshold: 100},
+	})
+	_, err := l.Limit(context.Background(), "key")
+	require.NoError(t, err)
+	// 同一个 key 的限制使用相同的 hash tag
+	assert.True(t, mr.Exists("ginx:slidewindow:{key}:second"))
+	assert.True(t, mr.Exists("ginx:slidewindow:{key}:100;w=60"))
+
+	mr.Close()
+	_, err = l.Limit(context.Background(), "key")
+	assert.Error(t, err)
+}
@@ -0,0 +1,471 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+var (
+	_ HierarchyLimiter = (*HierarchyMemoryLimiter)(nil)
+	_ HierarchyLimiter = (*HierarchyRedisLimiter)(nil)
+)
+
+// Level 定义分层限流中的一个层级: 对 Key 执行 Rule.
+// 同一个 Key 可以有多个 Rule, 例如租户每秒 10 个并且每天 1000 个请求, 此时 Rule 的名称不能相同.
+type Level struct {
+	// Key 限流对象, 例如 "tenant:acme".
+	Key  string
+	Rule Rule
+}
+
+// HierarchyLimiter 分层限流器. 每次调用时传入层级与额度, 因此不同租户可以使用不同的额度.
+type HierarchyLimiter interface {
+	// LimitLevels 判断 n 个请求是否限流, 全部层级都通过时原子地在每个层级中计入 n 个请求,
+	// 任意一个层级触发限流时不消耗其他层级的额度.
+	// 返回的 Decision.Name 为第一个触发限流或者剩余额度最少的层级的 Rule 名称.
+	// 层级的 Rule 无效 (窗口不足 1 毫秒或者阈值不大于 0) 时返回错误.
+	LimitLevels(ctx context.Context, levels []Level, n int) (ratelimit.Decision, error)
+}
+
+// HierarchyMemoryLimiter 基于内存的分层滑动窗口限流器.
+// 超过 2 个窗口没有请求的层级会被清理. 并发安全.
+type HierarchyMemoryLimiter struct {
+	opts []MemoryOption
+
+	// evaluators 按窗口与阈值缓存的 MemoryLimiter, 只用于计算每个层级的决策.
+	mu         sync.Mutex
+	evaluators map[evaluatorKey]*MemoryLimiter
+
+	seed   maphash.Seed
+	shards [shardCount]*hierarchyShard
+	// maxWindow 见过的最大窗口, 作为清理的间隔.
+	maxWindow atomic.Int64
+	// lastSweep 上一次清理的时间 (Unix 纳秒).
+	lastSweep atomic.Int64
+	timeFunc  func() time.Time
+}
+
+type evaluatorKey struct {
+	window    time.Duration
+	threshold int
+}
+
+type hierarchyShard struct {
+	mu   sync.Mutex
+	keys map[string]*hierarchyState
+}
+
+// hierarchyState 定义一个层级的窗口状态.
+type hierarchyState struct {
+	window time.Duration
+	*windowState
+}
+
+// NewHierarchyMemoryLimiter 创建一个基于内存的分层滑动窗口限流器.
+// opts 作用于每个层级, 例如 WithAlgorithm(AlgorithmApproximate).
+func NewHierarchyMemoryLimiter(opts ...MemoryOption) *HierarchyMemoryLimiter {
+	l := &HierarchyMemoryLimiter{
+		opts:       opts,
+		evaluators: make(map[evaluatorKey]*MemoryLimiter),
+		seed:       maphash.MakeSeed(),
+		timeFunc:   time.Now,
+	}
+	for i := range l.shards {
+		l.shards[i] = &hierarchyShard{keys: make(map[string]*hierarchyState)}
+	}
+	return l
+}
+
+func (l *HierarchyMemoryLimiter) LimitLevels(_ context.Context, levels []Level, n int) (ratelimit.Decision, error) {
+	if len(levels) == 0 {
+		return ratelimit.Decision{}, nil
+	}
+	if err := checkLevels(levels); err != nil {
+		return ratelimit.Decision{}, err
+	}
+	now := l.timeFunc()
+	l.sweep(now)
+
+	// 按分片的顺序加锁, 避免死锁
+	stateKeys := make([]string, len(levels))
+	idx := make([]int, len(levels))
+	for i, lv := range levels {
+		stateKeys[i] = lv.Key + "\x00" + lv.Rule.name()
+		idx[i] = int(maphash.String(l.seed, stateKeys[i]) % shardCount)
+	}
+	locked := slices.Clone(idx)
+	slices.Sort(locked)
+	locked = slices.Compact(locked)
+	for _, i := range locked {
+		l.shards[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range locked {
+			l.shards[i].mu.Unlock()
+		}
+	}()
+
+	rules := make([]Rule, len(levels))
+	evaluators := make([]*MemoryLimiter, len(levels))
+	states := make([]*windowState, len(levels))
+	ds := make([]ratelimit.Decision, len(levels))
+	limited := false
+	for i, lv := range levels {
+		rules[i] = lv.Rule
+		evaluators[i] = l.evaluator(lv.Rule)
+		s := l.shards[idx[i]]
+		state, ok := s.keys[stateKeys[i]]
+		if !ok || state.window != lv.Rule.Window {
+			// 额度的窗口改变时重新计数
+			state = &hierarchyState{window: lv.Rule.Window, windowState: &windowState{start: now}}
+			s.keys[stateKeys[i]] = state
+		}
+		state.lastSeen = now
+		states[i] = state.windowState
+		ds[i] = evaluators[i].decide(states[i], now, n)
+		limited = limited || ds[i].Limited
+	}
+	if !limited {
+		for i, ml := range evaluators {
+			ml.consume(states[i], now, n)
+			ds[i] = ml.decide(states[i], now, 0)
+		}
+	}
+	return combine(rules, ds), nil
+}
+
+// Len 返回当前保存状态的层级数量.
+func (l *HierarchyMemoryLimiter) Len() int {
+	var n int
+	for _, s := range l.shards {
+		s.mu.Lock()
+		n += len(s.keys)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (l *HierarchyMemoryLimiter) evaluator(r Rule) *MemoryLimiter {
+	k := evaluatorKey{window: r.Window, threshold: r.Threshold}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ml, ok := l.evaluators[k]
+	if !ok {
+		ml = NewMemoryLimiter(r.Window, r.Threshold, l.opts...)
+		l.evaluators[k] = ml
+		if int64(r.Window) > l.maxWindow.Load() {
+			l.maxWindow.Store(int64(r.Window))
+		}
+	}
+	return ml
+}
+
+// sweep 每隔一个最大窗口清理超过 2 个窗口没有请求的层级.
+func (l *HierarchyMemoryLimiter) sweep(now time.Time) {
+	last := l.lastSweep.Load()
+	if now.UnixNano()-last < l.maxWindow.Load() || !l.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	for _, s := range l.shards {
+		s.mu.Lock()
+		for key, state := range s.keys {
+			if now.Sub(state.lastSeen) >= 2*state.window {
+				delete(s.keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// HierarchyRedisLimiter 基于 Redis 的分层滑动窗口限流器, 可以在多个实例之间共享.
+// 每个层级使用一个有序集合, 通过 Lua 脚本在一次往返中检查全部层级.
+// 层级的 key 为 prefix + Level.Key + ":" + Rule 的名称.
+// Redis Cluster 要求一次调用的全部 key 在同一个 slot, 因此各个层级的 key 需要使用相同的 hash tag,
+// NewHierarchyResolver 生成的 key 满足该要求. 任意一个 key 使用了 hash tag 而全部 key 的 hash tag 不相同时返回错误;
+// 全部 key 都没有 hash tag 时不检查, 只能在单节点的 Redis 中使用.
+type HierarchyRedisLimiter struct {
+	// base 保存 Redis 客户端、key 前缀与成员的生成方式.
+	base *RedisLimiter
+}
+
+// NewHierarchyRedisLimiter 创建一个基于 Redis 的分层滑动窗口限流器. 窗口的精度为毫秒.
+func NewHierarchyRedisLimiter(client redis.Cmdable, opts ...RedisOption) *HierarchyRedisLimiter {
+	return &HierarchyRedisLimiter{base: newRedisLimiter(client, 0, 0, opts...)}
+}
+
+// LimitLevels 见 HierarchyLimiter.
+// Redis 出现错误时返回错误, 由调用方决定如何处理, 不会 panic.
+func (l *HierarchyRedisLimiter) LimitLevels(ctx context.Context, levels []Level, n int) (ratelimit.Decision, error) {
+	if len(levels) == 0 {
+		return ratelimit.Decision{}, nil
+	}
+	if err := checkLevels(levels); err != nil {
+		return ratelimit.Decision{}, err
+	}
+	now := l.base.timeFunc().UnixMilli()
+	keys := make([]string, len(levels))
+	rules := make([]Rule, len(levels))
+	args := make([]any, 0, 3+2*len(levels))
+	args = append(args, now, l.base.member(now), n)
+	for i, lv := range levels {
+		keys[i] = l.base.prefix + lv.Key + ":" + lv.Rule.name()
+		rules[i] = lv.Rule
+		args = append(args, lv.Rule.Window.Milliseconds(), lv.Rule.Threshold)
+	}
+	if err := checkHashTags(keys); err != nil {
+		return ratelimit.Decision{}, err
+	}
+	res, err := compositeSlideWindowScript.Run(ctx, l.base.client, keys, args...).Int64Slice()
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("redis 分层滑动窗口限流失败: %w", err)
+	}
+	if len(res) != 4*len(levels) {
+		return ratelimit.Decision{}, fmt.Errorf("redis 分层滑动窗口限流失败: 无效的返回值 %v", res)
+	}
+	ds := make([]ratelimit.Decision, len(levels))
+	for i, r := range rules {
+		ds[i] = redisDecision(res[4*i:4*i+4], r.Window, r.Threshold, now)
+	}
+	return combine(rules, ds), nil
+}
+
+// checkLevels 检查层级的 Rule.
+// 内存中无效的 Rule 会使 NewMemoryLimiter panic, Redis 中窗口不足 1 毫秒时不限流, 阈值不大于 0 时全部限流.
+func checkLevels(levels []Level) error {
+	for _, lv := range levels {
+		if err := lv.Rule.check(); err != nil {
+			return fmt.Errorf("层级 %q: %w", lv.Key, err)
+		}
+	}
+	return nil
+}
+
+// checkHashTags 检查使用了 hash tag 的 key 的 hash tag 是否相同.
+func checkHashTags(keys []string) error {
+	tagged := false
+	for _, k := range keys {
+		if _, ok := hashTag(k); ok {
+			tagged = true
+			break
+		}
+	}
+	if !tagged {
+		return nil
+	}
+	first, _ := hashTag(keys[0])
+	for _, k := range keys[1:] {
+		if tag, _ := hashTag(k); tag != first {
+			return fmt.Errorf("slidewindowlimit: 层级的 key %q 与 %q 的 hash tag 不相同, 在 Redis Cluster 中不在同一个 slot", keys[0], k)
+		}
+	}
+	return nil
+}
+
+// hashTag 返回 key 的 hash tag, 即第一个 "{" 与其后第一个 "}" 之间的非空字符串. 没有时返回 key 本身与 false.
+// 详见 https://redis.io/docs/latest/operate/oss_and_stack/reference/cluster-spec/#hash-tags
+func hashTag(key string) (string, bool) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key, false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key, false
+	}
+	return key[start+1 : start+1+end], true
+}
+
+// Quota 定义一个套餐中租户与用户的额度. 每个层级可以有多个限制.
+type Quota struct {
+	Tenant []Rule
+	User   []Rule
+}
+
+// PlanTable 套餐名称到额度的映射.
+// 套餐不在表中时使用空字符串对应的额度, 仍然没有时不限制租户与用户.
+type PlanTable map[string]Quota
+
+// Subject 定义请求的租户、用户与套餐.
+type Subject struct {
+	Tenant string
+	User   string
+	Plan   string
+}
+
+// NewHierarchyResolver 根据全局限制与套餐表创建解析层级的函数.
+// 层级依次为全局、租户、用户, key 分别为 "{global}"、"{global}:tenant:" + 租户、"{global}:tenant:" + 租户 + ":user:" + 用户.
+// 为了在 Redis Cluster 中使用, 同一个请求的全部 key 使用相同的 hash tag: 有全局限制时为 {global},
+// 全部请求在同一个 slot; 没有全局限制时为 {tenant:租户}, 例如 "{tenant:acme}"、"{tenant:acme}:user:alice".
+// 层级的 Rule 名称为层级名称加上限制的名称, 例如 "tenant:per_day".
+// subjectFn 返回 false 时 (例如匿名请求) 只执行全局限制; 租户或者用户为空时跳过对应的层级.
+// 从 jwt claims 中获取 Subject 可以使用 jwtlimit.SubjectFromClaims.
+// 全局限制或者套餐中的限制无效 (窗口不足 1 毫秒或者阈值不大于 0) 时 panic.
+func NewHierarchyResolver(global []Rule, plans PlanTable,
+	subjectFn func(*gin.Context) (Subject, bool)) func(*gin.Context) ([]Level, error) {
+	checkLevelRules("global", global)
+	for plan, quota := range plans {
+		checkLevelRules("plan "+strconv.Quote(plan)+" tenant", quota.Tenant)
+		checkLevelRules("plan "+strconv.Quote(plan)+" user", quota.User)
+	}
+	return func(ctx *gin.Context) ([]Level, error) {
+		levels := appendLevels(nil, "global", "{global}", global)
+		sub, ok := subjectFn(ctx)
+		if !ok {
+			return levels, nil
+		}
+		quota, ok := plans[sub.Plan]
+		if !ok {
+			quota = plans[""]
+		}
+		tenant := "{tenant:" + sub.Tenant + "}"
+		if len(global) > 0 {
+			tenant = "{global}:tenant:" + sub.Tenant
+		}
+		if sub.Tenant != "" {
+			levels = appendLevels(levels, "tenant", tenant, quota.Tenant)
+		}
+		if sub.User != "" {
+			levels = appendLevels(levels, "user", tenant+":user:"+sub.User, quota.User)
+		}
+		return levels, nil
+	}
+}
+
+// checkLevelRules 检查一个层级的限制, 无效时 panic.
+func checkLevelRules(level string, rules []Rule) {
+	for _, r := range rules {
+		if err := r.check(); err != nil {
+			panic(fmt.Sprintf("%s: %s", level, err))
+		}
+	}
+}
+
+func appendLevels(levels []Level, name, key string, rules []Rule) []Level {
+	for _, r := range rules {
+		r.Name = name + ":" + r.name()
+		levels = append(levels, Level{Key: key, Rule: r})
+	}
+	return levels
+}
+
+// HierarchyBuilder 分层限流中间件的构建器.
+// 与 Builder 不同, 每个请求由 resolveFn 解析出一组层级与额度, 例如全局、租户与用户.
+type HierarchyBuilder struct {
+	limiter   HierarchyLimiter
+	resolveFn func(*gin.Context) ([]Level, error)
+	costFn    func(ctx *gin.Context) int
+	logger    *slog.Logger
+	failure   ratelimit.FailurePolicy
+	fallback  HierarchyLimiter
+}
+
+// NewHierarchyBuilder
+// resolveFn: 解析请求的层级, 层级按顺序执行. 可以使用 NewHierarchyResolver 创建.
+// 返回错误时响应 500, 没有层级时不限流.
+func NewHierarchyBuilder(limiter HierarchyLimiter, resolveFn func(*gin.Context) ([]Level, error)) *HierarchyBuilder {
+	return &HierarchyBuilder{
+		limiter:   limiter,
+		resolveFn: resolveFn,
+		logger:    slog.Default(),
+	}
+}
+
+// SetCostFunc 设置请求的 cost, 即一个请求计为多少个请求. 默认为 1, 小于 1 时按 1 计算.
+func (b *HierarchyBuilder) SetCostFunc(fn func(*gin.Context) int) *HierarchyBuilder {
+	b.costFn = fn
+	return b
+}
+
+// SetFailurePolicy 设置限流器出现错误时的处理方式, 默认返回 500. resolveFn 的错误不使用失败策略.
+func (b *HierarchyBuilder) SetFailurePolicy(policy ratelimit.FailurePolicy) *HierarchyBuilder {
+	b.failure = policy
+	return b
+}
+
+// SetFallbackLimiter 设置 ratelimit.FailFallback 使用的备用限流器, 一般为 HierarchyMemoryLimiter.
+// 备用限流器使用相同的层级与额度, 每个实例独立计数, 需要时可以在 resolveFn 中按实例数缩小阈值.
+func (b *HierarchyBuilder) SetFallbackLimiter(limiter HierarchyLimiter) *HierarchyBuilder {
+	b.fallback = limiter
+	return b
+}
+
+func (b *HierarchyBuilder) SetLogger(logger *slog.Logger) *HierarchyBuilder {
+	b.logger = logger
+	return b
+}
+
+func (b *HierarchyBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limited, err := b.limit(ctx)
+		if err != nil {
+			b.logger.LogAttrs(ctx.Request.Context(), slog.LevelError,
+				"限流器出现错误", slog.Any("err", err))
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if limited {
+			ctx.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// BuildShadow 构建影子分层限流中间件, 只记录决策, 总是放行请求. 详见 ratelimit.ShadowOpts.
+// 日志中的 rule 为触发限流的层级.
+func (b *HierarchyBuilder) BuildShadow(opts ratelimit.ShadowOpts) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		levels, err := b.resolveFn(ctx)
+		var d ratelimit.Decision
+		if err == nil && len(levels) > 0 {
+			d, err = b.limiter.LimitLevels(ctx.Request.Context(), levels, b.cost(ctx))
+		}
+		opts.Record(ctx.Request.Context(), b.logger, "", d, err)
+		ctx.Next()
+	}
+}
+
+func (b *HierarchyBuilder) limit(ctx *gin.Context) (bool, error) {
+	levels, err := b.resolveFn(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(levels) == 0 {
+		return false, nil
+	}
+	cost := b.cost(ctx)
+	limitWith := func(limiter HierarchyLimiter) func() (bool, error) {
+		return func() (bool, error) {
+			d, err := limiter.LimitLevels(ctx.Request.Context(), levels, cost)
+			if err != nil {
+				return false, err
+			}
+			ratelimit.SetHeaders(ctx, d)
+			return d.Limited, nil
+		}
+	}
+	var fallback func() (bool, error)
+	if b.fallback != nil {
+		fallback = limitWith(b.fallback)
+	}
+	limited, _, err := b.failure.Do(ctx.Request.Context(), b.logger, limitWith(b.limiter), fallback)
+	return limited, err
+}
+
+func (b *HierarchyBuilder) cost(ctx *gin.Context) int {
+	return ratelimit.Cost(ctx, b.costFn)
+}
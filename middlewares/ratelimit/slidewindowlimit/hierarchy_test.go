@@ -0,0 +1,341 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+// subjectFromQuery 从查询参数中获取 Subject, 没有 plan 时视为匿名请求.
+func subjectFromQuery(ctx *gin.Context) (Subject, bool) {
+	plan, ok := ctx.GetQuery("plan")
+	if !ok {
+		return Subject{}, false
+	}
+	return Subject{Tenant: ctx.Query("tenant"), User: ctx.Query("user"), Plan: plan}, true
+}
+
+func TestHierarchyLimiter(t *testing.T) {
+	levels := func(tenant, user string) []Level {
+		return []Level{
+			{Key: "global", Rule: Rule{Name: "global", Window: time.Minute, Threshold: 3}},
+			{Key: "tenant:" + tenant, Rule: Rule{Name: "tenant", Window: time.Minute, Threshold: 2}},
+			{Key: "tenant:" + tenant + ":user:" + user, Rule: Rule{Name: "user", Window: time.Minute, Threshold: 1}},
+		}
+	}
+	type step struct {
+		advance time.Duration
+		tenant  string
+		user    string
+		// 只比较 Limited, Remaining 与 Name
+		want ratelimit.Decision
+	}
+	steps := []step{
+		{tenant: "a", user: "1", want: ratelimit.Decision{Remaining: 0, Name: "user"}},
+		// 触发用户的限制, 不消耗全局与租户的额度
+		{tenant: "a", user: "1", want: ratelimit.Decision{Limited: true, Remaining: 0, Name: "user"}},
+		{tenant: "a", user: "2", want: ratelimit.Decision{Remaining: 0, Name: "tenant"}},
+		{tenant: "a", user: "3", want: ratelimit.Decision{Limited: true, Remaining: 0, Name: "tenant"}},
+		{tenant: "b", user: "1", want: ratelimit.Decision{Remaining: 0, Name: "global"}},
+		{tenant: "c", user: "1", want: ratelimit.Decision{Limited: true, Remaining: 0, Name: "global"}},
+		{advance: time.Minute, tenant: "a", user: "1", want: ratelimit.Decision{Remaining: 0, Name: "user"}},
+	}
+	newLimiters := map[string]func(t *testing.T, now *time.Time) HierarchyLimiter{
+		"memory": func(t *testing.T, now *time.Time) HierarchyLimiter {
+			l := NewHierarchyMemoryLimiter()
+			l.timeFunc = func() time.Time { return *now }
+			return l
+		},
+		"redis": func(t *testing.T, now *time.Time) HierarchyLimiter {
+			_, client := newRedisClient(t)
+			l := NewHierarchyRedisLimiter(client, WithKeyPrefix("test:"))
+			l.base.timeFunc = func() time.Time { return *now }
+			return l
+		},
+	}
+	for name, newLimiter := range newLimiters {
+		t.Run(name, func(t *testing.T) {
+			now := time.UnixMilli(1695571200000)
+			l := newLimiter(t, &now)
+			for i, s := range steps {
+				now = now.Add(s.advance)
+				d, err := l.LimitLevels(context.Background(), levels(s.tenant, s.user), 1)
+				require.NoError(t, err)
+				got := ratelimit.Decision{Limited: d.Limited, Remaining: d.Remaining, Name: d.Name}
+				assert.Equal(t, s.want, got, "step %d", i)
+			}
+			d, err := l.LimitLevels(context.Background(), nil, 1)
+			require.NoError(t, err)
+			assert.False(t, d.Limited)
+		})
+	}
+}
+
+func TestHierarchyMemoryLimiter_Sweep(t *testing.T) {
+	now := time.Unix(1695571200, 0)
+	l := NewHierarchyMemoryLimiter(WithAlgorithm(AlgorithmApproximate))
+	l.timeFunc = func() time.Time { return now }
+	ctx := context.Background()
+	_, err := l.LimitLevels(ctx, []Level{
+		{Key: "a", Rule: Rule{Window: time.Second, Threshold: 10}},
+		{Key: "a", Rule: Rule{Window: time.Minute, Threshold: 100}},
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+
+	// 每个层级按自己的窗口清理
+	now = now.Add(time.Minute)
+	_, err = l.LimitLevels(ctx, []Level{{Key: "b", Rule: Rule{Window: time.Second, Threshold: 10}}}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Len())
+	now = now.Add(time.Minute)
+	_, err = l.LimitLevels(ctx, []Level{{Key: "b", Rule: Rule{Window: time.Second, Threshold: 10}}}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, l.Len())
+}
+
+func TestHierarchyRedisLimiter_Error(t *testing.T) {
+	mr, client := newRedisClient(t)
+	l := NewHierarchyRedisLimiter(client)
+	_, err := l.LimitLevels(context.Background(),
+		[]Level{{Key: "global", Rule: Rule{Name: "global", Window: time.Second, Threshold: 1}}}, 1)
+	require.NoError(t, err)
+	assert.True(t, mr.Exists("ginx:slidewindow:global:global"))
+
+	mr.Close()
+	_, err = l.LimitLevels(context.Background(),
+		[]Level{{Key: "global", Rule: Rule{Name: "global", Window: time.Second, Threshold: 1}}}, 1)
+	assert.Error(t, err)
+}
+
+func TestNewHierarchyResolver(t *testing.T) {
+	global := []Rule{{Name: "per_second", Window: time.Second, Threshold: 1000}}
+	plans := PlanTable{
+		"": {
+			Tenant: []Rule{{Window: time.Hour, Threshold: 100}},
+		},
+		"pro": {
+			Tenant: []Rule{{Name: "per_day", Window: 24 * time.Hour, Threshold: 10000}},
+			User:   []Rule{{Name: "per_second", Window: time.Second, Threshold: 10}},
+		},
+	}
+	resolve := NewHierarchyResolver(global, plans, subjectFromQuery)
+	tests := []struct {
+		name   string
+		target string
+		want   []Level
+	}{
+		{
+			// 匿名请求只执行全局限制
+			name:   "anonymous",
+			target: "/",
+			want: []Level{
+				{Key: "{global}", Rule: Rule{Name: "global:per_second", Window: time.Second, Threshold: 1000}},
+			},
+		},
+		{
+			name:   "pro",
+			target: "/?tenant=acme&user=alice&plan=pro",
+			want: []Level{
+				{Key: "{global}", Rule: Rule{Name: "global:per_second", Window: time.Second, Threshold: 1000}},
+				{Key: "{global}:tenant:acme", Rule: Rule{Name: "tenant:per_day", Window: 24 * time.Hour, Threshold: 10000}},
+				{Key: "{global}:tenant:acme:user:alice", Rule: Rule{Name: "user:per_second", Window: time.Second, Threshold: 10}},
+			},
+		},
+		{
+			// 未知的套餐使用默认的额度
+			name:   "default_plan",
+			target: "/?tenant=acme&user=alice&plan=unknown",
+			want: []Level{
+				{Key: "{global}", Rule: Rule{Name: "global:per_second", Window: time.Second, Threshold: 1000}},
+				{Key: "{global}:tenant:acme", Rule: Rule{Name: "tenant:100;w=3600", Window: time.Hour, Threshold: 100}},
+			},
+		},
+		{
+			// 没有租户时跳过租户层级
+			name:   "no_tenant",
+			target: "/?user=alice&plan=pro",
+			want: []Level{
+				{Key: "{global}", Rule: Rule{Name: "global:per_second", Window: time.Second, Threshold: 1000}},
+				{Key: "{global}:tenant::user:alice", Rule: Rule{Name: "user:per_second", Window: time.Second, Threshold: 10}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, tt.target, nil)
+			got, err := resolve(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewHierarchyResolver_InvalidRules(t *testing.T) {
+	valid := []Rule{{Name: "per_second", Window: time.Second, Threshold: 10}}
+	tests := []struct {
+		name   string
+		global []Rule
+		plans  PlanTable
+	}{
+		{name: "global_zero_window", global: []Rule{{Name: "per_second", Threshold: 10}}},
+		{name: "tenant_sub_millisecond_window", global: valid, plans: PlanTable{"pro": {
+			Tenant: []Rule{{Name: "per_second", Window: time.Microsecond, Threshold: 10}},
+		}}},
+		{name: "user_zero_threshold", plans: PlanTable{"": {
+			Tenant: valid,
+			User:   []Rule{{Name: "per_second", Window: time.Second}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Panics(t, func() { NewHierarchyResolver(tt.global, tt.plans, subjectFromQuery) })
+		})
+	}
+}
+
+func TestHierarchyLimiter_InvalidLevels(t *testing.T) {
+	mr, client := newRedisClient(t)
+	limiters := map[string]HierarchyLimiter{
+		"memory": NewHierarchyMemoryLimiter(),
+		"redis":  NewHierarchyRedisLimiter(client),
+	}
+	levels := [][]Level{
+		{{Key: "global", Rule: Rule{Name: "global", Threshold: 1}}},
+		{{Key: "global", Rule: Rule{Name: "global", Window: time.Microsecond, Threshold: 1}}},
+		{
+			{Key: "global", Rule: Rule{Name: "global", Window: time.Second, Threshold: 1}},
+			{Key: "tenant", Rule: Rule{Name: "tenant", Window: time.Second}},
+		},
+	}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			for i, lvs := range levels {
+				d, err := l.LimitLevels(context.Background(), lvs, 1)
+				assert.Error(t, err, "levels %d", i)
+				assert.Equal(t, ratelimit.Decision{}, d)
+			}
+		})
+	}
+	assert.Empty(t, mr.Keys())
+}
+
+func TestNewHierarchyResolver_NoGlobal(t *testing.T) {
+	plans := PlanTable{"": {
+		Tenant: []Rule{{Name: "per_day", Window: 24 * time.Hour, Threshold: 10000}},
+		User:   []Rule{{Name: "per_second", Window: time.Second, Threshold: 10}},
+	}}
+	resolve := NewHierarchyResolver(nil, plans, subjectFromQuery)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/?tenant=acme&user=alice&plan=", nil)
+	got, err := resolve(ctx)
+	require.NoError(t, err)
+	// 没有全局限制时按租户使用 hash tag
+	assert.Equal(t, []Level{
+		{Key: "{tenant:acme}", Rule: Rule{Name: "tenant:per_day", Window: 24 * time.Hour, Threshold: 10000}},
+		{Key: "{tenant:acme}:user:alice", Rule: Rule{Name: "user:per_second", Window: time.Second, Threshold: 10}},
+	}, got)
+}
+
+func TestHierarchyRedisLimiter_HashTag(t *testing.T) {
+	_, client := newRedisClient(t)
+	l := NewHierarchyRedisLimiter(client)
+	rule := Rule{Window: time.Second, Threshold: 10}
+	tests := []struct {
+		name    string
+		keys    []string
+		wantErr bool
+	}{
+		// 全部没有 hash tag 时不检查
+		{name: "untagged", keys: []string{"global", "tenant:acme"}},
+		{name: "same_tag", keys: []string{"{global}", "{global}:tenant:acme"}},
+		{name: "different_tag", keys: []string{"{global}", "{tenant:acme}"}, wantErr: true},
+		{name: "partially_tagged", keys: []string{"{global}", "tenant:acme"}, wantErr: true},
+		// 空的 {} 不是 hash tag
+		{name: "empty_tag", keys: []string{"{}global", "{}tenant"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels := make([]Level, len(tt.keys))
+			for i, k := range tt.keys {
+				levels[i] = Level{Key: k, Rule: rule}
+			}
+			_, err := l.LimitLevels(context.Background(), levels, 1)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestHierarchyBuilder_Build(t *testing.T) {
+	plans := PlanTable{
+		"free": {Tenant: []Rule{{Name: "per_minute", Window: time.Minute, Threshold: 2}}},
+		"pro":  {Tenant: []Rule{{Name: "per_minute", Window: time.Minute, Threshold: 100}}},
+	}
+	resolve := NewHierarchyResolver(nil, plans, subjectFromQuery)
+	server := gin.New()
+	server.Use(NewHierarchyBuilder(NewHierarchyMemoryLimiter(), func(ctx *gin.Context) ([]Level, error) {
+		if ctx.Query("plan") == "error" {
+			return nil, errors.New("mock error")
+		}
+		return resolve(ctx)
+	}).SetCostFunc(func(ctx *gin.Context) int {
+		if ctx.Query("cost") != "" {
+			return 2
+		}
+		return 1
+	}).Build())
+	server.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	tests := []struct {
+		name          string
+		target        string
+		wantCode      int
+		wantRemaining string
+	}{
+		{name: "free_1", target: "/?tenant=a&plan=free", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "free_cost", target: "/?tenant=a&plan=free&cost=1", wantCode: http.StatusTooManyRequests, wantRemaining: "1"},
+		{name: "free_2", target: "/?tenant=a&plan=free", wantCode: http.StatusOK, wantRemaining: "0"},
+		{name: "free_limited", target: "/?tenant=a&plan=free", wantCode: http.StatusTooManyRequests, wantRemaining: "0"},
+		// 租户升级套餐后额度立即生效, 已经计入的请求保留
+		{name: "pro", target: "/?tenant=a&plan=pro", wantCode: http.StatusOK, wantRemaining: "97"},
+		{name: "other_tenant", target: "/?tenant=b&plan=free", wantCode: http.StatusOK, wantRemaining: "1"},
+		// 没有层级时不限流
+		{name: "no_levels", target: "/?plan=free", wantCode: http.StatusOK},
+		{name: "resolve_error", target: "/?plan=error", wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantRemaining, recorder.Header().Get(ratelimit.HeaderRemaining))
+		})
+	}
+}
+
+func TestHierarchyBuilder_BuildShadow(t *testing.T) {
+	server := gin.New()
+	server.Use(NewHierarchyBuilder(NewHierarchyMemoryLimiter(), func(*gin.Context) ([]Level, error) {
+		return []Level{{Key: "global", Rule: Rule{Name: "global", Window: time.Minute, Threshold: 1}}}, nil
+	}).BuildShadow(ratelimit.ShadowOpts{Name: "quota"}))
+	server.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, "request %d", i)
+		assert.Empty(t, recorder.Header().Get(ratelimit.HeaderLimit), "request %d", i)
+	}
+}
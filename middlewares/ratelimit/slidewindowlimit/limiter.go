@@ -1,7 +1,28 @@
 package slidewindowlimit
 
-import "context"
+import (
+	"context"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
 
 type Limiter interface {
 	Limit(ctx context.Context, key string) (bool, error)
 }
+
+// DecisionLimiter 可选的 Limiter 接口, 返回限流的额度信息.
+// Builder 使用实现了该接口的 Limiter 时, 会设置 RateLimit-* 响应头, 限流时设置 Retry-After.
+type DecisionLimiter interface {
+	Limiter
+	LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error)
+}
+
+// CostLimiter 可选的 Limiter 接口, 支持一个请求计为多个请求.
+// Builder 通过 SetCostFunc 设置了请求的 cost 时, Limiter 需要实现该接口.
+type CostLimiter interface {
+	DecisionLimiter
+
+	// LimitN 判断 n 个请求是否限流, 不限流时原子地计入 n 个请求.
+	// n 超过阈值时总是限流.
+	LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error)
+}
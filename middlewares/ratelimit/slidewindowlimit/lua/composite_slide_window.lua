@@ -0,0 +1,51 @@
+-- KEYS[i]: 第 i 个限制的 key
+-- ARGV[1]: 当前时间 (毫秒)
+-- ARGV[2]: 本次请求的成员前缀, 需要唯一
+-- ARGV[3]: 本次计入的请求数
+-- ARGV[2+2i]: 第 i 个限制的窗口大小 (毫秒)
+-- ARGV[3+2i]: 第 i 个限制窗口内允许的请求数
+-- 全部限制都通过时才计入请求, 否则不计入任何限制.
+-- 依次返回每个限制的 {是否限流 (1 表示限流, 0 表示通过), 窗口内的请求数, 需要滑出窗口的最后一个请求的时间, 最新请求的时间}
+-- 没有对应的请求时返回 now - window
+local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[3])
+
+local res = {}
+local limited = false
+for i, key in ipairs(KEYS) do
+    local window = tonumber(ARGV[2 + 2 * i])
+    local threshold = tonumber(ARGV[3 + 2 * i])
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+    local cnt = redis.call('ZCARD', key)
+    local over = cnt + n - threshold
+    local retryAt = now - window
+    local l = 0
+    if n > threshold then
+        l = 1
+    elseif over > 0 then
+        l = 1
+        retryAt = tonumber(redis.call('ZRANGE', key, over - 1, over - 1, 'WITHSCORES')[2])
+    end
+    if l == 1 then
+        limited = true
+    end
+    local base = 4 * (i - 1)
+    res[base + 1] = l
+    res[base + 2] = cnt
+    res[base + 3] = retryAt
+end
+
+for i, key in ipairs(KEYS) do
+    local window = tonumber(ARGV[2 + 2 * i])
+    local base = 4 * (i - 1)
+    if not limited then
+        for j = 1, n do
+            redis.call('ZADD', key, now, ARGV[2] .. ':' .. j)
+        end
+        redis.call('PEXPIRE', key, window)
+        res[base + 2] = res[base + 2] + n
+    end
+    local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+    res[base + 4] = tonumber(newest[2] or (now - window))
+end
+return res
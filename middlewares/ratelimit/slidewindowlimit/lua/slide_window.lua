@@ -0,0 +1,34 @@
+-- KEYS[1]: 限流的 key
+-- ARGV[1]: 窗口大小 (毫秒)
+-- ARGV[2]: 窗口内允许的请求数
+-- ARGV[3]: 当前时间 (毫秒)
+-- ARGV[4]: 本次请求的成员前缀, 需要唯一
+-- ARGV[5]: 本次计入的请求数
+-- 返回 {是否限流 (1 表示限流, 0 表示通过), 窗口内的请求数, 需要滑出窗口的最后一个请求的时间, 最新请求的时间}
+-- 没有对应的请求时返回 now - window
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local threshold = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[5])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local cnt = redis.call('ZCARD', key)
+local over = cnt + n - threshold
+local limited = 1
+local retryAt = now - window
+if n > threshold then
+    -- 超过阈值的请求总是限流
+elseif over > 0 then
+    -- 最早的 over 个请求滑出窗口后可以重试
+    retryAt = tonumber(redis.call('ZRANGE', key, over - 1, over - 1, 'WITHSCORES')[2])
+else
+    for i = 1, n do
+        redis.call('ZADD', key, now, ARGV[4] .. ':' .. i)
+    end
+    redis.call('PEXPIRE', key, window)
+    cnt = cnt + n
+    limited = 0
+end
+local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+return {limited, cnt, retryAt, tonumber(newest[2] or (now - window))}
@@ -0,0 +1,252 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+// Algorithm 滑动窗口的计数算法.
+type Algorithm int
+
+const (
+	// AlgorithmExact 精确算法. 每个 key 记录窗口内每个请求的时间戳,
+	// 内存占用与阈值成正比.
+	AlgorithmExact Algorithm = iota
+	// AlgorithmApproximate 近似算法. 每个 key 只记录当前与上一个固定窗口的计数,
+	// 按当前窗口已经过的比例对上一个窗口的计数加权. 内存占用固定.
+	AlgorithmApproximate
+)
+
+const shardCount = 32
+
+var _ CostLimiter = (*MemoryLimiter)(nil)
+
+// MemoryLimiter 基于内存的滑动窗口限流器.
+// 在任意 window 时间内每个 key 最多允许 threshold 个请求.
+// 超过 2 个窗口没有请求的 key 会被清理, 不需要后台 goroutine. 并发安全.
+type MemoryLimiter struct {
+	window    time.Duration
+	threshold int
+	algorithm Algorithm
+	seed      maphash.Seed
+	shards    [shardCount]*shard
+	// lastSweep 上一次清理的时间 (Unix 纳秒).
+	lastSweep atomic.Int64
+	timeFunc  func() time.Time
+}
+
+type shard struct {
+	mu   sync.Mutex
+	keys map[string]*windowState
+}
+
+// windowState 定义一个 key 的窗口状态.
+type windowState struct {
+	lastSeen time.Time
+
+	// 精确算法: 窗口内请求的时间戳, 按时间排序.
+	timestamps []time.Time
+
+	// 近似算法: 当前固定窗口的开始时间与计数, 以及上一个固定窗口的计数.
+	start     time.Time
+	count     int
+	prevCount int
+}
+
+// MemoryOption 定义 MemoryLimiter 的选项.
+type MemoryOption func(*MemoryLimiter)
+
+// WithAlgorithm 设置计数算法. 默认为 AlgorithmExact.
+func WithAlgorithm(algorithm Algorithm) MemoryOption {
+	return func(l *MemoryLimiter) {
+		l.algorithm = algorithm
+	}
+}
+
+// NewMemoryLimiter 创建一个基于内存的滑动窗口限流器.
+// window: 窗口大小; threshold: 窗口内允许的请求数. window 或者 threshold 不大于 0 时 panic.
+func NewMemoryLimiter(window time.Duration, threshold int, opts ...MemoryOption) *MemoryLimiter {
+	if window <= 0 {
+		panic(fmt.Sprintf("slidewindowlimit: 无效的窗口大小 %s", window))
+	}
+	if threshold <= 0 {
+		panic(fmt.Sprintf("slidewindowlimit: 无效的阈值 %d", threshold))
+	}
+	l := &MemoryLimiter{
+		window:    window,
+		threshold: threshold,
+		algorithm: AlgorithmExact,
+		seed:      maphash.MakeSeed(),
+		timeFunc:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	for i := range l.shards {
+		l.shards[i] = &shard{keys: make(map[string]*windowState)}
+	}
+	return l
+}
+
+func (l *MemoryLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+func (l *MemoryLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+// LimitN 判断 n 个请求是否限流, 不限流时原子地计入 n 个请求.
+// n 超过阈值时总是限流.
+func (l *MemoryLimiter) LimitN(_ context.Context, key string, n int) (ratelimit.Decision, error) {
+	now := l.timeFunc()
+	l.sweep(now)
+	s := l.shards[maphash.String(l.seed, key)%shardCount]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.keys[key]
+	if !ok {
+		state = &windowState{start: now}
+		s.keys[key] = state
+	}
+	state.lastSeen = now
+	d := l.decide(state, now, n)
+	if !d.Limited {
+		l.consume(state, now, n)
+		d = l.decide(state, now, 0)
+	}
+	return d, nil
+}
+
+// Len 返回当前保存状态的 key 数量.
+func (l *MemoryLimiter) Len() int {
+	var n int
+	for _, s := range l.shards {
+		s.mu.Lock()
+		n += len(s.keys)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// decide 判断 n 个请求是否限流, 不计入请求. 需要持有锁.
+func (l *MemoryLimiter) decide(state *windowState, now time.Time, n int) ratelimit.Decision {
+	if l.algorithm == AlgorithmApproximate {
+		return l.decideApproximate(state, now, n)
+	}
+	return l.decideExact(state, now, n)
+}
+
+// consume 计入 n 个请求. 需要持有锁, 并且在 decide 之后调用.
+func (l *MemoryLimiter) consume(state *windowState, now time.Time, n int) {
+	if l.algorithm == AlgorithmApproximate {
+		state.count += n
+		return
+	}
+	for i := 0; i < n; i++ {
+		state.timestamps = append(state.timestamps, now)
+	}
+}
+
+func (l *MemoryLimiter) decideExact(state *windowState, now time.Time, n int) ratelimit.Decision {
+	boundary := now.Add(-l.window)
+	i := 0
+	for i < len(state.timestamps) && !state.timestamps[i].After(boundary) {
+		i++
+	}
+	// 复用底层数组, 内存占用不超过 threshold
+	if i > 0 {
+		cnt := copy(state.timestamps, state.timestamps[i:])
+		state.timestamps = state.timestamps[:cnt]
+	}
+	d := ratelimit.Decision{
+		Limit:     l.threshold,
+		Remaining: l.threshold - len(state.timestamps),
+		Window:    l.window,
+	}
+	if cnt := len(state.timestamps); cnt > 0 {
+		d.Reset = state.timestamps[cnt-1].Add(l.window).Sub(now)
+	}
+	switch over := len(state.timestamps) + n - l.threshold; {
+	case n > l.threshold:
+		d.Limited = true
+	case over > 0:
+		d.Limited = true
+		// 最早的 over 个请求滑出窗口后可以重试
+		d.RetryAfter = state.timestamps[over-1].Add(l.window).Sub(now)
+	}
+	return d
+}
+
+func (l *MemoryLimiter) decideApproximate(state *windowState, now time.Time, n int) ratelimit.Decision {
+	elapsed := now.Sub(state.start)
+	if elapsed >= l.window {
+		windows := elapsed / l.window
+		if windows == 1 {
+			state.prevCount = state.count
+		} else {
+			state.prevCount = 0
+		}
+		state.count = 0
+		state.start = state.start.Add(windows * l.window)
+		elapsed -= windows * l.window
+	}
+	weight := float64(l.window-elapsed) / float64(l.window)
+	estimate := float64(state.prevCount)*weight + float64(state.count)
+	d := ratelimit.Decision{
+		Limit:     l.threshold,
+		Remaining: int(float64(l.threshold) - estimate),
+		Window:    l.window,
+		// 当前窗口的计数在下一个窗口结束时不再有影响
+		Reset: state.start.Add(2 * l.window).Sub(now),
+	}
+	// 与单个请求相同, 加权计数加上其余 n-1 个请求后低于阈值时通过
+	threshold := float64(l.threshold - n + 1)
+	switch {
+	case n > l.threshold:
+		d.Limited = true
+	case estimate >= threshold:
+		d.Limited = true
+		d.RetryAfter = l.retryAfter(state, now, threshold)
+	}
+	return d
+}
+
+// retryAfter 计算近似算法中加权计数降到 threshold 以下的时间.
+func (l *MemoryLimiter) retryAfter(state *windowState, now time.Time, threshold float64) time.Duration {
+	window := float64(l.window)
+	if float64(state.count) < threshold {
+		// 在当前窗口内, 上一个窗口的权重降到 (threshold-count)/prevCount 以下
+		at := window * (1 - (threshold-float64(state.count))/float64(state.prevCount))
+		return state.start.Add(time.Duration(at)).Sub(now)
+	}
+	// 在下一个窗口内, 当前窗口的权重降到 threshold/count 以下
+	at := window * (1 - threshold/float64(state.count))
+	return state.start.Add(l.window + time.Duration(at)).Sub(now)
+}
+
+// sweep 每隔一个窗口清理超过 2 个窗口没有请求的 key.
+// 此时精确算法的时间戳与近似算法的两个计数都已经不再影响结果.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	last := l.lastSweep.Load()
+	if now.UnixNano()-last < int64(l.window) || !l.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	for _, s := range l.shards {
+		s.mu.Lock()
+		for key, state := range s.keys {
+			if now.Sub(state.lastSeen) >= 2*l.window {
+				delete(s.keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
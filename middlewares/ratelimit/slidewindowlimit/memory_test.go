@@ -0,0 +1,295 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func TestNewMemoryLimiter(t *testing.T) {
+	assert.Panics(t, func() { NewMemoryLimiter(0, 1) })
+	assert.Panics(t, func() { NewMemoryLimiter(-time.Second, 1) })
+	assert.Panics(t, func() { NewMemoryLimiter(time.Second, 0) })
+	assert.Panics(t, func() { NewMemoryLimiter(time.Second, -1, WithAlgorithm(AlgorithmApproximate)) })
+	assert.NotPanics(t, func() { NewMemoryLimiter(time.Second, 1) })
+}
+
+func TestMemoryLimiter_Limit(t *testing.T) {
+	type step struct {
+		// advance 请求前经过的时间
+		advance time.Duration
+		key     string
+		want    bool
+	}
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "exact",
+			algorithm: AlgorithmExact,
+			threshold: 2,
+			steps: []step{
+				{key: "a"},
+				{advance: 400 * time.Millisecond, key: "a"},
+				{advance: 400 * time.Millisecond, key: "a", want: true},
+				// 其他 key 不受影响
+				{key: "b"},
+				// 第一个请求滑出窗口
+				{advance: 200 * time.Millisecond, key: "a"},
+				{key: "a", want: true},
+				{advance: 400 * time.Millisecond, key: "a"},
+			},
+		},
+		{
+			name:      "approximate",
+			algorithm: AlgorithmApproximate,
+			threshold: 4,
+			steps: []step{
+				{key: "a"},
+				{key: "a"},
+				{key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+				// 下一个窗口过了 1/4, 上一个窗口的计数按 3/4 计算: 4*0.75=3
+				{advance: 1250 * time.Millisecond, key: "a"},
+				{key: "a", want: true},
+				// 过了 3/4: 4*0.25+1=2
+				{advance: 500 * time.Millisecond, key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+				// 超过 2 个窗口, 计数清零
+				{advance: 2 * time.Second, key: "a"},
+				{key: "a"},
+				{key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1695571200, 0)
+			l := NewMemoryLimiter(time.Second, tt.threshold, WithAlgorithm(tt.algorithm))
+			l.timeFunc = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				limited, err := l.Limit(context.Background(), s.key)
+				require.NoError(t, err)
+				assert.Equal(t, s.want, limited, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter_LimitDecision(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		want    ratelimit.Decision
+	}
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "exact",
+			algorithm: AlgorithmExact,
+			threshold: 2,
+			steps: []step{
+				{want: ratelimit.Decision{Limit: 2, Remaining: 1, Window: time.Second, Reset: time.Second}},
+				{
+					advance: 400 * time.Millisecond,
+					want:    ratelimit.Decision{Limit: 2, Remaining: 0, Window: time.Second, Reset: time.Second},
+				},
+				{
+					// 第一个请求在 200ms 后滑出窗口, 最后一个请求在 600ms 后滑出窗口
+					advance: 400 * time.Millisecond,
+					want: ratelimit.Decision{Limited: true, Limit: 2, Remaining: 0, Window: time.Second,
+						Reset: 600 * time.Millisecond, RetryAfter: 200 * time.Millisecond},
+				},
+			},
+		},
+		{
+			name:      "approximate",
+			algorithm: AlgorithmApproximate,
+			threshold: 2,
+			steps: []step{
+				{want: ratelimit.Decision{Limit: 2, Remaining: 1, Window: time.Second, Reset: 2 * time.Second}},
+				{
+					advance: 500 * time.Millisecond,
+					want: ratelimit.Decision{Limit: 2, Remaining: 0, Window: time.Second,
+						Reset: 1500 * time.Millisecond},
+				},
+				{
+					// 下一个窗口中上一个窗口的权重降到 2/2 以下, 即下一个窗口开始后
+					want: ratelimit.Decision{Limited: true, Limit: 2, Remaining: 0, Window: time.Second,
+						Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond},
+				},
+				{
+					// 上一个窗口的计数按 3/4 计算: 2*0.75=1.5
+					advance: 750 * time.Millisecond,
+					want: ratelimit.Decision{Limit: 2, Remaining: 0, Window: time.Second,
+						Reset: 1750 * time.Millisecond},
+				},
+				{
+					// 2*0.75+1=2.5, 上一个窗口的权重降到 1/2 以下后可以重试
+					want: ratelimit.Decision{Limited: true, Limit: 2, Remaining: 0, Window: time.Second,
+						Reset: 1750 * time.Millisecond, RetryAfter: 250 * time.Millisecond},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1695571200, 0)
+			l := NewMemoryLimiter(time.Second, tt.threshold, WithAlgorithm(tt.algorithm))
+			l.timeFunc = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				d, err := l.LimitDecision(context.Background(), "key")
+				require.NoError(t, err)
+				assert.Equal(t, s.want, d, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter_LimitN(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		n       int
+		want    ratelimit.Decision
+	}
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		steps     []step
+	}{
+		{
+			name:      "exact",
+			algorithm: AlgorithmExact,
+			steps: []step{
+				{n: 3, want: ratelimit.Decision{Limit: 5, Remaining: 2, Window: time.Second, Reset: time.Second}},
+				{
+					advance: 400 * time.Millisecond,
+					n:       2,
+					want:    ratelimit.Decision{Limit: 5, Remaining: 0, Window: time.Second, Reset: time.Second},
+				},
+				{
+					// 需要最早的 3 个请求滑出窗口
+					n: 3,
+					want: ratelimit.Decision{Limited: true, Limit: 5, Remaining: 0, Window: time.Second,
+						Reset: time.Second, RetryAfter: 600 * time.Millisecond},
+				},
+				{
+					// 需要最早的 4 个请求滑出窗口
+					n: 4,
+					want: ratelimit.Decision{Limited: true, Limit: 5, Remaining: 0, Window: time.Second,
+						Reset: time.Second, RetryAfter: time.Second},
+				},
+				{
+					// 超过阈值
+					advance: time.Second,
+					n:       6,
+					want:    ratelimit.Decision{Limited: true, Limit: 5, Remaining: 5, Window: time.Second},
+				},
+				{n: 5, want: ratelimit.Decision{Limit: 5, Remaining: 0, Window: time.Second, Reset: time.Second}},
+			},
+		},
+		{
+			name:      "approximate",
+			algorithm: AlgorithmApproximate,
+			steps: []step{
+				{n: 3, want: ratelimit.Decision{Limit: 5, Remaining: 2, Window: time.Second, Reset: 2 * time.Second}},
+				{
+					// 3+2>=5, 下一个窗口开始后上一个窗口的计数降到 3 以下
+					n: 3,
+					want: ratelimit.Decision{Limited: true, Limit: 5, Remaining: 2, Window: time.Second,
+						Reset: 2 * time.Second, RetryAfter: time.Second},
+				},
+				{
+					// 上一个窗口的计数按 0.6 计算: 3*0.6+2<5
+					advance: 1400 * time.Millisecond,
+					n:       3,
+					want: ratelimit.Decision{Limit: 5, Remaining: 0, Window: time.Second,
+						Reset: 1600 * time.Millisecond},
+				},
+				{
+					n: 6,
+					want: ratelimit.Decision{Limited: true, Limit: 5, Remaining: 0, Window: time.Second,
+						Reset: 1600 * time.Millisecond},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1695571200, 0)
+			l := NewMemoryLimiter(time.Second, 5, WithAlgorithm(tt.algorithm))
+			l.timeFunc = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				d, err := l.LimitN(context.Background(), "key", s.n)
+				require.NoError(t, err)
+				assert.Equal(t, s.want, d, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter_Sweep(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmExact, AlgorithmApproximate} {
+		now := time.Unix(1695571200, 0)
+		l := NewMemoryLimiter(time.Second, 10, WithAlgorithm(algorithm))
+		l.timeFunc = func() time.Time { return now }
+		ctx := context.Background()
+		for i := 0; i < 100; i++ {
+			_, err := l.Limit(ctx, strconv.Itoa(i))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 100, l.Len())
+
+		// 空闲的 key 在超过 2 个窗口后被清理
+		now = now.Add(2 * time.Second)
+		for i := 0; i < 100; i++ {
+			_, err := l.Limit(ctx, "active")
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 1, l.Len())
+	}
+}
+
+func TestMemoryLimiter_Concurrent(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmExact, AlgorithmApproximate} {
+		l := NewMemoryLimiter(time.Hour, 100, WithAlgorithm(algorithm))
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					limited, err := l.Limit(context.Background(), "key")
+					assert.NoError(t, err)
+					if !limited {
+						allowed.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(100), allowed.Load())
+	}
+}
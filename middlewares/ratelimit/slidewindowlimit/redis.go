@@ -0,0 +1,129 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+//go:embed lua/slide_window.lua
+var slideWindowLua string
+
+var slideWindowScript = redis.NewScript(slideWindowLua)
+
+var _ CostLimiter = (*RedisLimiter)(nil)
+
+// RedisLimiter 基于 Redis 的滑动窗口限流器, 可以在多个实例之间共享.
+// 每个 key 使用一个有序集合记录窗口内的请求, 通过 Lua 脚本在一次往返中
+// 完成 ZREMRANGEBYSCORE/ZCARD/ZADD/PEXPIRE.
+type RedisLimiter struct {
+	client    redis.Cmdable
+	prefix    string
+	window    time.Duration
+	threshold int
+	// instance 与 seq 组成有序集合中唯一的成员, 避免同一毫秒内的请求相互覆盖.
+	instance string
+	seq      atomic.Uint64
+	timeFunc func() time.Time
+}
+
+// RedisOption 定义 RedisLimiter 的选项.
+type RedisOption func(*RedisLimiter)
+
+// WithKeyPrefix 设置 Redis key 的前缀. 默认为 "ginx:slidewindow:".
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(l *RedisLimiter) {
+		l.prefix = prefix
+	}
+}
+
+// NewRedisLimiter 创建一个基于 Redis 的滑动窗口限流器.
+// window: 窗口大小, 精度为毫秒; threshold: 窗口内允许的请求数.
+// window 不足 1 毫秒或者 threshold 不大于 0 时 panic.
+func NewRedisLimiter(client redis.Cmdable, window time.Duration, threshold int,
+	opts ...RedisOption) *RedisLimiter {
+	if window < time.Millisecond {
+		panic(fmt.Sprintf("slidewindowlimit: 无效的窗口大小 %s, 需要至少 1 毫秒", window))
+	}
+	if threshold <= 0 {
+		panic(fmt.Sprintf("slidewindowlimit: 无效的阈值 %d", threshold))
+	}
+	return newRedisLimiter(client, window, threshold, opts...)
+}
+
+// newRedisLimiter 创建 RedisLimiter, 不检查窗口与阈值.
+// 组合与分层限流器使用窗口与阈值为 0 的 RedisLimiter 保存客户端、key 前缀与成员的生成方式.
+func newRedisLimiter(client redis.Cmdable, window time.Duration, threshold int,
+	opts ...RedisOption) *RedisLimiter {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	l := &RedisLimiter{
+		client:    client,
+		prefix:    "ginx:slidewindow:",
+		window:    window,
+		threshold: threshold,
+		instance:  hex.EncodeToString(b),
+		timeFunc:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Limit 判断是否限流.
+// Redis 出现错误时返回错误, 由调用方决定如何处理, 不会 panic.
+func (l *RedisLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	d, err := l.LimitDecision(ctx, key)
+	return d.Limited, err
+}
+
+func (l *RedisLimiter) LimitDecision(ctx context.Context, key string) (ratelimit.Decision, error) {
+	return l.LimitN(ctx, key, 1)
+}
+
+// LimitN 判断 n 个请求是否限流, 不限流时原子地计入 n 个请求.
+// n 超过阈值时总是限流.
+func (l *RedisLimiter) LimitN(ctx context.Context, key string, n int) (ratelimit.Decision, error) {
+	now := l.timeFunc().UnixMilli()
+	res, err := slideWindowScript.Run(ctx, l.client, []string{l.prefix + key},
+		l.window.Milliseconds(), l.threshold, now, l.member(now), n).Int64Slice()
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("redis 滑动窗口限流失败: %w", err)
+	}
+	if len(res) != 4 {
+		return ratelimit.Decision{}, fmt.Errorf("redis 滑动窗口限流失败: 无效的返回值 %v", res)
+	}
+	return redisDecision(res, l.window, l.threshold, now), nil
+}
+
+// member 返回本次请求在有序集合中唯一的成员前缀.
+func (l *RedisLimiter) member(now int64) string {
+	return strconv.FormatInt(now, 10) + ":" + l.instance + ":" +
+		strconv.FormatUint(l.seq.Add(1), 10)
+}
+
+// redisDecision 根据 Lua 脚本返回的 {是否限流, 窗口内的请求数, 可以重试的时间, 最新请求的时间} 得到决策.
+func redisDecision(res []int64, window time.Duration, threshold int, now int64) ratelimit.Decision {
+	ms := window.Milliseconds()
+	d := ratelimit.Decision{
+		Limited:   res[0] == 1,
+		Limit:     threshold,
+		Remaining: threshold - int(res[1]),
+		Window:    window,
+		Reset:     time.Duration(res[3]+ms-now) * time.Millisecond,
+	}
+	if d.Limited {
+		d.RetryAfter = max(time.Duration(res[2]+ms-now)*time.Millisecond, 0)
+	}
+	return d
+}
@@ -0,0 +1,206 @@
+package slidewindowlimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/udugong/ginx/middlewares/ratelimit"
+)
+
+func newRedisClient(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func TestNewRedisLimiter(t *testing.T) {
+	_, client := newRedisClient(t)
+	assert.Panics(t, func() { NewRedisLimiter(client, 0, 1) })
+	// 窗口的精度为毫秒
+	assert.Panics(t, func() { NewRedisLimiter(client, time.Microsecond, 1) })
+	assert.Panics(t, func() { NewRedisLimiter(client, time.Second, 0) })
+	assert.NotPanics(t, func() { NewRedisLimiter(client, time.Millisecond, 1) })
+	// 组合与分层限流器不受影响
+	assert.NotPanics(t, func() { NewHierarchyRedisLimiter(client) })
+}
+
+func TestRedisLimiter_Limit(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "normal",
+			steps: []step{
+				{key: "a"},
+				{advance: 400 * time.Millisecond, key: "a"},
+				{advance: 400 * time.Millisecond, key: "a", want: true},
+				// 其他 key 不受影响
+				{key: "b"},
+				// 第一个请求滑出窗口
+				{advance: 200 * time.Millisecond, key: "a"},
+				{key: "a", want: true},
+				{advance: 400 * time.Millisecond, key: "a"},
+			},
+		},
+		{
+			// 同一毫秒内的请求分别计数
+			name: "same_millisecond",
+			steps: []step{
+				{key: "a"},
+				{key: "a"},
+				{key: "a", want: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, client := newRedisClient(t)
+			now := time.UnixMilli(1695571200000)
+			l := NewRedisLimiter(client, time.Second, 2, WithKeyPrefix("test:"))
+			l.timeFunc = func() time.Time { return now }
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				limited, err := l.Limit(context.Background(), s.key)
+				require.NoError(t, err)
+				assert.Equal(t, s.want, limited, "step %d", i)
+			}
+			assert.True(t, mr.Exists("test:a"))
+			assert.Equal(t, time.Second, mr.TTL("test:a"))
+		})
+	}
+}
+
+func TestRedisLimiter_LimitDecision(t *testing.T) {
+	_, client := newRedisClient(t)
+	now := time.UnixMilli(1695571200000)
+	l := NewRedisLimiter(client, time.Second, 2)
+	l.timeFunc = func() time.Time { return now }
+	steps := []struct {
+		advance time.Duration
+		want    ratelimit.Decision
+	}{
+		{want: ratelimit.Decision{Limit: 2, Remaining: 1, Window: time.Second, Reset: time.Second}},
+		{
+			advance: 400 * time.Millisecond,
+			want:    ratelimit.Decision{Limit: 2, Remaining: 0, Window: time.Second, Reset: time.Second},
+		},
+		{
+			advance: 400 * time.Millisecond,
+			want: ratelimit.Decision{Limited: true, Limit: 2, Remaining: 0, Window: time.Second,
+				Reset: 600 * time.Millisecond, RetryAfter: 200 * time.Millisecond},
+		},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		d, err := l.LimitDecision(context.Background(), "key")
+		require.NoError(t, err)
+		assert.Equal(t, s.want, d, "step %d", i)
+	}
+}
+
+func TestRedisLimiter_LimitN(t *testing.T) {
+	mr, client := newRedisClient(t)
+	now := time.UnixMilli(1695571200000)
+	l := NewRedisLimiter(client, time.Second, 5, WithKeyPrefix("test:"))
+	l.timeFunc = func() time.Time { return now }
+	steps := []struct {
+		advance time.Duration
+		n       int
+		want    ratelimit.Decision
+	}{
+		{n: 3, want: ratelimit.Decision{Limit: 5, Remaining: 2, Window: time.Second, Reset: time.Second}},
+		{
+			advance: 400 * time.Millisecond,
+			n:       2,
+			want:    ratelimit.Decision{Limit: 5, Remaining: 0, Window: time.Second, Reset: time.Second},
+		},
+		{
+			// 需要最早的 3 个请求滑出窗口
+			n: 3,
+			want: ratelimit.Decision{Limited: true, Limit: 5, Remaining: 0, Window: time.Second,
+				Reset: time.Second, RetryAfter: 600 * time.Millisecond},
+		},
+		{
+			// 超过阈值
+			advance: time.Second,
+			n:       6,
+			want:    ratelimit.Decision{Limited: true, Limit: 5, Remaining: 5, Window: time.Second},
+		},
+		{n: 5, want: ratelimit.Decision{Limit: 5, Remaining: 0, Window: time.Second, Reset: time.Second}},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		d, err := l.LimitN(context.Background(), "key", s.n)
+		require.NoError(t, err)
+		assert.Equal(t, s.want, d, "step %d", i)
+	}
+	members, err := mr.ZMembers("test:key")
+	require.NoError(t, err)
+	assert.Len(t, members, 5)
+}
+
+func TestRedisLimiter_Error(t *testing.T) {
+	mr, client := newRedisClient(t)
+	l := NewRedisLimiter(client, time.Second, 2)
+	mr.Close()
+
+	limited, err := l.Limit(context.Background(), "a")
+	assert.Error(t, err)
+	assert.False(t, limited)
+
+	// Redis 出现错误时中间件返回 500
+	server := gin.New()
+	server.Use(NewBuilder(l).Build())
+	server.GET("/limit", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req, err := http.NewRequest(http.MethodGet, "/limit", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestRedisLimiter_Concurrent(t *testing.T) {
+	_, client := newRedisClient(t)
+	// 两个实例共享同一个 Redis
+	limiters := []*RedisLimiter{
+		NewRedisLimiter(client, time.Hour, 50),
+		NewRedisLimiter(client, time.Hour, 50),
+	}
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(l *RedisLimiter) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				limited, err := l.Limit(context.Background(), "key")
+				assert.NoError(t, err)
+				if !limited {
+					allowed.Add(1)
+				}
+			}
+		}(limiters[i%2])
+	}
+	wg.Wait()
+	assert.Equal(t, int64(50), allowed.Load())
+}